@@ -0,0 +1,131 @@
+package componenttests
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	jose "gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+// devToken signs a token for the subject and roles with the development key, as token.sh does. The
+// service only accepts it when configured with the FACEIT_AUTH_* settings of docker-compose.yml
+func devToken(t *testing.T, subject string, roles ...string) string {
+	content, err := ioutil.ReadFile("../testdata/auth/dev-rs256.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(content)
+	if block == nil {
+		t.Fatal("invalid development key")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: key},
+		(&jose.SignerOptions{}).WithType("JWT").WithHeader(jose.HeaderKey("kid"), "faceit-dev"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	token, err := jwt.Signed(signer).Claims(jwt.Claims{
+		Issuer:   "https://auth.faceit.local/",
+		Audience: jwt.Audience{"faceit-users"},
+		Subject:  subject,
+		IssuedAt: jwt.NewNumericDate(now),
+		Expiry:   jwt.NewNumericDate(now.Add(time.Hour)),
+	}).Claims(map[string]interface{}{"roles": roles}).CompactSerialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+// bearerTransport adds a bearer token to each request
+type bearerTransport struct {
+	token string
+}
+
+func (b *bearerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+b.token)
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// newClient returns a client authenticated with an admin development token
+func newClient(t *testing.T) *http.Client {
+	return newClientAs(t, "component-tests", "admin")
+}
+
+// newClientAs returns a client authenticated with a development token of the subject and roles
+func newClientAs(t *testing.T, subject string, roles ...string) *http.Client {
+	return &http.Client{Transport: &bearerTransport{token: devToken(t, subject, roles...)}}
+}
+
+func TestAuthentication(t *testing.T) {
+	tests := []struct {
+		name   string
+		path   string
+		header string
+		code   int
+	}{
+		{name: "healthcheck is public", path: "/healthcheck", code: http.StatusOK},
+		{name: "docs are public", path: "/docs", code: http.StatusOK},
+		{name: "users require a token", path: "/users/testing", code: http.StatusUnauthorized},
+		{name: "search requires a token", path: "/users?country=NZL", code: http.StatusUnauthorized},
+		{name: "invalid token", path: "/users/testing", header: "Bearer not-a-token", code: http.StatusUnauthorized},
+		{name: "valid token", path: "/users/testing", header: "Bearer " + devToken(t, "testing", "user"), code: http.StatusOK},
+	}
+	for _, test := range tests {
+		tt := test
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, getHost()+tt.path, nil)
+			assert.Nil(t, err)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			res, err := http.DefaultClient.Do(req)
+			assert.Nil(t, err, "error making request")
+			assert.Equal(t, tt.code, res.StatusCode)
+			if tt.code == http.StatusUnauthorized {
+				assert.Contains(t, res.Header.Get("WWW-Authenticate"), "Bearer")
+			}
+		})
+	}
+}
+
+func TestAuthorization(t *testing.T) {
+	tests := []struct {
+		name   string
+		client *http.Client
+		method string
+		path   string
+		code   int
+	}{
+		{name: "user gets themselves", client: newClientAs(t, "testing", "user"), method: http.MethodGet, path: "/users/testing", code: http.StatusOK},
+		{name: "user gets another user", client: newClientAs(t, "other", "user"), method: http.MethodGet, path: "/users/testing", code: http.StatusForbidden},
+		{name: "user deletes another user", client: newClientAs(t, "other", "user"), method: http.MethodDelete, path: "/users/testing", code: http.StatusForbidden},
+		{name: "user searches", client: newClientAs(t, "testing", "user"), method: http.MethodGet, path: "/users?country=NZL", code: http.StatusForbidden},
+		{name: "user lists dead letters", client: newClientAs(t, "testing", "user"), method: http.MethodGet, path: "/admin/dead-letters", code: http.StatusForbidden},
+		{name: "service searches", client: newClientAs(t, "search", "service"), method: http.MethodGet, path: "/users?country=NZL", code: http.StatusOK},
+		{name: "service gets a user", client: newClientAs(t, "search", "service"), method: http.MethodGet, path: "/users/testing", code: http.StatusForbidden},
+		{name: "no roles", client: newClientAs(t, "testing"), method: http.MethodGet, path: "/users/testing", code: http.StatusForbidden},
+		{name: "admin gets any user", client: newClient(t), method: http.MethodGet, path: "/users/testing", code: http.StatusOK},
+	}
+	for _, test := range tests {
+		tt := test
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, getHost()+tt.path, nil)
+			assert.Nil(t, err)
+			res, err := tt.client.Do(req)
+			assert.Nil(t, err, "error making request")
+			assert.Equal(t, tt.code, res.StatusCode)
+		})
+	}
+}
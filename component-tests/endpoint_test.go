@@ -23,16 +23,15 @@ func TestGetUser(t *testing.T) {
 	expected := &model.User{
 		Id:       "testing",
 		Nickname: "lemming52",
-		Country:  "NZ",
+		Country:  "NZL",
 		Forename: "andrew",
 		Surname:  "s",
-		Password: "correcthorsebatterystaple",
 		Email:    "lemming52@github.com",
 	}
 
 	uri := fmt.Sprintf("%s/users/%s", getHost(), id)
 	req, err := http.NewRequest(http.MethodGet, uri, nil)
-	client := &http.Client{}
+	client := newClient(t)
 	res, err := client.Do(req)
 	assert.Nil(t, err, "error making request")
 	assert.Equal(t, codeWant, res.StatusCode)
@@ -43,8 +42,11 @@ func TestGetUser(t *testing.T) {
 	err = json.Unmarshal(body, results)
 	assert.Nil(t, err)
 
+	// the version of the seeded user depends on the store and earlier writes, e.g. password migration
+	expected.Version = results.Version
+	assert.Equal(t, fmt.Sprintf(`"%d"`, results.Version), res.Header.Get("ETag"))
 	if !reflect.DeepEqual(expected, results) {
-		t.Errorf("user should match %s %s", expected, results)
+		t.Errorf("user should match %v %v", expected, results)
 	}
 }
 
@@ -55,21 +57,20 @@ func TestAddUser(t *testing.T) {
 		Country:  "FRA",
 		Forename: "Richard",
 		Surname:  "Papillion",
-		Password: "vitality",
 		Email:    "rp@notarealemail.com",
 	}
 	payload := `{
 		"forename": "Richard",
 		"surname": "Papillion",
 		"nickname": "shox",
-		"password": "vitality",
+		"password": "vitality2020",
 		"email": "rp@notarealemail.com",
 		"country": "FRA"
 	}`
 	// Insert
 	uri := fmt.Sprintf("%s/users", getHost())
 	req, err := http.NewRequest(http.MethodPost, uri, strings.NewReader(payload))
-	client := &http.Client{}
+	client := newClient(t)
 	res, err := client.Do(req)
 	assert.Nil(t, err, "error making request")
 	assert.Equal(t, codeWant, res.StatusCode)
@@ -81,8 +82,9 @@ func TestAddUser(t *testing.T) {
 	assert.Nil(t, err)
 
 	expected.Id = results.Id
+	expected.Version = 1
 	if !reflect.DeepEqual(expected, results) {
-		t.Errorf("inserteduser should match %s %s", expected, results)
+		t.Errorf("inserteduser should match %v %v", expected, results)
 	}
 
 	// Check if stored using Get endpoint
@@ -99,7 +101,7 @@ func TestAddUser(t *testing.T) {
 	err = json.Unmarshal(body, results)
 	assert.Nil(t, err)
 	if !reflect.DeepEqual(expected, results) {
-		t.Errorf("retrieved user should match %s %s", expected, results)
+		t.Errorf("retrieved user should match %v %v", expected, results)
 	}
 
 	// Cleanup, implicitly test delete endpoint
@@ -115,7 +117,7 @@ func TestDelete(t *testing.T) {
 		"forename": "Gabriel",
 		"surname": "Toledo",
 		"nickname": "FalleN",
-		"password": "MIBR",
+		"password": "mibr2018",
 		"email": "gt@notarealemail.com",
 		"country": "BRA"
 	}`
@@ -123,7 +125,7 @@ func TestDelete(t *testing.T) {
 	// Seed, implicitly test insert
 	uri := fmt.Sprintf("%s/users", getHost())
 	req, err := http.NewRequest(http.MethodPost, uri, strings.NewReader(payload))
-	client := &http.Client{}
+	client := newClient(t)
 	res, err := client.Do(req)
 	assert.Nil(t, err, "error making request")
 
@@ -153,7 +155,7 @@ func TestUpdate(t *testing.T) {
 		"forename": "Jacky",
 		"surname": "Yip",
 		"nickname": "Stewie2K",
-		"password": "MIBR",
+		"password": "mibr2018",
 		"email": "jy@notarealemail.com",
 		"country": "USA"
 	}`
@@ -161,7 +163,7 @@ func TestUpdate(t *testing.T) {
 		"forename": "Jacky",
 		"surname": "Yip",
 		"nickname": "Stewie2K",
-		"password": "Liquid",
+		"password": "liquid2019",
 		"email": "jy@notarealemail.com",
 		"country": "USA"
 	}`
@@ -169,7 +171,6 @@ func TestUpdate(t *testing.T) {
 		Forename: "Jacky",
 		Surname:  "Yip",
 		Nickname: "Stewie2K",
-		Password: "Liquid",
 		Email:    "jy@notarealemail.com",
 		Country:  "USA",
 	}
@@ -177,7 +178,7 @@ func TestUpdate(t *testing.T) {
 	// Seed, implicitly test insert
 	uri := fmt.Sprintf("%s/users", getHost())
 	req, err := http.NewRequest(http.MethodPost, uri, strings.NewReader(payload))
-	client := &http.Client{}
+	client := newClient(t)
 	res, err := client.Do(req)
 	assert.Nil(t, err, "error making request")
 
@@ -187,15 +188,26 @@ func TestUpdate(t *testing.T) {
 	err = json.Unmarshal(body, results)
 	assert.Nil(t, err)
 	expected.Id = results.Id
+	expected.Version = 2
+	etag := res.Header.Get("ETag")
 
 	// Update
 	expectedCode := 200
 	uri = fmt.Sprintf("%s/users/%s", getHost(), expected.Id)
 	req, err = http.NewRequest(http.MethodPut, uri, strings.NewReader(updatePayload))
+	req.Header.Set("If-Match", etag)
 	res, err = client.Do(req)
 	assert.Nil(t, err, "error making request")
 	assert.Equal(t, expectedCode, res.StatusCode)
 
+	// Repeating the update with the original ETag is rejected, it has been overwritten
+	staleCode := 412
+	req, err = http.NewRequest(http.MethodPut, uri, strings.NewReader(updatePayload))
+	req.Header.Set("If-Match", etag)
+	res, err = client.Do(req)
+	assert.Nil(t, err, "error making request")
+	assert.Equal(t, staleCode, res.StatusCode)
+
 	// Check Updated
 	req, err = http.NewRequest(http.MethodGet, uri, nil)
 	res, err = client.Do(req)
@@ -209,7 +221,7 @@ func TestUpdate(t *testing.T) {
 	assert.Nil(t, err)
 
 	if !reflect.DeepEqual(expected, results) {
-		t.Errorf("user should match %s %s", expected, results)
+		t.Errorf("user should match %v %v", expected, results)
 	}
 
 	// Cleanup, implicitly test delete endpoint
@@ -227,7 +239,6 @@ func TestFilter(t *testing.T) {
 			Forename: "Nathan",
 			Surname:  "Schmitt",
 			Nickname: "NBK-",
-			Password: "og",
 			Email:    "ns@notarealemail.com",
 			Country:  "FRA",
 		},
@@ -239,7 +250,7 @@ func TestFilter(t *testing.T) {
 	q := req.URL.Query()
 	q.Add("country", "FRA")
 	req.URL.RawQuery = q.Encode()
-	client := &http.Client{}
+	client := newClient(t)
 	res, err := client.Do(req)
 	assert.Nil(t, err, "error making request")
 	assert.Equal(t, expectedCode, res.StatusCode)
@@ -251,6 +262,12 @@ func TestFilter(t *testing.T) {
 	assert.Nil(t, err)
 
 	assert.Equal(t, expectedCount, response.Count)
+	// seeded users are versioned by whichever store loaded them
+	for i, user := range response.Results {
+		if i < len(expected) {
+			expected[i].Version = user.Version
+		}
+	}
 	if !reflect.DeepEqual(expected, response.Results) {
 		t.Errorf("filtered results should match %v %v", expected[0], response.Results[0])
 	}
@@ -262,7 +279,7 @@ func TestGetAll(t *testing.T) {
 	expectedCode := 200
 	uri := fmt.Sprintf("%s/users", getHost())
 	req, err := http.NewRequest(http.MethodGet, uri, nil)
-	client := &http.Client{}
+	client := newClient(t)
 	res, err := client.Do(req)
 	assert.Nil(t, err, "error making request")
 	assert.Equal(t, expectedCode, res.StatusCode)
@@ -274,3 +291,172 @@ func TestGetAll(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, expectedCount, response.Count)
 }
+
+func TestGetAllPaginated(t *testing.T) {
+	expectedCount := 5
+	expectedCode := 200
+	client := newClient(t)
+	seen := map[string]bool{}
+	cursor := ""
+	for i := 0; i < expectedCount; i++ {
+		uri := fmt.Sprintf("%s/users", getHost())
+		req, err := http.NewRequest(http.MethodGet, uri, nil)
+		q := req.URL.Query()
+		q.Add("limit", "2")
+		if cursor != "" {
+			q.Add("cursor", cursor)
+		}
+		req.URL.RawQuery = q.Encode()
+		res, err := client.Do(req)
+		assert.Nil(t, err, "error making request")
+		assert.Equal(t, expectedCode, res.StatusCode)
+
+		body, err := ioutil.ReadAll(res.Body)
+		assert.Nil(t, err)
+		response := &model.FilterResponse{}
+		err = json.Unmarshal(body, response)
+		assert.Nil(t, err)
+		for _, user := range response.Results {
+			seen[user.Id] = true
+		}
+		cursor = response.NextCursor
+		if cursor == "" {
+			break
+		}
+	}
+	assert.Equal(t, expectedCount, len(seen))
+}
+
+func TestVerifyPassword(t *testing.T) {
+	// The seeded user predates hashing, so the first check also migrates the stored password
+	id := "testing"
+	client := newClient(t)
+	uri := fmt.Sprintf("%s/users/%s/verify-password", getHost(), id)
+	tests := []struct {
+		name     string
+		payload  string
+		codeWant int
+	}{
+		{
+			name:     "correct",
+			payload:  `{"password": "correcthorsebatterystaple"}`,
+			codeWant: 200,
+		}, {
+			name:     "correct after migration",
+			payload:  `{"password": "correcthorsebatterystaple"}`,
+			codeWant: 200,
+		}, {
+			name:     "incorrect",
+			payload:  `{"password": "incorrecthorsebatterystaple"}`,
+			codeWant: 401,
+		},
+	}
+	for _, tt := range tests {
+		req, err := http.NewRequest(http.MethodPost, uri, strings.NewReader(tt.payload))
+		res, err := client.Do(req)
+		assert.Nil(t, err, "error making request")
+		assert.Equal(t, tt.codeWant, res.StatusCode, tt.name)
+	}
+}
+
+func TestAddUserConflict(t *testing.T) {
+	// The seeded user NBK- already holds this nickname, uniqueness ignores case
+	codeWant := 409
+	payload := `{
+		"forename": "Nathan",
+		"surname": "Schmitt",
+		"nickname": "nbk-",
+		"password": "og2020cs",
+		"email": "nathan@notarealemail.com",
+		"country": "FRA"
+	}`
+	uri := fmt.Sprintf("%s/users", getHost())
+	req, err := http.NewRequest(http.MethodPost, uri, strings.NewReader(payload))
+	client := newClient(t)
+	res, err := client.Do(req)
+	assert.Nil(t, err, "error making request")
+	assert.Equal(t, codeWant, res.StatusCode)
+}
+
+func TestPatch(t *testing.T) {
+	payload := `{
+		"forename": "Peter",
+		"surname": "Rasmussen",
+		"nickname": "dupreeh",
+		"password": "astralis2018",
+		"email": "pr@notarealemail.com",
+		"country": "DNK"
+	}`
+	expected := &model.User{
+		Forename: "Peter",
+		Surname:  "Rasmussen",
+		Nickname: "dupreeh",
+		Email:    "pr@notarealemail.com",
+		Country:  "FRA",
+		Version:  3,
+	}
+
+	// Seed, implicitly test insert
+	uri := fmt.Sprintf("%s/users", getHost())
+	req, err := http.NewRequest(http.MethodPost, uri, strings.NewReader(payload))
+	client := newClient(t)
+	res, err := client.Do(req)
+	assert.Nil(t, err, "error making request")
+
+	body, err := ioutil.ReadAll(res.Body)
+	assert.Nil(t, err)
+	results := &model.User{}
+	err = json.Unmarshal(body, results)
+	assert.Nil(t, err)
+	expected.Id = results.Id
+	uri = fmt.Sprintf("%s/users/%s", getHost(), expected.Id)
+
+	// Patch, each format changes a single field and leaves the rest untouched
+	patches := []struct {
+		contentType string
+		patch       string
+	}{
+		{
+			contentType: "application/merge-patch+json",
+			patch:       `{"country": "FRA"}`,
+		}, {
+			contentType: "application/json-patch+json",
+			patch:       `[{"op": "replace", "path": "/password", "value": "vitality2021"}]`,
+		},
+	}
+	expectedCode := 200
+	for _, p := range patches {
+		req, err = http.NewRequest(http.MethodPatch, uri, strings.NewReader(p.patch))
+		req.Header.Set("Content-Type", p.contentType)
+		res, err = client.Do(req)
+		assert.Nil(t, err, "error making request")
+		assert.Equal(t, expectedCode, res.StatusCode)
+	}
+
+	// Check Patched
+	req, err = http.NewRequest(http.MethodGet, uri, nil)
+	res, err = client.Do(req)
+	assert.Nil(t, err, "error making request")
+	assert.Equal(t, expectedCode, res.StatusCode)
+
+	body, err = ioutil.ReadAll(res.Body)
+	assert.Nil(t, err)
+	results = &model.User{}
+	err = json.Unmarshal(body, results)
+	assert.Nil(t, err)
+	if !reflect.DeepEqual(expected, results) {
+		t.Errorf("user should match %v %v", expected, results)
+	}
+
+	req, err = http.NewRequest(http.MethodPost, uri+"/verify-password", strings.NewReader(`{"password": "vitality2021"}`))
+	res, err = client.Do(req)
+	assert.Nil(t, err, "error making request")
+	assert.Equal(t, expectedCode, res.StatusCode)
+
+	// Cleanup, implicitly test delete endpoint
+	deleteCode := 204
+	req, err = http.NewRequest(http.MethodDelete, uri, nil)
+	res, err = client.Do(req)
+	assert.Nil(t, err, "error making delete request")
+	assert.Equal(t, deleteCode, res.StatusCode)
+}
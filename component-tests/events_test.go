@@ -0,0 +1,119 @@
+package componenttests
+
+import (
+	"encoding/json"
+	"faceit/model"
+	"faceit/service/publisher"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// sink receives the events of a service run with the webhook publisher, e.g.
+// -publisher webhook -webhook-urls http://localhost:3001/events -webhook-secret secret
+// It is started when WEBHOOK_SINK holds its address, and checks requests are signed with WEBHOOK_SECRET
+var sink = &webhookSink{}
+
+type webhookSink struct {
+	mu     sync.Mutex
+	events []*publisher.CloudEvent
+	secret []byte
+}
+
+func (s *webhookSink) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if publisher.Sign(s.secret, r.Header.Get(publisher.TimestampHeader), body) != r.Header.Get(publisher.SignatureHeader) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	event := &publisher.CloudEvent{}
+	err = json.Unmarshal(body, event)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	s.events = append(s.events, event)
+	s.mu.Unlock()
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// await waits for an event of the given type about a user
+func (s *webhookSink) await(t *testing.T, eventType, id string) *publisher.CloudEvent {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		s.mu.Lock()
+		for _, event := range s.events {
+			if event.Type == eventType && event.Subject == id {
+				s.mu.Unlock()
+				return event
+			}
+		}
+		s.mu.Unlock()
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatalf("no %s event received for %s", eventType, id)
+	return nil
+}
+
+func TestMain(m *testing.M) {
+	address := os.Getenv("WEBHOOK_SINK")
+	if address != "" {
+		sink.secret = []byte(os.Getenv("WEBHOOK_SECRET"))
+		// started before any test, so the first events of the run are not held back by failures
+		go func() {
+			err := http.ListenAndServe(address, sink)
+			fmt.Println("webhook sink stopped:", err)
+		}()
+	}
+	os.Exit(m.Run())
+}
+
+func TestWebhookEmitted(t *testing.T) {
+	if os.Getenv("WEBHOOK_SINK") == "" {
+		t.Skip("WEBHOOK_SINK is not set")
+	}
+	payload := `{
+		"forename": "Ladislav",
+		"surname": "Kovacs",
+		"nickname": "GuardiaN",
+		"password": "faze2018x",
+		"email": "lk@notarealemail.com",
+		"country": "SVK"
+	}`
+	uri := fmt.Sprintf("%s/users", getHost())
+	req, err := http.NewRequest(http.MethodPost, uri, strings.NewReader(payload))
+	client := newClient(t)
+	res, err := client.Do(req)
+	assert.Nil(t, err, "error making request")
+	assert.Equal(t, 201, res.StatusCode)
+	body, err := ioutil.ReadAll(res.Body)
+	assert.Nil(t, err)
+	user := &model.User{}
+	assert.Nil(t, json.Unmarshal(body, user))
+
+	created := sink.await(t, publisher.TypeUserCreated, user.Id)
+	assert.Equal(t, model.UserAdd, created.Data.Action)
+	assert.Equal(t, "GuardiaN", created.Data.After.Nickname)
+
+	uri = fmt.Sprintf("%s/users/%s", getHost(), user.Id)
+	req, err = http.NewRequest(http.MethodDelete, uri, nil)
+	res, err = client.Do(req)
+	assert.Nil(t, err, "error making delete request")
+	assert.Equal(t, 204, res.StatusCode)
+
+	deleted := sink.await(t, publisher.TypeUserDeleted, user.Id)
+	assert.Equal(t, "SVK", deleted.Data.Before.Country)
+	assert.Nil(t, deleted.Data.After)
+}
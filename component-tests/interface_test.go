@@ -3,6 +3,7 @@ package componenttests
 import (
 	"encoding/json"
 	"faceit/model"
+	"faceit/service/publisher"
 	"fmt"
 	"io/ioutil"
 	"net/http"
@@ -56,7 +57,7 @@ func TestSNSEmitted(t *testing.T) {
 		"forename": "Jacky",
 		"surname": "Yip",
 		"nickname": "Stewie2K",
-		"password": "MIBR",
+		"password": "mibr2018",
 		"email": "jy@notarealemail.com",
 		"country": "USA"
 	}`
@@ -67,7 +68,7 @@ func TestSNSEmitted(t *testing.T) {
 	// Send Message by adding user
 	uri := fmt.Sprintf("%s/users", getHost())
 	req, err := http.NewRequest(http.MethodPost, uri, strings.NewReader(payload))
-	client := &http.Client{}
+	client := newClient(t)
 	res, err := client.Do(req)
 	assert.Nil(t, err, "error making request")
 
@@ -86,11 +87,13 @@ func TestSNSEmitted(t *testing.T) {
 	assert.Equal(t, expectedMessages, len(result.Messages))
 	defer svc.deleteMessage(t, result.Messages[0].ReceiptHandle)
 
-	message := &model.Message{}
-	err = json.Unmarshal([]byte(*result.Messages[0].Body), message)
+	event := &publisher.CloudEvent{}
+	err = json.Unmarshal([]byte(*result.Messages[0].Body), event)
 	assert.Nil(t, err)
-	assert.Equal(t, expectedAction, message.Action)
-	assert.Equal(t, id, message.Id)
+	assert.Equal(t, expectedAction, event.Data.Action)
+	assert.Equal(t, id, event.Data.Id)
+	assert.Equal(t, publisher.TypeUserCreated, *result.Messages[0].MessageAttributes[publisher.AttributeEventType].StringValue)
+	assert.Equal(t, "USA", *result.Messages[0].MessageAttributes[publisher.AttributeCountry].StringValue)
 
 	// Cleanup
 	deleteCode := 204
@@ -0,0 +1,388 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writeConfig writes a config file to a temporary directory, removed by the returned func
+func writeConfig(t *testing.T, name, content string) (string, func()) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, name)
+	err = ioutil.WriteFile(path, []byte(content), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path, func() { os.RemoveAll(dir) }
+}
+
+// setEnv sets environment variables, restored by the returned func
+func setEnv(env map[string]string) func() {
+	previous := map[string]*string{}
+	for key, value := range env {
+		if old, ok := os.LookupEnv(key); ok {
+			previous[key] = &old
+		} else {
+			previous[key] = nil
+		}
+		os.Setenv(key, value)
+	}
+	return func() {
+		for key, old := range previous {
+			if old == nil {
+				os.Unsetenv(key)
+			} else {
+				os.Setenv(key, *old)
+			}
+		}
+	}
+}
+
+// devAuthEnv configures authentication with the development key, as docker-compose does
+var devAuthEnv = map[string]string{
+	"FACEIT_AUTH_JWKS":     "testdata/auth/jwks.json",
+	"FACEIT_AUTH_ISSUER":   "https://auth.faceit.local/",
+	"FACEIT_AUTH_AUDIENCE": "faceit-users",
+}
+
+// withDevAuth sets the authentication of devAuthEnv on a config
+func withDevAuth(c *Config) *Config {
+	c.Auth.JWKS = devAuthEnv["FACEIT_AUTH_JWKS"]
+	c.Auth.Issuer = devAuthEnv["FACEIT_AUTH_ISSUER"]
+	c.Auth.Audience = devAuthEnv["FACEIT_AUTH_AUDIENCE"]
+	return c
+}
+
+func TestLoadDefaults(t *testing.T) {
+	// no key is trusted unless one is configured
+	_, err := Load("faceit", nil)
+	if assert.IsType(t, &ValidationError{}, err) {
+		assert.Equal(t, []string{"authentication requires a jwks, issuer and audience"}, err.(*ValidationError).Problems)
+	}
+
+	defer setEnv(devAuthEnv)()
+	cfg, err := Load("faceit", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, withDevAuth(Default()), cfg)
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	path, remove := writeConfig(t, "faceit.yaml", `
+host: 0.0.0.0:4000
+storage: memory
+aws:
+  region: us-east-1
+sns:
+  topicArn: arn:aws:sns:us-east-1:123456789012:file
+kafka:
+  brokers: [kafka-1:9092, kafka-2:9092]
+outbox:
+  interval: 1m
+shutdown:
+  delay: 2s
+health:
+  cacheTTL: 0s
+tracing:
+  exporter: otlp
+accessLog:
+  format: combined
+  trustForwarded: false
+auth:
+  jwks: testdata/auth/jwks.json
+  issuer: https://auth.faceit.local/
+  audience: faceit-users
+`)
+	defer remove()
+	defer setEnv(map[string]string{
+		"FACEIT_CONFIG":                  path,
+		"FACEIT_AWS_REGION":              "eu-central-1",
+		"FACEIT_SNS_TOPIC_ARN":           "arn:aws:sns:eu-central-1:123456789012:env",
+		"FACEIT_AWS_ENDPOINT":            "",
+		"FACEIT_ACCESS_LOG_SAMPLE_RATIO": "0.1",
+	})()
+
+	cfg, err := Load("faceit", []string{"-sns-topic-arn", "arn:aws:sns:eu-central-1:123456789012:flag", "-publisher", "sns, kafka", "-tracing-sample-ratio", "0.25", "-access-log-trust-forwarded"})
+	assert.NoError(t, err)
+
+	// the file overrides the defaults
+	assert.Equal(t, "0.0.0.0:4000", cfg.Host)
+	assert.Equal(t, MemoryStorage, cfg.Storage)
+	assert.Equal(t, []string{"kafka-1:9092", "kafka-2:9092"}, cfg.Kafka.Brokers)
+	assert.Equal(t, time.Minute, cfg.Outbox.Interval)
+	assert.Equal(t, Shutdown{Timeout: Default().Shutdown.Timeout, Delay: 2 * time.Second}, cfg.Shutdown)
+	assert.Equal(t, Health{Timeout: Default().Health.Timeout}, cfg.Health)
+	// the environment overrides the file, an empty variable clears a default
+	assert.Equal(t, "eu-central-1", cfg.AWS.Region)
+	assert.Equal(t, "", cfg.AWS.Endpoint)
+	// flags override the environment
+	assert.Equal(t, "arn:aws:sns:eu-central-1:123456789012:flag", cfg.SNS.TopicArn)
+	assert.Equal(t, []string{SNSPublisher, KafkaPublisher}, cfg.Publishers)
+	assert.Equal(t, Tracing{Exporter: "otlp", Endpoint: Default().Tracing.Endpoint, SampleRatio: 0.25}, cfg.Tracing)
+	assert.Equal(t, AccessLog{Format: "combined", SampleRatio: 0.1, TrustForwarded: true}, cfg.AccessLog)
+	// untouched settings keep their defaults
+	assert.Equal(t, Default().Dynamo, cfg.Dynamo)
+}
+
+func TestLoadFile(t *testing.T) {
+	tests := []struct {
+		name     string
+		file     string
+		content  string
+		expected func(*Config)
+		err      string
+	}{
+		{
+			name:    "yaml",
+			file:    "faceit.yaml",
+			content: "storage: memory\nwebhook:\n  urls: [http://sink/events]\n  secret: s3cret\npublishers: [webhook]\n",
+			expected: func(c *Config) {
+				c.Storage = MemoryStorage
+				c.Publishers = []string{WebhookPublisher}
+				c.Webhook = Webhook{URLs: []string{"http://sink/events"}, Secret: "s3cret"}
+			},
+		},
+		{
+			name:    "json",
+			file:    "faceit.json",
+			content: `{"storage": "sql", "sql": {"driver": "sqlite3", "dsn": "file:faceit.db"}, "outbox": {"interval": 1000000}}`,
+			expected: func(c *Config) {
+				c.Storage = SQLStorage
+				c.SQL = SQL{Driver: "sqlite3", DSN: "file:faceit.db"}
+				c.Outbox.Interval = time.Millisecond
+			},
+		},
+		{
+			name:    "unknown key",
+			file:    "faceit.yaml",
+			content: "storage: memory\nstorge: sql\n",
+			err:     "field storge not found",
+		},
+		{
+			name:    "invalid duration",
+			file:    "faceit.yaml",
+			content: "outbox:\n  interval: soon\n",
+			err:     "invalid outbox interval",
+		},
+	}
+	for _, test := range tests {
+		tt := test
+		t.Run(tt.name, func(t *testing.T) {
+			path, remove := writeConfig(t, tt.file, tt.content)
+			defer remove()
+			defer setEnv(devAuthEnv)()
+
+			cfg, err := Load("faceit", []string{"-config", path})
+			if tt.err != "" {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), tt.err)
+				return
+			}
+			assert.NoError(t, err)
+			expected := withDevAuth(Default())
+			tt.expected(expected)
+			assert.Equal(t, expected, cfg)
+		})
+	}
+}
+
+func TestLoadExample(t *testing.T) {
+	cfg, err := Load("faceit", []string{"-config", "example.yaml"})
+	assert.NoError(t, err)
+	assert.Equal(t, "", cfg.AWS.Endpoint)
+	assert.Equal(t, []string{SNSPublisher, WebhookPublisher}, cfg.Publishers)
+	assert.Equal(t, "otlp", cfg.Tracing.Exporter)
+	assert.Equal(t, "https://login.example.com/.well-known/jwks.json", cfg.Auth.JWKS)
+	assert.Equal(t, "/etc/faceit/policy.yaml", cfg.Auth.Policy)
+}
+
+func TestLoadInvalidEnv(t *testing.T) {
+	defer setEnv(map[string]string{"FACEIT_OUTBOX_INTERVAL": "often"})()
+
+	_, err := Load("faceit", nil)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "FACEIT_OUTBOX_INTERVAL")
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		update   func(*Config)
+		problems []string
+	}{
+		{
+			name:   "default with authentication",
+			update: func(c *Config) {},
+		},
+		{
+			name: "default",
+			update: func(c *Config) {
+				c.Auth = Default().Auth
+			},
+			problems: []string{"authentication requires a jwks, issuer and audience"},
+		},
+		{
+			name: "bad host and storage",
+			update: func(c *Config) {
+				c.Host = "3000"
+				c.Storage = "mongo"
+			},
+			problems: []string{`host "3000" is not a host:port address`, "unknown storage backend: mongo"},
+		},
+		{
+			name: "sql without dsn",
+			update: func(c *Config) {
+				c.Storage = SQLStorage
+				c.SQL.Driver = "mysql"
+			},
+			problems: []string{"unknown sql driver: mysql", "the sql storage requires a dsn"},
+		},
+		{
+			name: "seeded dynamo",
+			update: func(c *Config) {
+				c.Seed = "testdata/users.json"
+			},
+			problems: []string{"the dynamo storage cannot be seeded, use localstack.sh"},
+		},
+		{
+			name: "publishers",
+			update: func(c *Config) {
+				c.Storage = MemoryStorage
+				c.Publishers = []string{WebhookPublisher, KafkaPublisher, "carrier-pigeon", KafkaPublisher}
+				c.Kafka.Topic = ""
+			},
+			problems: []string{
+				"the webhook publisher requires at least one url",
+				"the webhook publisher requires a secret to sign requests",
+				"the kafka publisher requires brokers and a topic",
+				"unknown publisher: carrier-pigeon",
+				"publisher kafka is listed more than once",
+				"the kafka publisher requires brokers and a topic",
+			},
+		},
+		{
+			name: "no publishers",
+			update: func(c *Config) {
+				c.Publishers = nil
+			},
+			problems: []string{"at least one publisher is required"},
+		},
+		{
+			name: "aws without region",
+			update: func(c *Config) {
+				c.AWS.Region = ""
+				c.SNS.TopicArn = ""
+			},
+			problems: []string{"the sns publisher requires a topic arn", "the dynamo storage and sns publisher require an aws region"},
+		},
+		{
+			name: "region not needed",
+			update: func(c *Config) {
+				c.AWS.Region = ""
+				c.Storage = MemoryStorage
+				c.Publishers = []string{NATSPublisher}
+			},
+		},
+		{
+			name: "dead letters",
+			update: func(c *Config) {
+				c.DeadLetters.Storage = SQLDeadLetters
+			},
+			problems: []string{"the sql dead letter storage requires the sql storage"},
+		},
+		{
+			name: "durations",
+			update: func(c *Config) {
+				c.Outbox.Interval = 0
+				c.Shutdown.Timeout = 0
+				c.Shutdown.Delay = -time.Second
+				c.Health.Timeout = 0
+				c.Health.CacheTTL = -time.Second
+			},
+			problems: []string{
+				"the outbox interval must be positive",
+				"the shutdown timeout must be positive",
+				"the shutdown delay cannot be negative",
+				"the health check timeout must be positive",
+				"the health check cache ttl cannot be negative",
+			},
+		},
+		{
+			name: "tracing",
+			update: func(c *Config) {
+				c.Tracing.Exporter = "jaeger"
+				c.Tracing.SampleRatio = 1.5
+			},
+			problems: []string{"unknown trace exporter: jaeger", "the trace sample ratio must be between 0 and 1"},
+		},
+		{
+			name: "otlp without endpoint",
+			update: func(c *Config) {
+				c.Tracing.Exporter = "otlp"
+				c.Tracing.Endpoint = ""
+			},
+			problems: []string{"the otlp trace exporter requires an endpoint"},
+		},
+		{
+			name: "access log",
+			update: func(c *Config) {
+				c.AccessLog.Format = "common"
+				c.AccessLog.SampleRatio = -0.5
+			},
+			problems: []string{"unknown access log format: common", "the access log sample ratio must be between 0 and 1"},
+		},
+		{
+			name: "auth",
+			update: func(c *Config) {
+				c.Auth.JWKS = ""
+			},
+			problems: []string{"authentication requires a jwks, issuer and audience"},
+		},
+		{
+			name: "policy",
+			update: func(c *Config) {
+				c.Auth.Policy = ""
+			},
+			problems: []string{"authorization requires a policy"},
+		},
+	}
+	for _, test := range tests {
+		tt := test
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := withDevAuth(Default())
+			tt.update(cfg)
+			err := cfg.Validate()
+			if tt.problems == nil {
+				assert.NoError(t, err)
+				return
+			}
+			if assert.IsType(t, &ValidationError{}, err) {
+				assert.Equal(t, tt.problems, err.(*ValidationError).Problems)
+			}
+		})
+	}
+}
+
+func TestEnvName(t *testing.T) {
+	assert.Equal(t, "FACEIT_AWS_REGION", EnvName("aws-region"))
+	assert.Equal(t, "FACEIT_CONFIG", EnvName(FileFlag))
+}
+
+func TestSession(t *testing.T) {
+	sess, err := AWS{Region: "eu-west-1", Endpoint: "http://localstack:4566"}.Session()
+	assert.NoError(t, err)
+	assert.Equal(t, "eu-west-1", *sess.Config.Region)
+	assert.Equal(t, "http://localstack:4566", *sess.Config.Endpoint)
+	assert.True(t, *sess.Config.DisableSSL)
+
+	sess, err = AWS{Region: "eu-west-1"}.Session()
+	assert.NoError(t, err)
+	assert.Nil(t, sess.Config.Endpoint)
+}
@@ -1,11 +1,28 @@
 module faceit
 
-go 1.13
+go 1.16
 
 require (
 	github.com/aws/aws-sdk-go v1.36.19
+	github.com/evanphx/json-patch v4.9.0+incompatible
 	github.com/google/uuid v1.1.3
 	github.com/gorilla/mux v1.8.0
+	github.com/lib/pq v1.9.0
+	github.com/mattn/go-sqlite3 v1.14.6
+	github.com/nats-io/nats-server/v2 v2.2.6
+	github.com/nats-io/nats.go v1.11.0
+	github.com/prometheus/client_golang v1.9.0
+	github.com/segmentio/kafka-go v0.4.10
 	github.com/sirupsen/logrus v1.7.0
-	github.com/stretchr/testify v1.2.2
+	github.com/stretchr/testify v1.7.0
+	go.opentelemetry.io/contrib/instrumentation/github.com/gorilla/mux/otelmux v0.24.0
+	go.opentelemetry.io/otel v1.0.0
+	go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp v1.0.0
+	go.opentelemetry.io/otel/exporters/stdout/stdouttrace v1.0.0
+	go.opentelemetry.io/otel/sdk v1.0.0
+	go.opentelemetry.io/otel/trace v1.0.0
+	golang.org/x/crypto v0.0.0-20210314154223-e6e6c4f2bb5b
+	golang.org/x/time v0.0.0-20201208040808-7e3f01d25324
+	gopkg.in/square/go-jose.v2 v2.5.1
+	gopkg.in/yaml.v2 v2.4.0
 )
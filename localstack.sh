@@ -10,6 +10,23 @@ aws dynamodb create-table \
 --key-schema AttributeName=userId,KeyType=HASH \
 --provisioned-throughput ReadCapacityUnits=5,WriteCapacityUnits=5
 
+aws dynamodb create-table \
+--endpoint-url=http://localhost:4566 \
+--region eu-west-1 \
+--table-name faceit-users-unique \
+--attribute-definitions AttributeName=uniqueKey,AttributeType=S \
+--key-schema AttributeName=uniqueKey,KeyType=HASH \
+--provisioned-throughput ReadCapacityUnits=5,WriteCapacityUnits=5
+
+aws dynamodb create-table \
+--endpoint-url=http://localhost:4566 \
+--region eu-west-1 \
+--table-name faceit-users-outbox \
+--attribute-definitions AttributeName=eventId,AttributeType=S AttributeName=pending,AttributeType=S AttributeName=created,AttributeType=S \
+--key-schema AttributeName=eventId,KeyType=HASH \
+--global-secondary-indexes 'IndexName=pending-index,KeySchema=[{AttributeName=pending,KeyType=HASH},{AttributeName=created,KeyType=RANGE}],Projection={ProjectionType=ALL},ProvisionedThroughput={ReadCapacityUnits=5,WriteCapacityUnits=5}' \
+--provisioned-throughput ReadCapacityUnits=5,WriteCapacityUnits=5
+
 aws dynamodb batch-write-item \
 --endpoint-url=http://localhost:4566 \
 --region eu-west-1 \
@@ -24,3 +41,12 @@ aws --endpoint-url=http://localhost:4566 sns subscribe \
 --protocol sqs \
 --notification-endpoint http://localhost:4566/queue/user_messages \
 --attributes RawMessageDelivery=true
+
+# Only receives deletions of Danish users, filtered by SNS on the message attributes
+aws sqs create-queue --endpoint-url=http://localhost:4566 --queue-name user_deletions_dnk
+
+aws --endpoint-url=http://localhost:4566 sns subscribe \
+--topic-arn arn:aws:sns:eu-west-1:000000000000:messages_sns \
+--protocol sqs \
+--notification-endpoint http://localhost:4566/queue/user_deletions_dnk \
+--attributes '{"RawMessageDelivery":"true","FilterPolicy":"{\"eventType\":[\"com.faceit.user.deleted\"],\"country\":[\"DNK\"]}"}'
@@ -1,15 +1,37 @@
 package main
 
 import (
+	"context"
+	"fmt"
+	"io"
 	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
+	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/gorilla/mux"
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
 	"github.com/sirupsen/logrus"
 	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gorilla/mux/otelmux"
 
+	"faceit/config"
+	"faceit/service/accesslog"
+	"faceit/service/auth"
 	"faceit/service/dao"
 	"faceit/service/handlers"
+	"faceit/service/health"
+	"faceit/service/logging"
+	"faceit/service/metrics"
+	"faceit/service/outbox"
+	"faceit/service/policy"
 	"faceit/service/publisher"
+	"faceit/service/replay"
+	"faceit/service/tracing"
 )
 
 const (
@@ -25,48 +47,394 @@ const (
 	// SingleUserURI is the address for any operation on a given user ID
 	SingleUserURI = "/users/{id}"
 
+	// VerifyPasswordURI is the address to check a password for a given user ID
+	VerifyPasswordURI = "/users/{id}/verify-password"
+
 	// HealthCheckURI is the uri for the basic status endpoint
 	HealthCheckURI = "/healthcheck"
 
+	// LivenessURI reports the process is serving
+	LivenessURI = "/livez"
+
+	// ReadinessURI reports whether the dependencies are reachable
+	ReadinessURI = "/readyz"
+
+	// MetricsURI serves the Prometheus metrics
+	MetricsURI = "/metrics"
+
 	// DocsURI is the endpoint for the prerendered documentation
 	DocsURI = "/docs"
 
-	// Host is the hardcoded local host for the service
-	// Note that changing the port will require alterations to the dockerfile and docker-compose
-	Host = "0.0.0.0:3000"
+	// DeadLettersURI lists the messages that could not be published
+	DeadLettersURI = "/admin/dead-letters"
+
+	// ReplayDeadLetterURI publishes a dead letter again
+	ReplayDeadLetterURI = "/admin/dead-letters/{id}/replay"
+
+	// ReplayURI starts a replay of user snapshots
+	ReplayURI = "/admin/events/replay"
+
+	// ReplayJobURI reports the progress of a replay
+	ReplayJobURI = "/admin/events/replay/{id}"
 )
 
+// Storage is a user store which also holds the outbox of messages written with each change
+type Storage interface {
+	handlers.DAOClient
+	outbox.Store
+}
+
 func main() {
 	log.SetFormatter(&logrus.JSONFormatter{})
+	log.AddHook(logging.RedactHook{})
 
-	log.Info("start server")
+	cfg, err := config.Load(os.Args[0], os.Args[1:])
+	if err != nil {
+		log.WithField("error", err).Fatal("unable to load configuration")
+	}
+	log.WithFields(log.Fields{
+		"host":        cfg.Host,
+		"storage":     cfg.Storage,
+		"publishers":  cfg.Publishers,
+		"deadLetters": cfg.DeadLetters.Storage,
+		"tracing":     cfg.Tracing.Exporter,
+	}).Info("start server")
+	shutdownTracing, err := tracing.Setup(context.Background(), tracing.Options{
+		Service:     Service,
+		Version:     Version,
+		Exporter:    cfg.Tracing.Exporter,
+		Endpoint:    cfg.Tracing.Endpoint,
+		SampleRatio: cfg.Tracing.SampleRatio,
+	})
+	if err != nil {
+		log.WithField("error", err).Fatal("unable to set up tracing")
+	}
 	r := mux.NewRouter()
+	r.Use(otelmux.Middleware(Service), logging.Middleware(log.StandardLogger()))
+
+	sess, err := cfg.AWS.Session()
+	if err != nil {
+		log.WithField("error", err).Fatal("unable to create aws session")
+	}
+	db, err := getDatabase(cfg, sess)
+	if err != nil {
+		log.WithField("error", err).Fatal("unable to create storage client")
+	}
+	dead, err := getDeadLetters(cfg, db)
+	if err != nil {
+		log.WithField("error", err).Fatal("unable to create dead letter storage")
+	}
+	targets, err := getTargets(cfg, sess)
+	if err != nil {
+		log.WithField("error", err).Fatal("unable to create publisher")
+	}
+	keys := auth.NewKeySet(cfg.Auth.JWKS)
+	err = keys.Load(context.Background())
+	if err != nil {
+		log.WithField("error", err).Fatal("unable to load jwks")
+	}
+	authn := auth.NewAuthenticator(keys, cfg.Auth.Issuer, cfg.Auth.Audience)
+	rules, err := policy.Load(cfg.Auth.Policy)
+	if err != nil {
+		log.WithField("error", err).Fatal("unable to load policy")
+	}
+	probe := health.NewProbe(cfg.Health.Timeout, cfg.Health.CacheTTL, getChecks(cfg, db, targets)...)
 
-	db := getDatabase()
-	msg := getPublisher()
+	// checks and dead letters use the clients directly, everything else is instrumented
+	m := metrics.New()
+	for _, target := range targets {
+		traced := tracing.NewPublisher(target.Name, getDestination(cfg, target.Name), target.Client)
+		target.Client = m.Publisher(target.Name, traced)
+	}
+	msg := publisher.NewFanOut(dead, targets...)
+	if counter, ok := db.(metrics.PendingCounter); ok {
+		m.RegisterOutbox(counter)
+	}
+	m.RegisterDeadLetters(dead)
+	store := m.DAO(traceDatabase(cfg, db))
+
+	relay := outbox.NewRelay(store, msg, cfg.Outbox.Interval)
+	relayCtx, cancelRelay := context.WithCancel(context.Background())
+	relayDone := make(chan struct{})
+	go func() {
+		relay.Run(relayCtx)
+		close(relayDone)
+	}()
+	stopRelay := func() {
+		cancelRelay()
+		<-relayDone
+	}
 
-	h := handlers.NewHandler(db, msg)
+	lifecycle := &handlers.Lifecycle{}
+	h := handlers.NewHandler(store, relay)
+	replayCtx, stopReplays := context.WithCancel(context.Background())
+	replays := replay.NewReplayer(replayCtx, store, msg)
+	admin := handlers.NewAdminHandler(msg, replays)
+	// counted ahead of the api middleware, so the requests it refuses are counted too
+	r.Use(m.Middleware)
 	r.HandleFunc(DocsURI, handlers.GetDocHandler(handlers.DocPath)).Methods(http.MethodGet)
-	r.HandleFunc(HealthCheckURI, handlers.GetHealthCheckHandler(Service, Version))
+	r.HandleFunc(HealthCheckURI, handlers.GetHealthCheckHandler(Service, Version, lifecycle))
+	r.HandleFunc(LivenessURI, handlers.GetLivenessHandler(Service, Version)).Methods(http.MethodGet)
+	r.HandleFunc(ReadinessURI, handlers.GetReadinessHandler(probe, lifecycle)).Methods(http.MethodGet)
+	r.Handle(MetricsURI, m.Handler()).Methods(http.MethodGet)
 
-	r.HandleFunc(SingleUserURI, handlers.ToHandlerFunc(h.RemoveUser)).Methods(http.MethodDelete)
-	r.HandleFunc(SingleUserURI, handlers.ToHandlerFunc(h.UpdateUser)).Methods(http.MethodPut)
-	r.HandleFunc(SingleUserURI, handlers.ToHandlerFunc(h.GetUser)).Methods(http.MethodGet)
+	// the users and admin endpoints require a bearer token, whose roles the policy must allow
+	api := r.NewRoute().Subrouter()
+	api.Use(authn.Middleware, policy.NewEnforcer(rules).Middleware)
+	api.HandleFunc(SingleUserURI, handlers.ToHandlerFunc(h.RemoveUser)).Methods(http.MethodDelete)
+	api.HandleFunc(SingleUserURI, handlers.ToHandlerFunc(h.UpdateUser)).Methods(http.MethodPut)
+	api.HandleFunc(SingleUserURI, handlers.ToHandlerFunc(h.PatchUser)).Methods(http.MethodPatch)
+	api.HandleFunc(SingleUserURI, handlers.ToHandlerFunc(h.GetUser)).Methods(http.MethodGet)
 
-	r.HandleFunc(UsersURI, handlers.ToHandlerFunc(h.AddUser)).Methods(http.MethodPost)
-	r.HandleFunc(UsersURI, handlers.ToHandlerFunc(h.FilterUsers)).Methods(http.MethodGet)
+	api.HandleFunc(VerifyPasswordURI, handlers.ToHandlerFunc(h.VerifyPassword)).Methods(http.MethodPost)
 
+	api.HandleFunc(UsersURI, handlers.ToHandlerFunc(h.AddUser)).Methods(http.MethodPost)
+	api.HandleFunc(UsersURI, handlers.ToHandlerFunc(h.FilterUsers)).Methods(http.MethodGet)
+
+	api.HandleFunc(DeadLettersURI, handlers.ToHandlerFunc(admin.ListDeadLetters)).Methods(http.MethodGet)
+	api.HandleFunc(ReplayDeadLetterURI, handlers.ToHandlerFunc(admin.ReplayDeadLetter)).Methods(http.MethodPost)
+	api.HandleFunc(ReplayURI, handlers.ToHandlerFunc(admin.StartReplay)).Methods(http.MethodPost)
+	api.HandleFunc(ReplayJobURI, handlers.ToHandlerFunc(admin.GetReplay)).Methods(http.MethodGet)
+	err = rules.CheckRouter(api)
+	if err != nil {
+		log.WithField("error", err).Fatal("policy does not cover every endpoint")
+	}
+
+	access := accesslog.New(accesslog.Options{
+		Format:         cfg.AccessLog.Format,
+		SampleRatio:    cfg.AccessLog.SampleRatio,
+		TrustForwarded: cfg.AccessLog.TrustForwarded,
+		Route:          accesslog.MuxRoute(r),
+		Logger:         log.StandardLogger(),
+		Output:         os.Stdout,
+	})
 	server := &http.Server{
-		Handler: r,
-		Addr:    Host,
+		Handler: access.Middleware(r),
+		Addr:    cfg.Host,
 	}
-	log.Fatal(server.ListenAndServe())
+	go func() {
+		err := server.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			log.WithField("error", err).Fatal("server failed")
+		}
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	sig := <-signals
+	log.WithFields(log.Fields{
+		"signal":  sig.String(),
+		"delay":   cfg.Shutdown.Delay.String(),
+		"timeout": cfg.Shutdown.Timeout.String(),
+	}).Info("shutting down")
+
+	// Report unready, and close idle connections after their next response, while the load
+	// balancers notice, then drain everything else within the timeout
+	lifecycle.BeginShutdown()
+	server.SetKeepAlivesEnabled(false)
+	time.Sleep(cfg.Shutdown.Delay)
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Shutdown.Timeout)
+	defer cancel()
+	drain(ctx, server, replays, stopReplays, relay, stopRelay, msg, db, shutdownTracing)
+	log.Info("shutdown complete")
 }
 
-func getDatabase() *dao.DynamoClient {
-	return dao.NewDynamoClient()
+// drain stops the service within the deadline of ctx. Active requests complete first, as they can
+// start replays and write to the outbox, then running replays finish, and the outbox is flushed once
+// the relay has stopped, before the publishers and storage are closed and the last spans exported.
+// Work still running at the deadline is cancelled, undelivered outbox entries stay pending for the
+// next start
+func drain(ctx context.Context, server *http.Server, replays *replay.Replayer, stopReplays context.CancelFunc,
+	relay *outbox.Relay, stopRelay func(), msg *publisher.FanOut, db Storage, shutdownTracing func(context.Context) error) {
+	err := server.Shutdown(ctx)
+	if err != nil {
+		log.WithField("error", err).Warn("closing requests still active at shutdown")
+		server.Close()
+	}
+
+	err = waitFor(ctx, replays.Wait)
+	if err != nil {
+		log.WithField("error", err).Warn("cancelling replays still running at shutdown")
+	}
+	stopReplays()
+	replays.Wait()
+
+	stopRelay()
+	err = relay.Flush(ctx)
+	if err != nil {
+		log.WithField("error", err).Warn("outbox not flushed at shutdown, pending messages are published on the next start")
+	}
+
+	err = msg.Close()
+	if err != nil {
+		log.WithField("error", err).Warn("unable to close publishers")
+	}
+
+	// the SQL storage holds a connection pool, the other stores have nothing to release
+	if closer, ok := db.(io.Closer); ok {
+		err = closer.Close()
+		if err != nil {
+			log.WithField("error", err).Warn("unable to close storage")
+		}
+	}
+
+	err = shutdownTracing(ctx)
+	if err != nil {
+		log.WithField("error", err).Warn("unable to export the remaining spans")
+	}
 }
 
-func getPublisher() *publisher.SNSClient {
-	return publisher.NewSNSClient()
+// waitFor calls wait, returning once it does or the context ends
+func waitFor(ctx context.Context, wait func()) error {
+	done := make(chan struct{})
+	go func() {
+		wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func getDatabase(cfg *config.Config, sess *session.Session) (Storage, error) {
+	var db Storage
+	switch cfg.Storage {
+	case config.DynamoStorage:
+		return dao.NewDynamoClient(sess, cfg.Dynamo.UsersTable, cfg.Dynamo.UniqueTable, cfg.Dynamo.OutboxTable), nil
+	case config.MemoryStorage:
+		db = dao.NewMemoryClient()
+	case config.SQLStorage:
+		client, err := dao.NewSQLClient(context.Background(), cfg.SQL.Driver, cfg.SQL.DSN)
+		if err != nil {
+			return nil, err
+		}
+		db = client
+	default:
+		return nil, fmt.Errorf("unknown storage backend: %s", cfg.Storage)
+	}
+	if cfg.Seed != "" {
+		err := seedDatabase(db, cfg.Seed)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return db, nil
+}
+
+// traceDatabase traces the storage, with the system and tables it uses as span attributes
+func traceDatabase(cfg *config.Config, db Storage) *tracing.DAO {
+	switch cfg.Storage {
+	case config.DynamoStorage:
+		return tracing.NewDAO(db, tracing.DynamoSystem, cfg.Dynamo.UsersTable, cfg.Dynamo.OutboxTable)
+	case config.SQLStorage:
+		system := tracing.PostgresSystem
+		if cfg.SQL.Driver == dao.SQLiteDriver {
+			system = tracing.SQLiteSystem
+		}
+		return tracing.NewDAO(db, system, "users", "outbox")
+	default:
+		return tracing.NewDAO(db, tracing.MemorySystem, "", "")
+	}
+}
+
+// seedDatabase populates a local storage with the same test entries localstack.sh writes to dynamo
+func seedDatabase(db handlers.DAOClient, path string) error {
+	users, err := dao.LoadSeedFile(path)
+	if err != nil {
+		return err
+	}
+	for _, user := range users {
+		err = db.Insert(context.Background(), user, nil)
+		if err != nil {
+			return err
+		}
+	}
+	log.WithField("count", len(users)).Info("seeded storage")
+	return nil
+}
+
+// getTargets creates every configured publisher, messages are fanned out to each of them
+func getTargets(cfg *config.Config, sess *session.Session) ([]*publisher.Target, error) {
+	var targets []*publisher.Target
+	for _, name := range cfg.Publishers {
+		client, err := getTarget(cfg, sess, name)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, &publisher.Target{Name: name, Client: client})
+	}
+	return targets, nil
+}
+
+// getChecks returns the readiness checks of the storage and publishers that support them, dynamo,
+// sql and sns
+func getChecks(cfg *config.Config, db Storage, targets []*publisher.Target) []*health.Check {
+	var checks []*health.Check
+	if checker, ok := db.(health.Checker); ok {
+		checks = append(checks, &health.Check{Name: cfg.Storage, Checker: checker})
+	}
+	for _, target := range targets {
+		if checker, ok := target.Client.(health.Checker); ok {
+			checks = append(checks, &health.Check{Name: target.Name, Checker: checker})
+		}
+	}
+	return checks
+}
+
+// getDestination returns where a publisher sends messages, for the spans of each publish
+func getDestination(cfg *config.Config, name string) string {
+	switch name {
+	case config.SNSPublisher:
+		return cfg.SNS.TopicArn
+	case config.KafkaPublisher:
+		return cfg.Kafka.Topic
+	case config.NATSPublisher:
+		return cfg.NATS.Subject
+	case config.WebhookPublisher:
+		return strings.Join(cfg.Webhook.URLs, ",")
+	default:
+		return ""
+	}
+}
+
+func getTarget(cfg *config.Config, sess *session.Session, name string) (publisher.MsgClient, error) {
+	switch name {
+	case config.SNSPublisher:
+		return publisher.NewSNSClient(sess, cfg.SNS.TopicArn), nil
+	case config.KafkaPublisher:
+		return publisher.NewKafkaClient(cfg.Kafka.Brokers, cfg.Kafka.Topic), nil
+	case config.NATSPublisher:
+		client, err := publisher.NewNATSClient(cfg.NATS.URL, cfg.NATS.Subject, cfg.NATS.Stream)
+		if err != nil {
+			return nil, err
+		}
+		return client, nil
+	case config.WebhookPublisher:
+		return publisher.NewWebhookClient(cfg.Webhook.URLs, cfg.Webhook.Secret), nil
+	default:
+		return nil, fmt.Errorf("unknown publisher: %s", name)
+	}
+}
+
+func getDeadLetters(cfg *config.Config, db Storage) (publisher.DeadLetterStore, error) {
+	switch cfg.DeadLetters.Storage {
+	case config.FileDeadLetters:
+		store, err := dao.NewFileDeadLetters(cfg.DeadLetters.Dir)
+		if err != nil {
+			return nil, err
+		}
+		return store, nil
+	case config.SQLDeadLetters:
+		store, ok := db.(publisher.DeadLetterStore)
+		if !ok {
+			return nil, fmt.Errorf("the sql dead letter storage requires the sql storage")
+		}
+		return store, nil
+	default:
+		return nil, fmt.Errorf("unknown dead letter storage: %s", cfg.DeadLetters.Storage)
+	}
 }
@@ -1,6 +1,10 @@
 package model
 
-import "time"
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
 
 const (
 	// UserAdd is the operation designation for messaging of adding a new user
@@ -9,38 +13,204 @@ const (
 	UserDelete = "DeleteUser"
 	// UserUpdate is the operation designation for messaging of updating a new user
 	UserUpdate = "UpdateUser"
+	// UserSnapshot is the operation designation for messaging the current state of a user, when events are replayed
+	UserSnapshot = "UserSnapshot"
+
+	// MessageSchemaVersion is the version of the message format described in events.yaml. It changes
+	// when a field is removed or changes meaning, new fields may be added within a version
+	MessageSchemaVersion = 2
+	// Redacted replaces the values of secret fields in messages
+	Redacted = "REDACTED"
 )
 
-// User is the major structure for the service, containing all required info and a unique key
+// User is the major structure for the service, containing all required info and a unique key.
+// The password is stored as a bcrypt hash and is never serialised to JSON, so it cannot leak into
+// responses or logs. Version is incremented by the store on every write, so a write based on a
+// stale read can be detected and rejected
 type User struct {
 	Id       string `json:"userId" dynamodbav:"userId"`
 	Forename string `json:"forename" dynamodbav:"forename"`
 	Surname  string `json:"surname" dynamodbav:"surname"`
 	Nickname string `json:"nickname" dynamodbav:"nickname"`
-	Password string `json:"password" dynamodbav:"password"`
+	Password string `json:"-" dynamodbav:"password"`
 	Email    string `json:"email" dynamodbav:"email"`
 	Country  string `json:"country" dynamodbav:"country"`
+	Version  int64  `json:"version" dynamodbav:"version"`
 }
 
-// Message is the format of the messages emitted by the service
+// Message is the format of the messages emitted by the service. It carries the user before and
+// after the change, so consumers need not read the user back, and updates list the fields they
+// changed with their old and new values. Version is the version of the user the change produced,
+// and orders the messages for a single user. TraceContext holds the W3C trace context of the request
+// that made the change, it is kept with the message in the outbox and in dead letters so publishing
+// and replays continue the trace, but is not part of the published message
 type Message struct {
-	Id      string    `json:"userId"`
-	Action  string    `json:"userAction"`
-	Created time.Time `json:"creationTime"`
+	EventId       string         `json:"eventId"`
+	SchemaVersion int            `json:"schemaVersion"`
+	Id            string         `json:"userId"`
+	Action        string         `json:"userAction"`
+	Created       time.Time      `json:"creationTime"`
+	Version       int64          `json:"version"`
+	Before        *User          `json:"before,omitempty"`
+	After         *User          `json:"after,omitempty"`
+	Fields        []string       `json:"changedFields,omitempty"`
+	Diff          []*FieldChange `json:"diff,omitempty"`
+
+	TraceContext map[string]string `json:"-"`
+}
+
+// FieldChange is the old and new value of a single field changed by an update
+type FieldChange struct {
+	Field  string `json:"field"`
+	Before string `json:"before"`
+	After  string `json:"after"`
 }
 
 // New message converts a user Id and operation to a message
 func NewMessage(id, action string) *Message {
 	currentTime := time.Now()
 	return &Message{
-		Id:      id,
-		Action:  action,
-		Created: currentTime,
+		EventId:       uuid.New().String(),
+		SchemaVersion: MessageSchemaVersion,
+		Id:            id,
+		Action:        action,
+		Created:       currentTime,
 	}
 }
 
+// NewChangeMessage describes the change of a user from before to after, either of which is nil when
+// the user is added or deleted. Writes are conditional on the version that was read, so the change
+// is always stored as the version after that of before. Password hashes are salted, so two hashes of
+// the same password differ and the caller reports whether the plaintext password changed
+func NewChangeMessage(action string, before, after *User, passwordChanged bool) *Message {
+	msg := NewMessage("", action)
+	if before != nil {
+		msg.Id = before.Id
+		msg.Version = before.Version
+		msg.Before = snapshot(before, before.Version)
+	}
+	msg.Version++
+	if after != nil {
+		msg.Id = after.Id
+		msg.After = snapshot(after, msg.Version)
+	}
+	if before != nil && after != nil {
+		msg.Diff = diff(before, after, passwordChanged)
+		for _, change := range msg.Diff {
+			msg.Fields = append(msg.Fields, change.Field)
+		}
+	}
+	return msg
+}
+
+// NewSnapshotMessage describes the current state of a user without a change, so consumers can
+// rebuild their copy of it
+func NewSnapshotMessage(user *User) *Message {
+	msg := NewMessage(user.Id, UserSnapshot)
+	msg.Version = user.Version
+	msg.After = snapshot(user, user.Version)
+	return msg
+}
+
+// snapshot copies a user for a message at the given version, without the password hash
+func snapshot(user *User, version int64) *User {
+	copied := *user
+	copied.Password = ""
+	copied.Version = version
+	return &copied
+}
+
+// diff lists the fields that differ between two users in the order of a user request. Only the
+// fact that the password changed is recorded, never its hash
+func diff(before, after *User, passwordChanged bool) []*FieldChange {
+	fields := []struct {
+		name          string
+		before, after string
+	}{
+		{"forename", before.Forename, after.Forename},
+		{"surname", before.Surname, after.Surname},
+		{"nickname", before.Nickname, after.Nickname},
+		{"password", "", ""},
+		{"email", before.Email, after.Email},
+		{"country", before.Country, after.Country},
+	}
+	changes := []*FieldChange{}
+	for _, field := range fields {
+		if field.name == "password" {
+			if passwordChanged {
+				changes = append(changes, &FieldChange{Field: field.name, Before: Redacted, After: Redacted})
+			}
+			continue
+		}
+		if field.before == field.after {
+			continue
+		}
+		changes = append(changes, &FieldChange{Field: field.name, Before: field.before, After: field.after})
+	}
+	return changes
+}
+
+// DeadLetter is a message that could not be published to one of the publishers after every retry.
+// It is kept until it is replayed to that publisher
+type DeadLetter struct {
+	Id       string    `json:"id"`
+	Target   string    `json:"target"`
+	Message  *Message  `json:"message"`
+	Error    string    `json:"error"`
+	Attempts int       `json:"attempts"`
+	Failed   time.Time `json:"failedAt"`
+}
+
+const (
+	// JobRunning is the status of a replay that is still emitting events
+	JobRunning = "running"
+	// JobCompleted is the status of a replay that emitted an event for every user
+	JobCompleted = "completed"
+	// JobFailed is the status of a replay that stopped on an error
+	JobFailed = "failed"
+)
+
+// ReplayRequest starts a replay of UserSnapshot events. Filter takes the same queries as a user
+// search, e.g. {"country": "DNK", "nickname[prefix]": "s1"}, and Rate limits the events per second
+type ReplayRequest struct {
+	Filter map[string]string `json:"filter,omitempty"`
+	Rate   float64           `json:"ratePerSecond,omitempty"`
+}
+
+// ReplayJob reports the progress of a replay
+type ReplayJob struct {
+	Id       string            `json:"id"`
+	Status   string            `json:"status"`
+	Filter   map[string]string `json:"filter,omitempty"`
+	Rate     float64           `json:"ratePerSecond"`
+	Emitted  int               `json:"emitted"`
+	Error    string            `json:"error,omitempty"`
+	Started  time.Time         `json:"startedAt"`
+	Finished *time.Time        `json:"finishedAt,omitempty"`
+}
+
+// Page describes which window of results to return from a search. A zero limit returns all results
+type Page struct {
+	Limit  int
+	Cursor string
+}
+
+const (
+	// OpEqual matches attributes exactly equal to the value, it is the default operator
+	OpEqual = "eq"
+	// OpNotEqual matches attributes not equal to the value
+	OpNotEqual = "ne"
+	// OpPrefix matches attributes that begin with the value
+	OpPrefix = "prefix"
+	// OpContains matches attributes that contain the value as a substring
+	OpContains = "contains"
+	// OpIn matches attributes equal to any of a list of values, the value must be a []string
+	OpIn = "in"
+)
+
 // FilterCondition is a struct to siplify the transfer of query values between the service and the storage client
 type FilterCondition struct {
-	Query string
-	Value interface{}
+	Query    string
+	Operator string
+	Value    interface{}
 }
@@ -0,0 +1,139 @@
+package model
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
+)
+
+func TestNewChangeMessage(t *testing.T) {
+	stored := &User{
+		Id:       "dummy-test-user",
+		Forename: "Peter",
+		Surname:  "Rothmann",
+		Nickname: "dupreeh",
+		Password: "stored-hash",
+		Email:    "pr@notarealemail.com",
+		Country:  "DNK",
+		Version:  2,
+	}
+	updated := *stored
+	updated.Password = "new-hash"
+	updated.Country = "SWE"
+
+	tests := []struct {
+		name            string
+		action          string
+		before          *User
+		after           *User
+		passwordChanged bool
+		expectedVersion int64
+		expectedFields  []string
+	}{
+		{
+			name:            "add",
+			action:          UserAdd,
+			after:           &User{Id: stored.Id, Password: "stored-hash"},
+			expectedVersion: 1,
+		}, {
+			name:            "update",
+			action:          UserUpdate,
+			before:          stored,
+			after:           &updated,
+			passwordChanged: true,
+			expectedVersion: 3,
+			expectedFields:  []string{"password", "country"},
+		}, {
+			// the hashes differ, but the caller found the same password was sent
+			name:            "update with the same password",
+			action:          UserUpdate,
+			before:          stored,
+			after:           &updated,
+			expectedVersion: 3,
+			expectedFields:  []string{"country"},
+		}, {
+			name:            "delete",
+			action:          UserDelete,
+			before:          stored,
+			expectedVersion: 3,
+		},
+	}
+	for _, test := range tests {
+		tt := test
+		t.Run(tt.name, func(t *testing.T) {
+			msg := NewChangeMessage(tt.action, tt.before, tt.after, tt.passwordChanged)
+			assert.NotEmpty(t, msg.EventId)
+			assert.Equal(t, MessageSchemaVersion, msg.SchemaVersion)
+			assert.Equal(t, stored.Id, msg.Id)
+			assert.Equal(t, tt.action, msg.Action)
+			assert.Equal(t, tt.expectedVersion, msg.Version)
+			assert.Equal(t, tt.before != nil, msg.Before != nil)
+			assert.Equal(t, tt.after != nil, msg.After != nil)
+			if msg.After != nil {
+				assert.Equal(t, tt.expectedVersion, msg.After.Version)
+			}
+			assert.Equal(t, tt.expectedFields, msg.Fields)
+			assert.Equal(t, len(tt.expectedFields), len(msg.Diff))
+
+			// the snapshots are copies, and the hash never leaves the service
+			assert.Equal(t, "stored-hash", stored.Password)
+			body, err := json.Marshal(msg)
+			assert.Nil(t, err)
+			assert.NotContains(t, string(body), "hash")
+		})
+	}
+
+	msg := NewChangeMessage(UserUpdate, stored, &updated, true)
+	assert.Equal(t, &FieldChange{Field: "password", Before: Redacted, After: Redacted}, msg.Diff[0])
+	assert.Equal(t, &FieldChange{Field: "country", Before: "DNK", After: "SWE"}, msg.Diff[1])
+}
+
+// jsonFields lists the JSON names of the serialised fields of a struct
+func jsonFields(value interface{}) []string {
+	fields := []string{}
+	kind := reflect.TypeOf(value)
+	for i := 0; i < kind.NumField(); i++ {
+		name := strings.Split(kind.Field(i).Tag.Get("json"), ",")[0]
+		if name != "-" {
+			fields = append(fields, name)
+		}
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+// TestEventsSchema checks the message structures match those published in events.yaml
+func TestEventsSchema(t *testing.T) {
+	content, err := ioutil.ReadFile("../events.yaml")
+	assert.Nil(t, err)
+	spec := struct {
+		Components struct {
+			Schemas map[string]struct {
+				Properties map[string]interface{} `yaml:"properties"`
+			} `yaml:"schemas"`
+		} `yaml:"components"`
+	}{}
+	assert.Nil(t, yaml.Unmarshal(content, &spec))
+
+	structs := map[string]interface{}{
+		"Message":     Message{},
+		"User":        User{},
+		"FieldChange": FieldChange{},
+	}
+	for name, value := range structs {
+		schema, ok := spec.Components.Schemas[name]
+		assert.True(t, ok, "events.yaml should define a %s schema", name)
+		properties := []string{}
+		for property := range schema.Properties {
+			properties = append(properties, property)
+		}
+		sort.Strings(properties)
+		assert.Equal(t, jsonFields(value), properties, name)
+	}
+}
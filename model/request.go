@@ -1,15 +1,14 @@
 package model
 
-// For these structs, as in the case of my implementation there is no difference, bar the ID
-// between the requests and the user object, these aren't used. If the user object contained
-// additional internal parameters these would be used, but they're not here
+// These structs differ from the user object in that they carry the plaintext password, which
+// is hashed before a user is stored and never returned
 
 // AddRequest is the request body expected to add a new user
 type AddRequest struct {
 	Forename string `json:"forename" dynamodbav:"forename"`
 	Surname  string `json:"surname" dynamodbav:"surname"`
 	Nickname string `json:"nickname" dynamodbav:"nickname"`
-	Password string `json:"password" dynamodbav:"nickname"`
+	Password string `json:"password" dynamodbav:"password"`
 	Email    string `json:"email" dynamodbav:"email"`
 	Country  string `json:"country" dynamodbav:"country"`
 }
@@ -20,7 +19,12 @@ type UpdateRequest struct {
 	Forename string `json:"forename" dynamodbav:"forename"`
 	Surname  string `json:"surname" dynamodbav:"surname"`
 	Nickname string `json:"nickname" dynamodbav:"nickname"`
-	Password string `json:"password" dynamodbav:"nickname"`
+	Password string `json:"password" dynamodbav:"password"`
 	Email    string `json:"email" dynamodbav:"email"`
 	Country  string `json:"country" dynamodbav:"country"`
 }
+
+// VerifyPasswordRequest is the request body expected to check a password against a stored user
+type VerifyPasswordRequest struct {
+	Password string `json:"password"`
+}
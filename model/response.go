@@ -1,7 +1,52 @@
 package model
 
+import "time"
+
 // FilterResponse is the struct returned by a user search
 type FilterResponse struct {
-	Results []*User `json:"results"`
-	Count   int     `json:"count"`
+	Results    []*User `json:"results"`
+	Count      int     `json:"count"`
+	NextCursor string  `json:"nextCursor,omitempty"`
+}
+
+// VerifyPasswordResponse is the struct returned by a successful password verification
+type VerifyPasswordResponse struct {
+	Id       string `json:"userId"`
+	Verified bool   `json:"verified"`
+}
+
+// DeadLetterResponse is the struct returned by a dead letter listing
+type DeadLetterResponse struct {
+	Results []*DeadLetter `json:"results"`
+	Count   int           `json:"count"`
+}
+
+const (
+	// ReadinessReady is the status of a service whose dependencies are all reachable
+	ReadinessReady = "ready"
+	// ReadinessUnready is the status of a service with at least one unreachable dependency
+	ReadinessUnready = "unready"
+	// ReadinessShuttingDown is the status of a service draining before it stops
+	ReadinessShuttingDown = "shutting down"
+
+	// DependencyUp is the status of a dependency that passed its check
+	DependencyUp = "up"
+	// DependencyDown is the status of a dependency that failed or timed out
+	DependencyDown = "down"
+)
+
+// ReadinessReport is the struct returned by the readiness probe, with the result of each dependency check
+type ReadinessReport struct {
+	Status       string              `json:"status"`
+	Dependencies []*DependencyStatus `json:"dependencies"`
+}
+
+// DependencyStatus is the latest result of checking a dependency, results are cached so Checked
+// can be earlier than the request
+type DependencyStatus struct {
+	Name    string    `json:"name"`
+	Status  string    `json:"status"`
+	Latency float64   `json:"latencyMs"`
+	Error   string    `json:"error,omitempty"`
+	Checked time.Time `json:"checkedAt"`
 }
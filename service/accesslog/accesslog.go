@@ -0,0 +1,208 @@
+// Package accesslog writes one line for each request served, as JSON or in the Apache combined format
+package accesslog
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+
+	"faceit/service/logging"
+)
+
+const (
+	// JSONFormat logs each request as a JSON line through the service logger
+	JSONFormat = "json"
+	// CombinedFormat writes the Apache combined format, followed by the latency in microseconds, the
+	// request ID and the route template
+	CombinedFormat = "combined"
+
+	// Unmatched is the route of requests matching no route
+	Unmatched = "unmatched"
+
+	combinedTime = "02/Jan/2006:15:04:05 -0700"
+)
+
+// Options configures the access log
+type Options struct {
+	Format string
+	// SampleRatio is the fraction of successful requests logged, from 0 to 1. Requests failing with
+	// a 4xx or 5xx status are always logged
+	SampleRatio float64
+	// TrustForwarded takes the client IP from the X-Forwarded-For header set by a load balancer
+	TrustForwarded bool
+	// Route returns the route template of a request, e.g. /users/{id}
+	Route func(*http.Request) string
+	// Logger receives the JSON lines
+	Logger *logrus.Logger
+	// Output receives the combined lines
+	Output io.Writer
+}
+
+// AccessLog logs the requests served by a handler
+type AccessLog struct {
+	options Options
+	sample  func() float64
+}
+
+// New creates an access log
+func New(options Options) *AccessLog {
+	return &AccessLog{
+		options: options,
+		sample:  rand.Float64,
+	}
+}
+
+// Entry is what is recorded of a request
+type Entry struct {
+	Time      time.Time
+	Method    string
+	Route     string
+	URI       string
+	Proto     string
+	Status    int
+	Bytes     int64
+	Latency   time.Duration
+	ClientIP  string
+	RequestID string
+	Referer   string
+	UserAgent string
+}
+
+// Middleware logs each request served by next, once it has been served. It wraps the router, so the
+// route is matched again to find its template, and the request ID is read from the response
+func (a *AccessLog) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+		if recorder.status < http.StatusBadRequest && a.sample() >= a.options.SampleRatio {
+			return
+		}
+		a.write(&Entry{
+			Time:      start,
+			Method:    r.Method,
+			Route:     a.route(r),
+			URI:       requestURI(r),
+			Proto:     r.Proto,
+			Status:    recorder.status,
+			Bytes:     recorder.bytes,
+			Latency:   time.Since(start),
+			ClientIP:  a.clientIP(r),
+			RequestID: w.Header().Get(logging.RequestIDHeader),
+			Referer:   r.Referer(),
+			UserAgent: r.UserAgent(),
+		})
+	})
+}
+
+// requestURI returns the path and query of a request, with the values of sensitive params masked
+// as the service logger masks sensitive fields, since combined lines are not written through it
+func requestURI(r *http.Request) string {
+	uri := r.URL.EscapedPath()
+	if r.URL.RawQuery != "" {
+		uri += "?" + logging.RedactQuery(r.URL.RawQuery)
+	}
+	return uri
+}
+
+// route returns the template of the route a request matched
+func (a *AccessLog) route(r *http.Request) string {
+	if a.options.Route == nil {
+		return Unmatched
+	}
+	return a.options.Route(r)
+}
+
+// clientIP returns the address of the client, the first address of X-Forwarded-For when trusted
+func (a *AccessLog) clientIP(r *http.Request) string {
+	if a.options.TrustForwarded {
+		forwarded := strings.TrimSpace(strings.Split(r.Header.Get("X-Forwarded-For"), ",")[0])
+		if forwarded != "" {
+			return forwarded
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// write writes an entry in the configured format
+func (a *AccessLog) write(e *Entry) {
+	if a.options.Format == CombinedFormat {
+		fmt.Fprintln(a.options.Output, Combined(e))
+		return
+	}
+	a.options.Logger.WithFields(logrus.Fields{
+		"method":    e.Method,
+		"route":     e.Route,
+		"uri":       e.URI,
+		"status":    e.Status,
+		"bytes":     e.Bytes,
+		"latencyMs": float64(e.Latency) / float64(time.Millisecond),
+		"clientIp":  e.ClientIP,
+		"requestId": e.RequestID,
+		"userAgent": e.UserAgent,
+	}).Info("request")
+}
+
+// Combined formats an entry as an Apache combined log line, extended with the latency in
+// microseconds, the request ID and the route template
+func Combined(e *Entry) string {
+	bytes := "-"
+	if e.Bytes > 0 {
+		bytes = fmt.Sprint(e.Bytes)
+	}
+	return fmt.Sprintf(`%s - - [%s] "%s %s %s" %d %s "%s" "%s" %d "%s" "%s"`,
+		e.ClientIP, e.Time.Format(combinedTime), e.Method, quote(e.URI), e.Proto, e.Status, bytes,
+		quote(e.Referer), quote(e.UserAgent), e.Latency.Microseconds(), quote(e.RequestID), quote(e.Route))
+}
+
+// quote escapes a value for a quoted field, using - for an empty one
+func quote(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value)
+}
+
+// MuxRoute returns a func matching requests against the router to find the template of their route
+func MuxRoute(router *mux.Router) func(*http.Request) string {
+	return func(r *http.Request) string {
+		var match mux.RouteMatch
+		if !router.Match(r, &match) || match.Route == nil {
+			return Unmatched
+		}
+		template, err := match.Route.GetPathTemplate()
+		if err != nil {
+			return Unmatched
+		}
+		return template
+	}
+}
+
+// responseRecorder records the status code and size of a response
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (r *responseRecorder) WriteHeader(code int) {
+	r.status = code
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += int64(n)
+	return n, err
+}
@@ -0,0 +1,159 @@
+package accesslog
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+
+	"faceit/service/logging"
+)
+
+// router serves a user, or a 404 for the user missing
+func router() *mux.Router {
+	r := mux.NewRouter()
+	r.HandleFunc("/users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(logging.RequestIDHeader, "abc-123")
+		if mux.Vars(r)["id"] == "missing" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(`{"userId":"a"}`))
+	}).Methods(http.MethodGet)
+	return r
+}
+
+func TestJSON(t *testing.T) {
+	logger, hook := logtest.NewNullLogger()
+	r := router()
+	access := New(Options{Format: JSONFormat, SampleRatio: 1, Route: MuxRoute(r), Logger: logger})
+
+	req := httptest.NewRequest(http.MethodGet, "/users/a?fields=all", nil)
+	req.RemoteAddr = "10.0.0.7:53122"
+	req.Header.Set("User-Agent", "curl/7.68.0")
+	access.Middleware(r).ServeHTTP(httptest.NewRecorder(), req)
+
+	entry := hook.LastEntry()
+	assert.Equal(t, "request", entry.Message)
+	assert.Equal(t, logrus.InfoLevel, entry.Level)
+	assert.Equal(t, http.MethodGet, entry.Data["method"])
+	assert.Equal(t, "/users/{id}", entry.Data["route"])
+	assert.Equal(t, "/users/a?fields=all", entry.Data["uri"])
+	assert.Equal(t, http.StatusOK, entry.Data["status"])
+	assert.Equal(t, int64(14), entry.Data["bytes"])
+	assert.IsType(t, float64(0), entry.Data["latencyMs"])
+	assert.Equal(t, "10.0.0.7", entry.Data["clientIp"])
+	assert.Equal(t, "abc-123", entry.Data["requestId"])
+	assert.Equal(t, "curl/7.68.0", entry.Data["userAgent"])
+
+	// requests matching no route, or the wrong method, have no template
+	access.Middleware(r).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/teams/a", nil))
+	assert.Equal(t, Unmatched, hook.LastEntry().Data["route"])
+	assert.Equal(t, http.StatusNotFound, hook.LastEntry().Data["status"])
+	access.Middleware(r).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodDelete, "/users/a", nil))
+	assert.Equal(t, Unmatched, hook.LastEntry().Data["route"])
+}
+
+func TestCombined(t *testing.T) {
+	var out bytes.Buffer
+	r := router()
+	access := New(Options{Format: CombinedFormat, SampleRatio: 1, Route: MuxRoute(r), Output: &out})
+
+	req := httptest.NewRequest(http.MethodGet, "/users/missing", nil)
+	req.RemoteAddr = "10.0.0.7:53122"
+	req.Header.Set("User-Agent", `evil "agent"`)
+	access.Middleware(r).ServeHTTP(httptest.NewRecorder(), req)
+
+	assert.Regexp(t, `^10\.0\.0\.7 - - \[\d{2}/\w{3}/\d{4}:\d{2}:\d{2}:\d{2} [+-]\d{4}\] "GET /users/missing HTTP/1\.1" 404 - "-" "evil \\"agent\\"" \d+ "abc-123" "/users/\{id\}"\n$`, out.String())
+}
+
+func TestRedactedQuery(t *testing.T) {
+	var out bytes.Buffer
+	logger, hook := logtest.NewNullLogger()
+	r := router()
+	for _, access := range []*AccessLog{
+		New(Options{Format: CombinedFormat, SampleRatio: 1, Route: MuxRoute(r), Output: &out}),
+		New(Options{Format: JSONFormat, SampleRatio: 1, Route: MuxRoute(r), Logger: logger}),
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/users/a?country=NZL&email=alice%40example.com&email[prefix]=bob", nil)
+		access.Middleware(r).ServeHTTP(httptest.NewRecorder(), req)
+	}
+	assert.Contains(t, out.String(), `"GET /users/a?country=NZL&email=[REDACTED]&email[prefix]=[REDACTED] HTTP/1.1"`)
+	assert.NotContains(t, out.String(), "example.com")
+	assert.Equal(t, "/users/a?country=NZL&email=[REDACTED]&email[prefix]=[REDACTED]", hook.LastEntry().Data["uri"])
+}
+
+func TestCombinedEntry(t *testing.T) {
+	entry := &Entry{
+		Time:      time.Date(2021, 3, 14, 9, 26, 53, 0, time.UTC),
+		Method:    http.MethodPost,
+		Route:     "/users",
+		URI:       "/users",
+		Proto:     "HTTP/1.1",
+		Status:    http.StatusCreated,
+		Bytes:     181,
+		Latency:   2500 * time.Microsecond,
+		ClientIP:  "192.0.2.1",
+		RequestID: "abc-123",
+		Referer:   "https://admin.example.com/",
+		UserAgent: "faceit-admin/1.0",
+	}
+	assert.Equal(t, `192.0.2.1 - - [14/Mar/2021:09:26:53 +0000] "POST /users HTTP/1.1" 201 181 "https://admin.example.com/" "faceit-admin/1.0" 2500 "abc-123" "/users"`, Combined(entry))
+}
+
+func TestSampling(t *testing.T) {
+	logger, hook := logtest.NewNullLogger()
+	r := router()
+	access := New(Options{Format: JSONFormat, SampleRatio: 0.25, Route: MuxRoute(r), Logger: logger})
+
+	tests := []struct {
+		name   string
+		path   string
+		sample float64
+		logged bool
+	}{
+		{name: "sampled success", path: "/users/a", sample: 0.1, logged: true},
+		{name: "dropped success", path: "/users/a", sample: 0.5, logged: false},
+		{name: "failure", path: "/users/missing", sample: 0.5, logged: true},
+	}
+	for _, test := range tests {
+		tt := test
+		t.Run(tt.name, func(t *testing.T) {
+			hook.Reset()
+			access.sample = func() float64 { return tt.sample }
+			access.Middleware(r).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, tt.path, nil))
+			assert.Equal(t, tt.logged, len(hook.AllEntries()) == 1)
+		})
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		name      string
+		trust     bool
+		forwarded string
+		expected  string
+	}{
+		{name: "remote address", forwarded: "203.0.113.9", expected: "10.0.0.7"},
+		{name: "forwarded", trust: true, forwarded: "203.0.113.9, 10.0.0.1", expected: "203.0.113.9"},
+		{name: "not forwarded", trust: true, expected: "10.0.0.7"},
+	}
+	for _, test := range tests {
+		tt := test
+		t.Run(tt.name, func(t *testing.T) {
+			access := New(Options{TrustForwarded: tt.trust, Logger: logrus.New()})
+			req := httptest.NewRequest(http.MethodGet, "/users/a", nil)
+			req.RemoteAddr = "10.0.0.7:53122"
+			if tt.forwarded != "" {
+				req.Header.Set("X-Forwarded-For", tt.forwarded)
+			}
+			assert.Equal(t, tt.expected, access.clientIP(req))
+		})
+	}
+}
@@ -0,0 +1,173 @@
+// Package auth authenticates requests bearing a JWT signed by a key of a configured JWKS
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	jose "gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+
+	"faceit/service/handlers"
+	"faceit/service/logging"
+)
+
+var (
+	// ErrMissingToken is returned for requests without a bearer token
+	ErrMissingToken = errors.New("missing bearer token")
+
+	// algorithms are the signature algorithms accepted
+	algorithms = map[string]bool{
+		string(jose.RS256): true,
+		string(jose.ES256): true,
+	}
+)
+
+// Claims are the claims of an authenticated token. Roles and the space separated scope are read
+// when the issuer includes them
+type Claims struct {
+	jwt.Claims
+	Roles []string `json:"roles,omitempty"`
+	Scope string   `json:"scope,omitempty"`
+}
+
+// KeySource returns the keys which may have signed a token with the given key ID
+type KeySource interface {
+	Keys(ctx context.Context, kid string) ([]jose.JSONWebKey, error)
+}
+
+// Authenticator validates bearer tokens, which must be signed with RS256 or ES256 by a key of the
+// source, issued by the issuer for the audience, and unexpired
+type Authenticator struct {
+	keys     KeySource
+	issuer   string
+	audience string
+	now      func() time.Time
+}
+
+// NewAuthenticator creates an authenticator accepting tokens from an issuer for an audience
+func NewAuthenticator(keys KeySource, issuer, audience string) *Authenticator {
+	return &Authenticator{
+		keys:     keys,
+		issuer:   issuer,
+		audience: audience,
+		now:      time.Now,
+	}
+}
+
+// Authenticate verifies a token and returns its claims
+func (a *Authenticator) Authenticate(ctx context.Context, token string) (*Claims, error) {
+	parsed, err := jwt.ParseSigned(token)
+	if err != nil {
+		return nil, fmt.Errorf("malformed token: %v", err)
+	}
+	if len(parsed.Headers) != 1 {
+		return nil, errors.New("token must have a single signature")
+	}
+	header := parsed.Headers[0]
+	if !algorithms[header.Algorithm] {
+		return nil, fmt.Errorf("unsupported signature algorithm: %s", header.Algorithm)
+	}
+	keys, err := a.keys.Keys(ctx, header.KeyID)
+	if err != nil {
+		return nil, err
+	}
+
+	claims := &Claims{}
+	verified := false
+	for _, key := range keys {
+		if key.Algorithm != "" && key.Algorithm != header.Algorithm {
+			continue
+		}
+		if parsed.Claims(key.Key, claims) == nil {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, errors.New("invalid token signature")
+	}
+
+	if claims.Expiry == nil {
+		return nil, errors.New("token has no expiry")
+	}
+	err = claims.ValidateWithLeeway(jwt.Expected{
+		Issuer:   a.issuer,
+		Audience: jwt.Audience{a.audience},
+		Time:     a.now(),
+	}, jwt.DefaultLeeway)
+	if err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// Middleware rejects requests without a valid bearer token with a 401, and puts the claims of the
+// token on the context of those with one
+func (a *Authenticator) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := logging.FromContext(ctx)
+		token, ok := bearerToken(r)
+		if !ok {
+			logger.Warn("request without bearer token")
+			unauthorized(w, ErrMissingToken, `Bearer realm="faceit"`)
+			return
+		}
+		claims, err := a.Authenticate(ctx, token)
+		if err != nil {
+			logger.WithField("error", err).Warn("invalid bearer token")
+			err = fmt.Errorf("invalid token: %v", err)
+			unauthorized(w, err, `Bearer realm="faceit", error="invalid_token"`)
+			return
+		}
+		ctx = NewContext(ctx, claims)
+		ctx = logging.NewContext(ctx, logger.WithField("subject", claims.Subject))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// bearerToken returns the token of the Authorization header
+func bearerToken(r *http.Request) (string, bool) {
+	header := r.Header.Get("Authorization")
+	if len(header) < len("Bearer ") || !strings.EqualFold(header[:len("Bearer ")], "Bearer ") {
+		return "", false
+	}
+	token := strings.TrimSpace(header[len("Bearer "):])
+	return token, token != ""
+}
+
+// unauthorized writes a 401 in the format of the other error responses
+func unauthorized(w http.ResponseWriter, err error, challenge string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("WWW-Authenticate", challenge)
+	w.WriteHeader(http.StatusUnauthorized)
+	err = json.NewEncoder(w).Encode(&handlers.ErrorResponse{
+		Code:        http.StatusUnauthorized,
+		Description: err.Error(),
+	})
+	if err != nil {
+		log.WithField("error", err).Error("unable to write response")
+	}
+}
+
+type contextKey int
+
+const claimsKey contextKey = iota
+
+// NewContext returns a context carrying the claims of the authenticated caller
+func NewContext(ctx context.Context, claims *Claims) context.Context {
+	return context.WithValue(ctx, claimsKey, claims)
+}
+
+// FromContext returns the claims of the authenticated caller, if any
+func FromContext(ctx context.Context) (*Claims, bool) {
+	claims, ok := ctx.Value(claimsKey).(*Claims)
+	return claims, ok
+}
@@ -0,0 +1,213 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	jose "gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+const (
+	issuer   = "https://auth.example.com/"
+	audience = "faceit-users"
+)
+
+// testKeys are the signing keys of the tests, with their public keys written to a JWKS file
+type testKeys struct {
+	rsa  *rsa.PrivateKey
+	ec   *ecdsa.PrivateKey
+	jwks []byte
+	path string
+}
+
+// newTestKeys generates an RS256 and an ES256 key, and writes their JWKS to a file removed by the
+// returned func
+func newTestKeys(t *testing.T) (*testKeys, func()) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	set := jose.JSONWebKeySet{Keys: []jose.JSONWebKey{
+		{Key: rsaKey.Public(), KeyID: "rsa", Algorithm: "RS256", Use: "sig"},
+		{Key: ecKey.Public(), KeyID: "ec", Algorithm: "ES256", Use: "sig"},
+	}}
+	content, err := json.Marshal(set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "jwks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "jwks.json")
+	err = ioutil.WriteFile(path, content, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testKeys{rsa: rsaKey, ec: ecKey, jwks: content, path: path}, func() { os.RemoveAll(dir) }
+}
+
+// sign signs claims with a key, under a key ID
+func sign(t *testing.T, alg jose.SignatureAlgorithm, key interface{}, kid string, claims interface{}) string {
+	options := (&jose.SignerOptions{}).WithType("JWT")
+	if kid != "" {
+		options = options.WithHeader(jose.HeaderKey("kid"), kid)
+	}
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: alg, Key: key}, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := jwt.Signed(signer).Claims(claims).CompactSerialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+// validClaims returns claims accepted by the authenticator at now
+func validClaims(now time.Time) *Claims {
+	return &Claims{
+		Claims: jwt.Claims{
+			Issuer:   issuer,
+			Subject:  "dummy-test-user",
+			Audience: jwt.Audience{audience},
+			Expiry:   jwt.NewNumericDate(now.Add(time.Hour)),
+			IssuedAt: jwt.NewNumericDate(now),
+		},
+		Roles: []string{"user"},
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	keys, remove := newTestKeys(t)
+	defer remove()
+	now := time.Date(2021, 3, 14, 9, 0, 0, 0, time.UTC)
+	authn := NewAuthenticator(NewKeySet(keys.path), issuer, audience)
+	authn.now = func() time.Time { return now }
+
+	expired := validClaims(now.Add(-2 * time.Hour))
+	otherIssuer := validClaims(now)
+	otherIssuer.Issuer = "https://evil.example.com/"
+	otherAudience := validClaims(now)
+	otherAudience.Audience = jwt.Audience{"faceit-teams"}
+	noExpiry := validClaims(now)
+	noExpiry.Expiry = nil
+	tampered := sign(t, jose.RS256, keys.rsa, "rsa", validClaims(now))
+	tampered = tampered[:len(tampered)-4] + "AAAA"
+
+	tests := []struct {
+		name  string
+		token string
+		err   string
+	}{
+		{name: "rs256", token: sign(t, jose.RS256, keys.rsa, "rsa", validClaims(now))},
+		{name: "es256", token: sign(t, jose.ES256, keys.ec, "ec", validClaims(now))},
+		{name: "no key id", token: sign(t, jose.ES256, keys.ec, "", validClaims(now))},
+		{name: "expired", token: sign(t, jose.RS256, keys.rsa, "rsa", expired), err: "token is expired"},
+		{name: "issuer", token: sign(t, jose.RS256, keys.rsa, "rsa", otherIssuer), err: "invalid issuer"},
+		{name: "audience", token: sign(t, jose.RS256, keys.rsa, "rsa", otherAudience), err: "invalid audience"},
+		{name: "no expiry", token: sign(t, jose.RS256, keys.rsa, "rsa", noExpiry), err: "token has no expiry"},
+		{name: "wrong key", token: sign(t, jose.RS256, keys.rsa, "ec", validClaims(now)), err: "invalid token signature"},
+		{name: "tampered", token: tampered, err: "invalid token signature"},
+		{name: "hs256", token: sign(t, jose.HS256, []byte("shared-secret-shared-secret-1234"), "rsa", validClaims(now)), err: "unsupported signature algorithm: HS256"},
+		{name: "malformed", token: "not-a-token", err: "malformed token"},
+	}
+	for _, test := range tests {
+		tt := test
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := authn.Authenticate(context.Background(), tt.token)
+			if tt.err != "" {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), tt.err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, "dummy-test-user", claims.Subject)
+			assert.Equal(t, []string{"user"}, claims.Roles)
+		})
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	keys, remove := newTestKeys(t)
+	defer remove()
+	authn := NewAuthenticator(NewKeySet(keys.path), issuer, audience)
+	handler := authn.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := FromContext(r.Context())
+		assert.True(t, ok)
+		w.Write([]byte(claims.Subject))
+	}))
+	expired := validClaims(time.Now().Add(-2 * time.Hour))
+
+	tests := []struct {
+		name      string
+		header    string
+		code      int
+		challenge string
+		body      string
+	}{
+		{
+			name:   "valid",
+			header: "Bearer " + sign(t, jose.RS256, keys.rsa, "rsa", validClaims(time.Now())),
+			code:   http.StatusOK,
+			body:   "dummy-test-user",
+		}, {
+			name:   "lower case scheme",
+			header: "bearer " + sign(t, jose.ES256, keys.ec, "ec", validClaims(time.Now())),
+			code:   http.StatusOK,
+			body:   "dummy-test-user",
+		}, {
+			name:      "missing",
+			code:      http.StatusUnauthorized,
+			challenge: `Bearer realm="faceit"`,
+			body:      `{"code":401,"description":"missing bearer token"}`,
+		}, {
+			name:      "basic",
+			header:    "Basic YWRtaW46YWRtaW4=",
+			code:      http.StatusUnauthorized,
+			challenge: `Bearer realm="faceit"`,
+			body:      `{"code":401,"description":"missing bearer token"}`,
+		}, {
+			name:      "expired",
+			header:    "Bearer " + sign(t, jose.RS256, keys.rsa, "rsa", expired),
+			code:      http.StatusUnauthorized,
+			challenge: `Bearer realm="faceit", error="invalid_token"`,
+			body:      `{"code":401,"description":"invalid token: square/go-jose/jwt: validation failed, token is expired (exp)"}`,
+		},
+	}
+	for _, test := range tests {
+		tt := test
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/users/dummy-test-user", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			assert.Equal(t, tt.code, w.Code)
+			assert.Equal(t, tt.challenge, w.Header().Get("WWW-Authenticate"))
+			if tt.code == http.StatusOK {
+				assert.Equal(t, tt.body, w.Body.String())
+			} else {
+				assert.JSONEq(t, tt.body, w.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,142 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	jose "gopkg.in/square/go-jose.v2"
+)
+
+const (
+	// RefreshInterval is how long keys are used before the JWKS is read again
+	RefreshInterval = time.Hour
+	// MinRefreshInterval limits how often a token signed by an unknown key makes the JWKS be read
+	// again, so that a rotated key is picked up without callers forcing a fetch per request
+	MinRefreshInterval = time.Minute
+
+	fetchTimeout = 5 * time.Second
+)
+
+// KeySet holds the public keys of a JWKS read from a file, or fetched from an http or https url
+type KeySet struct {
+	source  string
+	client  *http.Client
+	mu      sync.Mutex
+	keys    *jose.JSONWebKeySet
+	fetched time.Time
+	now     func() time.Time
+}
+
+// NewKeySet creates a key set read from a file path or url, the keys are loaded on first use
+func NewKeySet(source string) *KeySet {
+	return &KeySet{
+		source: source,
+		client: &http.Client{Timeout: fetchTimeout},
+		now:    time.Now,
+	}
+}
+
+// Load reads the JWKS, so that a missing or invalid key set fails at startup rather than on a request
+func (k *KeySet) Load(ctx context.Context) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.load(ctx)
+}
+
+// Keys returns the keys which may have signed a token, those with its key ID, or all of them if it
+// has none. Keys are read again once they are older than RefreshInterval, or when no key has the ID.
+// If the JWKS cannot be read again the last keys read are still used
+func (k *KeySet) Keys(ctx context.Context, kid string) ([]jose.JSONWebKey, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.keys == nil || k.now().Sub(k.fetched) > RefreshInterval {
+		err := k.reload(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+	keys := k.find(kid)
+	if len(keys) == 0 && k.now().Sub(k.fetched) > MinRefreshInterval {
+		err := k.reload(ctx)
+		if err != nil {
+			return nil, err
+		}
+		keys = k.find(kid)
+	}
+	return keys, nil
+}
+
+// reload reads the JWKS again, keeping the keys already read if that fails
+func (k *KeySet) reload(ctx context.Context) error {
+	err := k.load(ctx)
+	if err != nil && k.keys != nil {
+		log.WithFields(log.Fields{
+			"source": k.source,
+			"error":  err,
+		}).Warn("unable to refresh jwks, using the keys already read")
+		k.fetched = k.now()
+		return nil
+	}
+	return err
+}
+
+// load reads and parses the JWKS, keeping only the public signing keys
+func (k *KeySet) load(ctx context.Context) error {
+	content, err := k.read(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to read jwks %s: %v", k.source, err)
+	}
+	set := &jose.JSONWebKeySet{}
+	err = json.Unmarshal(content, set)
+	if err != nil {
+		return fmt.Errorf("unable to parse jwks %s: %v", k.source, err)
+	}
+	keys := &jose.JSONWebKeySet{}
+	for _, key := range set.Keys {
+		if key.Use == "enc" {
+			continue
+		}
+		keys.Keys = append(keys.Keys, key.Public())
+	}
+	if len(keys.Keys) == 0 {
+		return fmt.Errorf("jwks %s has no signing keys", k.source)
+	}
+	k.keys = keys
+	k.fetched = k.now()
+	return nil
+}
+
+// read returns the content of the JWKS file or url
+func (k *KeySet) read(ctx context.Context) ([]byte, error) {
+	if !strings.HasPrefix(k.source, "http://") && !strings.HasPrefix(k.source, "https://") {
+		return ioutil.ReadFile(k.source)
+	}
+	req, err := http.NewRequest(http.MethodGet, k.source, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := k.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d", res.StatusCode)
+	}
+	return ioutil.ReadAll(res.Body)
+}
+
+// find returns the keys with an ID, or all keys for an empty one
+func (k *KeySet) find(kid string) []jose.JSONWebKey {
+	if kid == "" {
+		return k.keys.Keys
+	}
+	return k.keys.Key(kid)
+}
@@ -0,0 +1,103 @@
+package auth
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeySetURL(t *testing.T) {
+	keys, remove := newTestKeys(t)
+	defer remove()
+	var fetches int32
+	failing := int32(0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		if atomic.LoadInt32(&failing) == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.Write(keys.jwks)
+	}))
+	defer server.Close()
+
+	now := time.Date(2021, 3, 14, 9, 0, 0, 0, time.UTC)
+	set := NewKeySet(server.URL)
+	set.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	assert.NoError(t, set.Load(ctx))
+	found, err := set.Keys(ctx, "rsa")
+	assert.NoError(t, err)
+	assert.Len(t, found, 1)
+	found, err = set.Keys(ctx, "")
+	assert.NoError(t, err)
+	assert.Len(t, found, 2)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&fetches))
+
+	// an unknown key is only looked for again after the minimum interval
+	found, err = set.Keys(ctx, "rotated")
+	assert.NoError(t, err)
+	assert.Empty(t, found)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&fetches))
+	now = now.Add(2 * MinRefreshInterval)
+	_, err = set.Keys(ctx, "rotated")
+	assert.NoError(t, err)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&fetches))
+
+	// keys are read again once stale, and still used if that fails
+	atomic.StoreInt32(&failing, 1)
+	now = now.Add(2 * RefreshInterval)
+	found, err = set.Keys(ctx, "ec")
+	assert.NoError(t, err)
+	assert.Len(t, found, 1)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&fetches))
+}
+
+func TestKeySetLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jwks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		err := ioutil.WriteFile(path, []byte(content), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	tests := []struct {
+		name   string
+		source string
+		err    string
+	}{
+		{name: "repository key", source: "../../testdata/auth/jwks.json"},
+		{name: "missing", source: filepath.Join(dir, "missing.json"), err: "unable to read jwks"},
+		{name: "invalid", source: write("invalid.json", `{"keys": [`), err: "unable to parse jwks"},
+		{name: "no keys", source: write("enc.json", `{"keys": []}`), err: "has no signing keys"},
+		{name: "unreachable", source: "http://127.0.0.1:1/jwks.json", err: "unable to read jwks"},
+	}
+	for _, test := range tests {
+		tt := test
+		t.Run(tt.name, func(t *testing.T) {
+			err := NewKeySet(tt.source).Load(context.Background())
+			if tt.err == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.Error(t, err)
+			assert.Contains(t, err.Error(), tt.err)
+		})
+	}
+}
@@ -0,0 +1,39 @@
+package dao
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+)
+
+// ErrInvalidCursor is returned when a pagination cursor cannot be decoded
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// cursor is the position a paginated search resumes from. All clients order or key their
+// results on the user ID, so the last returned ID is sufficient to continue from
+type cursor struct {
+	LastId string `json:"lastId"`
+}
+
+// encodeCursor converts the last returned user ID to an opaque token for the caller
+func encodeCursor(lastId string) string {
+	content, _ := json.Marshal(&cursor{LastId: lastId})
+	return base64.RawURLEncoding.EncodeToString(content)
+}
+
+// decodeCursor recovers the user ID to resume a search after, an empty token starts from the beginning
+func decodeCursor(token string) (string, error) {
+	if token == "" {
+		return "", nil
+	}
+	content, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return "", ErrInvalidCursor
+	}
+	c := &cursor{}
+	err = json.Unmarshal(content, c)
+	if err != nil || c.LastId == "" {
+		return "", ErrInvalidCursor
+	}
+	return c.LastId, nil
+}
@@ -0,0 +1,83 @@
+package dao
+
+import (
+	"context"
+	"faceit/model"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type pager interface {
+	Insert(ctx context.Context, user *model.User, msg *model.Message) error
+	Filter(ctx context.Context, conditions []*model.FilterCondition, page *model.Page) ([]*model.User, string, error)
+	GetAll(ctx context.Context, page *model.Page) ([]*model.User, string, error)
+}
+
+// assertPagination seeds the test users and walks them two at a time, checking that every
+// user is returned exactly once and the final page carries no cursor
+func assertPagination(t *testing.T, db pager) {
+	ctx := context.Background()
+	users, err := LoadSeedFile("../../testdata/users.json")
+	assert.Nil(t, err)
+	for _, user := range users {
+		assert.Nil(t, db.Insert(ctx, user, nil))
+	}
+
+	seen := map[string]bool{}
+	page := &model.Page{Limit: 2}
+	for i := 0; i < len(users); i++ {
+		results, next, err := db.GetAll(ctx, page)
+		assert.Nil(t, err)
+		for _, user := range results {
+			assert.False(t, seen[user.Id], "user returned twice: %s", user.Id)
+			seen[user.Id] = true
+		}
+		if next == "" {
+			break
+		}
+		assert.Equal(t, 2, len(results))
+		page.Cursor = next
+	}
+	assert.Equal(t, len(users), len(seen))
+
+	// the cursor also applies to filtered searches
+	conditions := []*model.FilterCondition{{Query: "country", Value: "NZL"}}
+	first, next, err := db.Filter(ctx, conditions, &model.Page{Limit: 1})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(first))
+	assert.NotEqual(t, "", next)
+	second, next, err := db.Filter(ctx, conditions, &model.Page{Limit: 1, Cursor: next})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(second))
+	assert.NotEqual(t, first[0].Id, second[0].Id)
+	assert.Equal(t, "", next)
+
+	_, _, err = db.GetAll(ctx, &model.Page{Cursor: "not a cursor"})
+	assert.Equal(t, ErrInvalidCursor, err)
+}
+
+func TestMemoryClientPagination(t *testing.T) {
+	assertPagination(t, NewMemoryClient())
+}
+
+func TestSQLClientPagination(t *testing.T) {
+	db := newTestSQLClient(t)
+	defer db.Close()
+	assertPagination(t, db)
+}
+
+func TestCursor(t *testing.T) {
+	id, err := decodeCursor(encodeCursor("27ec4aaa-6411-424a-993e-5b9a1aadf8d4"))
+	assert.Nil(t, err)
+	assert.Equal(t, "27ec4aaa-6411-424a-993e-5b9a1aadf8d4", id)
+
+	id, err = decodeCursor("")
+	assert.Nil(t, err)
+	assert.Equal(t, "", id)
+
+	for _, token := range []string{"!!", "bm90IGpzb24", "e30"} {
+		_, err = decodeCursor(token)
+		assert.Equal(t, ErrInvalidCursor, err, token)
+	}
+}
@@ -0,0 +1,159 @@
+package dao
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"faceit/model"
+)
+
+// FileDeadLetters stores dead letters as one JSON file each in a directory, for services without a
+// SQL storage
+type FileDeadLetters struct {
+	mu  sync.Mutex
+	dir string
+}
+
+// fileDeadLetter is the stored form of a dead letter, keeping the trace context of the message,
+// which is not part of its JSON
+type fileDeadLetter struct {
+	*model.DeadLetter
+	TraceContext map[string]string `json:"traceContext,omitempty"`
+}
+
+// NewFileDeadLetters creates the directory if needed and returns a store in it
+func NewFileDeadLetters(dir string) (*FileDeadLetters, error) {
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+	return &FileDeadLetters{dir: dir}, nil
+}
+
+// AddDeadLetter writes a message that could not be published to its own file
+func (db *FileDeadLetters) AddDeadLetter(ctx context.Context, letter *model.DeadLetter) error {
+	stored := &fileDeadLetter{DeadLetter: letter}
+	if letter.Message != nil {
+		stored.TraceContext = letter.Message.TraceContext
+	}
+	content, err := json.Marshal(stored)
+	if err != nil {
+		return err
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	// written aside and renamed, so a crash never leaves a partial letter
+	tmp := db.path(letter.Id) + ".tmp"
+	err = ioutil.WriteFile(tmp, content, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, db.path(letter.Id))
+}
+
+// DeadLetters returns up to limit dead letters, oldest first. A zero limit returns all of them
+func (db *FileDeadLetters) DeadLetters(ctx context.Context, limit int) ([]*model.DeadLetter, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	files, err := ioutil.ReadDir(db.dir)
+	if err != nil {
+		return nil, err
+	}
+	letters := []*model.DeadLetter{}
+	for _, file := range files {
+		if !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+		letter, err := db.read(filepath.Join(db.dir, file.Name()))
+		if err != nil {
+			return nil, err
+		}
+		letters = append(letters, letter)
+	}
+	sort.Slice(letters, func(i, j int) bool {
+		if letters[i].Failed.Equal(letters[j].Failed) {
+			return letters[i].Id < letters[j].Id
+		}
+		return letters[i].Failed.Before(letters[j].Failed)
+	})
+	if limit > 0 && len(letters) > limit {
+		letters = letters[:limit]
+	}
+	return letters, nil
+}
+
+// DeadLetterCount returns the number of dead letters, without reading them
+func (db *FileDeadLetters) DeadLetterCount(ctx context.Context) (int, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	files, err := ioutil.ReadDir(db.dir)
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, file := range files {
+		if strings.HasSuffix(file.Name(), ".json") {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// GetDeadLetter returns a single dead letter, or ErrNotFound
+func (db *FileDeadLetters) GetDeadLetter(ctx context.Context, id string) (*model.DeadLetter, error) {
+	if !validLetterId(id) {
+		return nil, ErrNotFound
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	letter, err := db.read(db.path(id))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	return letter, err
+}
+
+// RemoveDeadLetter deletes a dead letter once it has been replayed
+func (db *FileDeadLetters) RemoveDeadLetter(ctx context.Context, id string) error {
+	if !validLetterId(id) {
+		return nil
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	err := os.Remove(db.path(id))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (db *FileDeadLetters) path(id string) string {
+	return filepath.Join(db.dir, id+".json")
+}
+
+func (db *FileDeadLetters) read(path string) (*model.DeadLetter, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	stored := &fileDeadLetter{DeadLetter: &model.DeadLetter{}}
+	err = json.Unmarshal(content, stored)
+	if err != nil {
+		return nil, err
+	}
+	if stored.Message != nil {
+		stored.Message.TraceContext = stored.TraceContext
+	}
+	return stored.DeadLetter, nil
+}
+
+// validLetterId rejects ids that could address a file outside the directory
+func validLetterId(id string) bool {
+	return id != "" && !strings.ContainsAny(id, `/\.`)
+}
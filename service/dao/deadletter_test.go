@@ -0,0 +1,88 @@
+package dao
+
+import (
+	"context"
+	"faceit/model"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type deadLetters interface {
+	AddDeadLetter(ctx context.Context, letter *model.DeadLetter) error
+	DeadLetters(ctx context.Context, limit int) ([]*model.DeadLetter, error)
+	DeadLetterCount(ctx context.Context) (int, error)
+	GetDeadLetter(ctx context.Context, id string) (*model.DeadLetter, error)
+	RemoveDeadLetter(ctx context.Context, id string) error
+}
+
+// assertDeadLetters checks dead letters are listed oldest first until they are removed
+func assertDeadLetters(t *testing.T, db deadLetters) {
+	ctx := context.Background()
+	failed := time.Date(2021, 1, 4, 12, 0, 0, 0, time.UTC)
+	letters := []*model.DeadLetter{
+		{Id: "second", Target: "sns", Message: model.NewMessage("dummy-test-user", model.UserUpdate), Error: "throttled", Attempts: 5, Failed: failed.Add(time.Second)},
+		{Id: "first", Target: "webhook", Message: model.NewMessage("dummy-test-user", model.UserAdd), Error: "timeout", Attempts: 5, Failed: failed},
+	}
+	letters[0].Message.TraceContext = map[string]string{"traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"}
+	for _, letter := range letters {
+		assert.Nil(t, db.AddDeadLetter(ctx, letter))
+	}
+
+	listed, err := db.DeadLetters(ctx, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(listed))
+	assert.Equal(t, "first", listed[0].Id)
+	assert.Equal(t, "webhook", listed[0].Target)
+	assert.Equal(t, model.UserAdd, listed[0].Message.Action)
+	assert.True(t, failed.Equal(listed[0].Failed))
+
+	limited, err := db.DeadLetters(ctx, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(limited))
+
+	count, err := db.DeadLetterCount(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, count)
+
+	letter, err := db.GetDeadLetter(ctx, "second")
+	assert.Nil(t, err)
+	assert.Equal(t, "throttled", letter.Error)
+	assert.Equal(t, letters[0].Message.EventId, letter.Message.EventId)
+	// the trace context is kept, so a replay continues the trace of the original request
+	assert.Equal(t, letters[0].Message.TraceContext, letter.Message.TraceContext)
+	assert.Empty(t, listed[0].Message.TraceContext)
+	_, err = db.GetDeadLetter(ctx, "missing")
+	assert.True(t, isMissing(err))
+
+	assert.Nil(t, db.RemoveDeadLetter(ctx, "second"))
+	assert.Nil(t, db.RemoveDeadLetter(ctx, "second"))
+	listed, err = db.DeadLetters(ctx, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(listed))
+	count, err = db.DeadLetterCount(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, count)
+}
+
+func TestFileDeadLetters(t *testing.T) {
+	dir, err := ioutil.TempDir("", "deadletters")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	db, err := NewFileDeadLetters(dir)
+	assert.Nil(t, err)
+	assertDeadLetters(t, db)
+
+	// ids cannot address files outside the directory
+	_, err = db.GetDeadLetter(context.Background(), "../outside")
+	assert.True(t, isMissing(err))
+}
+
+func TestSQLClientDeadLetters(t *testing.T) {
+	db := newTestSQLClient(t)
+	defer db.Close()
+	assertDeadLetters(t, db)
+}
@@ -2,47 +2,80 @@ package dao
 
 import (
 	"context"
-	"errors"
+	"encoding/json"
 	"faceit/model"
+	"fmt"
+	"strconv"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/credentials"
-	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/aws/client"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
 	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
 )
 
+const (
+	// UsersTable is the default dynamo table holding the users, also naming the users in seed files
+	UsersTable = "faceit-users"
+	// UniqueTable is the default dynamo table of lookup items, each claiming a nickname or email for a single
+	// user. Lookups are written in the same transaction as the user, enforcing uniqueness
+	UniqueTable = "faceit-users-unique"
+	// OutboxTable is the default dynamo table of user change events, written in the same transaction as the change
+	OutboxTable = "faceit-users-outbox"
+	// OutboxPendingIndex is a sparse index of the outbox, holding only entries not yet delivered
+	OutboxPendingIndex = "pending-index"
+
+	// pendingValue marks an outbox entry as pending, the attribute is removed on delivery
+	pendingValue = "pending"
+)
+
 // DynamoClient is an extension of the AWS dynamo struct, with the general purpose methods
 // altered to specific needs of this service
 type DynamoClient struct {
 	client       *dynamodb.DynamoDB
 	table        *string
 	partitionKey string
+	uniqueTable  *string
+	uniqueKey    string
+	outboxTable  *string
+	outboxIndex  *string
 	decoder      *dynamodbattribute.Decoder
 	encoder      *dynamodbattribute.Encoder
 }
 
-// NewDynamoClient instantiates a new dynamo client object
-// For this test, this will only ever instantiate a client for use locally with the specific configuration used in testing
-func NewDynamoClient() *DynamoClient {
+// NewDynamoClient instantiates a new dynamo client object on the given tables, using the region,
+// endpoint and credentials of the given AWS session
+func NewDynamoClient(sess client.ConfigProvider, usersTable, uniqueTable, outboxTable string) *DynamoClient {
 	client := &DynamoClient{
-		table:        aws.String("faceit-users"),
+		table:        aws.String(usersTable),
 		partitionKey: "userId",
+		uniqueTable:  aws.String(uniqueTable),
+		uniqueKey:    "uniqueKey",
+		outboxTable:  aws.String(outboxTable),
+		outboxIndex:  aws.String(OutboxPendingIndex),
 	}
 	client.decoder = dynamodbattribute.NewDecoder()
 	client.encoder = dynamodbattribute.NewEncoder()
-
-	client.client = dynamodb.New(session.Must(session.NewSession(aws.NewConfig().
-		WithRegion("eu-west-1").
-		WithEndpoint("http://localstack:4566"). // Hardcoded for simplicity in task
-		WithDisableEndpointHostPrefix(true).
-		WithDisableSSL(true).
-		WithCredentials(credentials.NewStaticCredentials("dummy", "dummy", "dummy")),
-	)))
+	client.client = dynamodb.New(sess)
 	return client
 }
 
+// Check describes the users table, failing if dynamo is unreachable or the table is not active
+func (db *DynamoClient) Check(ctx context.Context) error {
+	out, err := db.client.DescribeTableWithContext(ctx, &dynamodb.DescribeTableInput{
+		TableName: db.table,
+	})
+	if err != nil {
+		return wrapAWSError(err)
+	}
+	status := aws.StringValue(out.Table.TableStatus)
+	if status != dynamodb.TableStatusActive {
+		return fmt.Errorf("table %s is %s", *db.table, status)
+	}
+	return nil
+}
+
 // Get recovers a user object from the DB given a userID
 func (db *DynamoClient) Get(ctx context.Context, id string) (*model.User, error) {
 	user := &model.User{}
@@ -55,39 +88,390 @@ func (db *DynamoClient) Get(ctx context.Context, id string) (*model.User, error)
 
 	res, err := db.client.GetItemWithContext(ctx, &input)
 	if err != nil {
-		return nil, err
+		return nil, wrapAWSError(err)
 	}
 	if len(res.Item) == 0 {
-		return nil, errors.New("no such user")
+		return nil, ErrNotFound
 	}
 	db.decode(res.Item, user)
 	return user, nil
 }
 
-// Insert takes a user object and inserts it into the DynamoDB keyed on user ID
-func (db *DynamoClient) Insert(ctx context.Context, user *model.User) error {
-	attr, err := db.encode(user)
+// Insert takes a user object and inserts it into the DynamoDB keyed on user ID. The lookup items
+// for the nickname and email are claimed, and any the user previously held released, in the
+// same transaction, so a ConflictError is returned if another user holds either.
+// The put is conditional on the stored version matching that of the user, a stale write returns
+// ErrPreconditionFailed. On success the version of the user is incremented.
+// A non-nil message is put in the outbox table in the same transaction
+func (db *DynamoClient) Insert(ctx context.Context, user *model.User, msg *model.Message) error {
+	next := *user
+	next.Version++
+	attr, err := db.encode(&next)
 	if err != nil {
 		return err
 	}
-	input := &dynamodb.PutItemInput{
-		TableName: db.table,
-		Item:      attr,
+	existing, err := db.Get(ctx, user.Id)
+	if err != nil && !isMissing(err) {
+		return err
 	}
-	_, err = db.client.PutItemWithContext(ctx, input)
-	return err
+
+	condition, names, values := db.versionCondition(user.Version)
+	items := []*dynamodb.TransactWriteItem{{
+		Put: &dynamodb.Put{
+			TableName:                 db.table,
+			Item:                      attr,
+			ConditionExpression:       condition,
+			ExpressionAttributeNames:  names,
+			ExpressionAttributeValues: values,
+		},
+	}}
+	keys := uniqueKeys(user)
+	claims, claimed := db.claimItems(user.Id, keys, uniqueFields)
+	items = append(items, claims...)
+	if existing != nil {
+		items = append(items, db.releaseItems(existing, keys)...)
+	}
+	items, err = db.enqueue(items, msg)
+	if err != nil {
+		return err
+	}
+
+	err = db.transact(ctx, items, claimed)
+	if err != nil {
+		return err
+	}
+	user.Version = next.Version
+	return nil
 }
 
-// Delete removes the entry for a given User ID
-func (db *DynamoClient) Delete(ctx context.Context, id string) error {
-	input := &dynamodb.DeleteItemInput{
-		TableName: db.table,
-		Key: map[string]*dynamodb.AttributeValue{
-			db.partitionKey: {S: aws.String(id)},
+// Update writes only the attributes of the named fields with an update expression, under the same
+// version, uniqueness and outbox rules as Insert. Lookup items are only claimed and released when the
+// nickname or email change. ErrNotFound is returned if the user is not stored
+func (db *DynamoClient) Update(ctx context.Context, user *model.User, fields []string, msg *model.Message) error {
+	err := checkUpdate(fields)
+	if err != nil {
+		return err
+	}
+	existing, err := db.Get(ctx, user.Id)
+	if err != nil {
+		return err
+	}
+	if existing.Version != user.Version {
+		return ErrPreconditionFailed
+	}
+
+	version := expression.Name("version")
+	update := expression.Set(version, expression.Value(user.Version+1))
+	for _, field := range fields {
+		value, _ := attribute(user, field)
+		update = update.Set(expression.Name(field), expression.Value(value))
+	}
+	condition := version.Equal(expression.Value(user.Version))
+	if user.Version == 0 {
+		condition = expression.Or(expression.AttributeNotExists(version), condition)
+	}
+	condition = expression.And(expression.AttributeExists(expression.Name(db.partitionKey)), condition)
+	expr, err := expression.NewBuilder().WithUpdate(update).WithCondition(condition).Build()
+	if err != nil {
+		return err
+	}
+	key := map[string]*dynamodb.AttributeValue{
+		db.partitionKey: {S: aws.String(user.Id)},
+	}
+
+	updated := *existing
+	applyUpdate(&updated, user, fields)
+	keys, previous := uniqueKeys(&updated), uniqueKeys(existing)
+	var changed []string
+	for _, field := range uniqueFields {
+		if keys[field] != previous[field] {
+			changed = append(changed, field)
+		}
+	}
+
+	items := []*dynamodb.TransactWriteItem{{
+		Update: &dynamodb.Update{
+			TableName:                 db.table,
+			Key:                       key,
+			UpdateExpression:          expr.Update(),
+			ConditionExpression:       expr.Condition(),
+			ExpressionAttributeNames:  expr.Names(),
+			ExpressionAttributeValues: expr.Values(),
 		},
+	}}
+	claims, claimed := db.claimItems(user.Id, keys, changed)
+	items = append(items, claims...)
+	items = append(items, db.releaseItems(existing, keys)...)
+	items, err = db.enqueue(items, msg)
+	if err != nil {
+		return err
+	}
+	err = db.transact(ctx, items, claimed)
+	if err != nil {
+		return err
+	}
+	user.Version++
+	return nil
+}
+
+// claimItems puts the lookup items for the given unique fields, each conditional on the key being free or
+// already held by the user. The fields claimed are returned in the order of the items
+func (db *DynamoClient) claimItems(id string, keys map[string]string, fields []string) ([]*dynamodb.TransactWriteItem, []string) {
+	var items []*dynamodb.TransactWriteItem
+	var claimed []string
+	for _, field := range fields {
+		key, ok := keys[field]
+		if !ok {
+			continue
+		}
+		claimed = append(claimed, field)
+		items = append(items, &dynamodb.TransactWriteItem{
+			Put: &dynamodb.Put{
+				TableName: db.uniqueTable,
+				Item: map[string]*dynamodb.AttributeValue{
+					db.uniqueKey:    {S: aws.String(key)},
+					db.partitionKey: {S: aws.String(id)},
+				},
+				ConditionExpression:       aws.String("attribute_not_exists(#key) OR #id = :id"),
+				ExpressionAttributeNames:  map[string]*string{"#key": aws.String(db.uniqueKey), "#id": aws.String(db.partitionKey)},
+				ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":id": {S: aws.String(id)}},
+			},
+		})
 	}
-	_, err := db.client.DeleteItemWithContext(ctx, input)
-	return err
+	return items, claimed
+}
+
+// transact writes a user item followed by its claimed lookup items. A failed condition on the user item
+// is a stale write, returned as ErrPreconditionFailed, while one on a claim is returned as a ConflictError
+func (db *DynamoClient) transact(ctx context.Context, items []*dynamodb.TransactWriteItem, claimed []string) error {
+	_, err := db.client.TransactWriteItemsWithContext(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: items,
+	})
+	if cancelled, ok := err.(*dynamodb.TransactionCanceledException); ok {
+		// reasons are in the order of the items, the user write is first followed by the claims
+		for i, reason := range cancelled.CancellationReasons {
+			if aws.StringValue(reason.Code) != "ConditionalCheckFailed" {
+				continue
+			}
+			if i == 0 {
+				return ErrPreconditionFailed
+			}
+			if i <= len(claimed) {
+				return &ConflictError{Field: claimed[i-1]}
+			}
+		}
+		return wrapCancellation(cancelled)
+	}
+	return wrapAWSError(err)
+}
+
+// Delete removes the entry for a given User ID if it is still at the given version, and releases
+// its lookup items and puts a non-nil message in the outbox in the same transaction
+func (db *DynamoClient) Delete(ctx context.Context, id string, version int64, msg *model.Message) error {
+	existing, err := db.Get(ctx, id)
+	if isMissing(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if existing.Version != version {
+		return ErrPreconditionFailed
+	}
+	condition, names, values := db.versionCondition(version)
+	items := []*dynamodb.TransactWriteItem{{
+		Delete: &dynamodb.Delete{
+			TableName: db.table,
+			Key: map[string]*dynamodb.AttributeValue{
+				db.partitionKey: {S: aws.String(id)},
+			},
+			ConditionExpression:       condition,
+			ExpressionAttributeNames:  names,
+			ExpressionAttributeValues: values,
+		},
+	}}
+	items = append(items, db.releaseItems(existing, nil)...)
+	items, err = db.enqueue(items, msg)
+	if err != nil {
+		return err
+	}
+	return db.transact(ctx, items, nil)
+}
+
+// outboxItem is the stored form of an outbox entry. The pending attribute is removed on delivery,
+// so the pending index only holds entries still to be published
+type outboxItem struct {
+	EventId   string `dynamodbav:"eventId"`
+	Message   string `dynamodbav:"message"`
+	Created   string `dynamodbav:"created"`
+	Pending   string `dynamodbav:"pending,omitempty"`
+	Attempts  int    `dynamodbav:"attempts"`
+	LastError string `dynamodbav:"lastError,omitempty"`
+	// TraceContext is the trace context of the message, which is not part of its JSON
+	TraceContext map[string]string `dynamodbav:"traceContext,omitempty"`
+}
+
+// enqueue appends a put of the message to the outbox table to a transaction, a nil message adds nothing.
+// It must be the last item, so the cancellation reasons of the user and lookup items keep their positions
+func (db *DynamoClient) enqueue(items []*dynamodb.TransactWriteItem, msg *model.Message) ([]*dynamodb.TransactWriteItem, error) {
+	if msg == nil {
+		return items, nil
+	}
+	entry := newOutboxEntry(msg)
+	message, err := json.Marshal(entry.Message)
+	if err != nil {
+		return nil, err
+	}
+	attr, err := db.encode(&outboxItem{
+		EventId:      entry.Id,
+		Message:      string(message),
+		Created:      formatOutboxTime(entry.Created),
+		Pending:      pendingValue,
+		TraceContext: msg.TraceContext,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return append(items, &dynamodb.TransactWriteItem{
+		Put: &dynamodb.Put{
+			TableName: db.outboxTable,
+			Item:      attr,
+		},
+	}), nil
+}
+
+// Pending queries the sparse pending index for up to limit undelivered outbox entries, oldest first
+func (db *DynamoClient) Pending(ctx context.Context, limit int) ([]*OutboxEntry, error) {
+	res, err := db.client.QueryWithContext(ctx, &dynamodb.QueryInput{
+		TableName:                 db.outboxTable,
+		IndexName:                 db.outboxIndex,
+		KeyConditionExpression:    aws.String("#pending = :pending"),
+		ExpressionAttributeNames:  map[string]*string{"#pending": aws.String("pending")},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":pending": {S: aws.String(pendingValue)}},
+		ScanIndexForward:          aws.Bool(true),
+		Limit:                     aws.Int64(int64(limit)),
+	})
+	if err != nil {
+		return nil, wrapAWSError(err)
+	}
+	var entries []*OutboxEntry
+	for _, attr := range res.Items {
+		item := &outboxItem{}
+		db.decode(attr, item)
+		entry := &OutboxEntry{
+			Id:        item.EventId,
+			Attempts:  item.Attempts,
+			LastError: item.LastError,
+		}
+		err = json.Unmarshal([]byte(item.Message), &entry.Message)
+		if err != nil {
+			return nil, err
+		}
+		entry.Message.TraceContext = item.TraceContext
+		entry.Created, err = time.Parse(outboxTimeLayout, item.Created)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// PendingCount counts the undelivered outbox entries in the sparse pending index
+func (db *DynamoClient) PendingCount(ctx context.Context) (int, error) {
+	count := 0
+	input := &dynamodb.QueryInput{
+		TableName:                 db.outboxTable,
+		IndexName:                 db.outboxIndex,
+		KeyConditionExpression:    aws.String("#pending = :pending"),
+		ExpressionAttributeNames:  map[string]*string{"#pending": aws.String("pending")},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":pending": {S: aws.String(pendingValue)}},
+		Select:                    aws.String(dynamodb.SelectCount),
+	}
+	err := db.client.QueryPagesWithContext(ctx, input, func(page *dynamodb.QueryOutput, last bool) bool {
+		count += int(aws.Int64Value(page.Count))
+		return true
+	})
+	if err != nil {
+		return 0, wrapAWSError(err)
+	}
+	return count, nil
+}
+
+// MarkDelivered removes an outbox entry from the pending index, recording when it was published
+func (db *DynamoClient) MarkDelivered(ctx context.Context, id string) error {
+	_, err := db.client.UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
+		TableName:                 db.outboxTable,
+		Key:                       map[string]*dynamodb.AttributeValue{"eventId": {S: aws.String(id)}},
+		UpdateExpression:          aws.String("REMOVE #pending SET #delivered = :delivered"),
+		ExpressionAttributeNames:  map[string]*string{"#pending": aws.String("pending"), "#delivered": aws.String("deliveredAt")},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":delivered": {S: aws.String(formatOutboxTime(time.Now()))}},
+	})
+	return wrapAWSError(err)
+}
+
+// MarkFailed records a failed attempt to publish an outbox entry, which remains pending
+func (db *DynamoClient) MarkFailed(ctx context.Context, id string, cause error) error {
+	_, err := db.client.UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
+		TableName:                db.outboxTable,
+		Key:                      map[string]*dynamodb.AttributeValue{"eventId": {S: aws.String(id)}},
+		UpdateExpression:         aws.String("SET #error = :error ADD #attempts :one"),
+		ExpressionAttributeNames: map[string]*string{"#error": aws.String("lastError"), "#attempts": aws.String("attempts")},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":error": {S: aws.String(cause.Error())},
+			":one":   {N: aws.String("1")},
+		},
+	})
+	return wrapAWSError(err)
+}
+
+// versionCondition builds the condition a write to a user item must meet, that the stored version
+// is the given version. Items written before versioning have no version attribute and match version 0
+func (db *DynamoClient) versionCondition(version int64) (*string, map[string]*string, map[string]*dynamodb.AttributeValue) {
+	condition := "#version = :version"
+	if version == 0 {
+		condition = "attribute_not_exists(#version) OR " + condition
+	}
+	names := map[string]*string{"#version": aws.String("version")}
+	values := map[string]*dynamodb.AttributeValue{
+		":version": {N: aws.String(strconv.FormatInt(version, 10))},
+	}
+	return aws.String(condition), names, values
+}
+
+// wrapCancellation converts a cancelled transaction to ErrThrottled when any item was rejected due to
+// load or a concurrent transaction, both of which succeed on retry
+func wrapCancellation(cancelled *dynamodb.TransactionCanceledException) error {
+	for _, reason := range cancelled.CancellationReasons {
+		switch aws.StringValue(reason.Code) {
+		case "ThrottlingError", "ProvisionedThroughputExceeded", "TransactionConflict":
+			return wrap(ErrThrottled, cancelled)
+		}
+	}
+	return cancelled
+}
+
+// releaseItems deletes the lookup items held by an existing user that are not being kept.
+// Users stored before lookups existed hold none, so a missing lookup is not a failure
+func (db *DynamoClient) releaseItems(existing *model.User, keep map[string]string) []*dynamodb.TransactWriteItem {
+	var items []*dynamodb.TransactWriteItem
+	for field, key := range uniqueKeys(existing) {
+		if keep[field] == key {
+			continue
+		}
+		items = append(items, &dynamodb.TransactWriteItem{
+			Delete: &dynamodb.Delete{
+				TableName: db.uniqueTable,
+				Key: map[string]*dynamodb.AttributeValue{
+					db.uniqueKey: {S: aws.String(key)},
+				},
+				ConditionExpression:       aws.String("attribute_not_exists(#key) OR #id = :id"),
+				ExpressionAttributeNames:  map[string]*string{"#key": aws.String(db.uniqueKey), "#id": aws.String(db.partitionKey)},
+				ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":id": {S: aws.String(existing.Id)}},
+			},
+		})
+	}
+	return items
 }
 
 // small convenience function for converting results fetched from dynamo to go structs
@@ -109,15 +493,19 @@ func (db *DynamoClient) encode(object interface{}) (map[string]*dynamodb.Attribu
 
 // Filter takes a set of filter conditions, compiles them into an expression and applied
 // that expression onto a scan of the table
-func (db *DynamoClient) Filter(ctx context.Context, conditions []*model.FilterCondition) ([]*model.User, error) {
+func (db *DynamoClient) Filter(ctx context.Context, conditions []*model.FilterCondition, page *model.Page) ([]*model.User, string, error) {
 	var filters []expression.ConditionBuilder
 	for _, condition := range conditions {
-		filters = append(filters, expression.Name(condition.Query).Equal(expression.Value(condition.Value)))
+		filter, err := toCondition(condition)
+		if err != nil {
+			return nil, "", err
+		}
+		filters = append(filters, filter)
 	}
 	filt := combineFilters(filters)
 	expr, err := expression.NewBuilder().WithFilter(filt).Build()
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 	input := &dynamodb.ScanInput{
 		TableName:                 db.table,
@@ -125,20 +513,34 @@ func (db *DynamoClient) Filter(ctx context.Context, conditions []*model.FilterCo
 		ExpressionAttributeValues: expr.Values(),
 		FilterExpression:          expr.Filter(),
 	}
-	res, err := db.client.ScanWithContext(ctx, input)
-	if err != nil {
-		return nil, err
-	}
-	if len(res.Items) == 0 {
-		return nil, nil
-	}
-	users := []*model.User{}
-	for _, item := range res.Items {
-		user := &model.User{}
-		db.decode(item, user)
-		users = append(users, user)
+	return db.scan(ctx, input, page)
+}
+
+// toCondition translates a filter condition and its operator to the equivalent dynamo condition
+func toCondition(condition *model.FilterCondition) (expression.ConditionBuilder, error) {
+	name := expression.Name(condition.Query)
+	switch condition.Operator {
+	case model.OpEqual, "":
+		return name.Equal(expression.Value(condition.Value)), nil
+	case model.OpNotEqual:
+		return name.NotEqual(expression.Value(condition.Value)), nil
+	case model.OpPrefix:
+		return name.BeginsWith(fmt.Sprint(condition.Value)), nil
+	case model.OpContains:
+		return name.Contains(fmt.Sprint(condition.Value)), nil
+	case model.OpIn:
+		values, ok := condition.Value.([]string)
+		if !ok || len(values) == 0 {
+			return expression.ConditionBuilder{}, fmt.Errorf("in filter on %s requires a list of values", condition.Query)
+		}
+		var operands []expression.OperandBuilder
+		for _, value := range values[1:] {
+			operands = append(operands, expression.Value(value))
+		}
+		return name.In(expression.Value(values[0]), operands...), nil
+	default:
+		return expression.ConditionBuilder{}, fmt.Errorf("unknown filter operator: %s", condition.Operator)
 	}
-	return users, nil
 }
 
 // combineFilters takes a list of dynamo conditions and compiles a single condition
@@ -154,22 +556,51 @@ func combineFilters(filters []expression.ConditionBuilder) expression.ConditionB
 }
 
 // GetAll performs an empty scan, to return all users
-func (db *DynamoClient) GetAll(ctx context.Context) ([]*model.User, error) {
+func (db *DynamoClient) GetAll(ctx context.Context, page *model.Page) ([]*model.User, string, error) {
 	input := &dynamodb.ScanInput{
 		TableName: db.table,
 	}
-	res, err := db.client.ScanWithContext(ctx, input)
+	return db.scan(ctx, input, page)
+}
+
+// scan follows LastEvaluatedKey across scan pages until the page limit is filled or the table
+// is exhausted. As dynamo applies the scan limit before filtering, each request only asks for
+// the number of items still required, so the last evaluated key is always a safe resume point
+func (db *DynamoClient) scan(ctx context.Context, input *dynamodb.ScanInput, page *model.Page) ([]*model.User, string, error) {
+	start, err := decodeCursor(page.Cursor)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
-	if len(res.Items) == 0 {
-		return nil, nil
+	if start != "" {
+		input.ExclusiveStartKey = map[string]*dynamodb.AttributeValue{
+			db.partitionKey: {S: aws.String(start)},
+		}
 	}
 	users := []*model.User{}
-	for _, item := range res.Items {
-		user := &model.User{}
-		db.decode(item, user)
-		users = append(users, user)
+	for {
+		if page.Limit > 0 {
+			input.Limit = aws.Int64(int64(page.Limit - len(users)))
+		}
+		res, err := db.client.ScanWithContext(ctx, input)
+		if err != nil {
+			return nil, "", wrapAWSError(err)
+		}
+		for _, item := range res.Items {
+			user := &model.User{}
+			db.decode(item, user)
+			users = append(users, user)
+		}
+		last, ok := res.LastEvaluatedKey[db.partitionKey]
+		if !ok || last.S == nil {
+			break
+		}
+		input.ExclusiveStartKey = res.LastEvaluatedKey
+		if page.Limit > 0 && len(users) >= page.Limit {
+			return users, encodeCursor(*last.S), nil
+		}
+	}
+	if len(users) == 0 {
+		return nil, "", nil
 	}
-	return users, nil
+	return users, "", nil
 }
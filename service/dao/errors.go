@@ -0,0 +1,102 @@
+package dao
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// The errors below are returned, possibly wrapped, by every user store so callers can tell failures
+// apart with errors.Is regardless of the backend in use
+var (
+	// ErrNotFound is returned when a requested user is not stored
+	ErrNotFound = errors.New("no such user")
+	// ErrConflict is returned when a write would give a user the same nickname or email as another user
+	ErrConflict = errors.New("conflict")
+	// ErrPreconditionFailed is returned when a write was based on a version of the user that is no longer stored
+	ErrPreconditionFailed = errors.New("user has been modified")
+	// ErrUnavailable is returned when the store cannot be reached or failed internally, the request may be retried
+	ErrUnavailable = errors.New("storage unavailable")
+	// ErrThrottled is returned when the store rejected the request due to load, the request should be retried later
+	ErrThrottled = errors.New("storage throttled")
+)
+
+// isMissing reports whether an error is the result of a user not being stored
+func isMissing(err error) bool {
+	return errors.Is(err, ErrNotFound)
+}
+
+// ConflictError identifies the unique field a write conflicted on, it matches ErrConflict with errors.Is
+type ConflictError struct {
+	Field string
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("%s is already in use", e.Field)
+}
+
+// Is allows errors.Is(err, ErrConflict) to match any ConflictError
+func (e *ConflictError) Is(target error) bool {
+	return target == ErrConflict
+}
+
+// wrap annotates err with one of the typed errors, keeping the original description
+func wrap(kind, err error) error {
+	return fmt.Errorf("%w: %v", kind, err)
+}
+
+// throttledCodes are the AWS error codes raised when dynamo rejects a request due to load
+var throttledCodes = map[string]bool{
+	dynamodb.ErrCodeProvisionedThroughputExceededException: true,
+	dynamodb.ErrCodeRequestLimitExceeded:                   true,
+	dynamodb.ErrCodeTransactionInProgressException:         true,
+	dynamodb.ErrCodeTransactionConflictException:           true,
+	"ThrottlingException":                                  true,
+}
+
+// unavailableCodes are the AWS error codes raised when dynamo cannot serve a request at all
+var unavailableCodes = map[string]bool{
+	dynamodb.ErrCodeInternalServerError:       true,
+	dynamodb.ErrCodeResourceNotFoundException: true,
+	request.ErrCodeRequestError:               true,
+	request.ErrCodeResponseTimeout:            true,
+	"ServiceUnavailable":                      true,
+}
+
+// wrapAWSError converts AWS errors to the typed errors of this package, errors it does not
+// recognise are returned unchanged
+func wrapAWSError(err error) error {
+	var aerr awserr.Error
+	if !errors.As(err, &aerr) {
+		return err
+	}
+	switch {
+	case throttledCodes[aerr.Code()]:
+		return wrap(ErrThrottled, err)
+	case unavailableCodes[aerr.Code()]:
+		return wrap(ErrUnavailable, err)
+	}
+	return err
+}
+
+// wrapSQLError converts database errors to the typed errors of this package, errors it does not
+// recognise are returned unchanged
+func wrapSQLError(err error) error {
+	var netErr net.Error
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, driver.ErrBadConn), errors.As(err, &netErr):
+		return wrap(ErrUnavailable, err)
+	case strings.Contains(err.Error(), "database is locked"):
+		// sqlite reports a busy database when another connection holds the write lock
+		return wrap(ErrThrottled, err)
+	}
+	return err
+}
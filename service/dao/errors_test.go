@@ -0,0 +1,83 @@
+package dao
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWrapAWSError(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected error
+	}{
+		{
+			name:     "provisioned throughput",
+			err:      awserr.New(dynamodb.ErrCodeProvisionedThroughputExceededException, "slow down", nil),
+			expected: ErrThrottled,
+		}, {
+			name:     "request limit",
+			err:      awserr.New(dynamodb.ErrCodeRequestLimitExceeded, "slow down", nil),
+			expected: ErrThrottled,
+		}, {
+			name:     "connection",
+			err:      awserr.New(request.ErrCodeRequestError, "send request failed", errors.New("connection refused")),
+			expected: ErrUnavailable,
+		}, {
+			name:     "missing table",
+			err:      awserr.New(dynamodb.ErrCodeResourceNotFoundException, "no table", nil),
+			expected: ErrUnavailable,
+		}, {
+			name: "cancelled transaction",
+			err: wrapCancellation(&dynamodb.TransactionCanceledException{
+				CancellationReasons: []*dynamodb.CancellationReason{
+					{Code: aws.String("None")},
+					{Code: aws.String("TransactionConflict")},
+				},
+			}),
+			expected: ErrThrottled,
+		},
+	}
+	for _, test := range tests {
+		tt := test
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			err := wrapAWSError(tt.err)
+			assert.True(t, errors.Is(err, tt.expected), err)
+		})
+	}
+
+	validation := awserr.New(request.InvalidParameterErrCode, "bad request", nil)
+	assert.Equal(t, validation, wrapAWSError(validation))
+	assert.Nil(t, wrapAWSError(nil))
+}
+
+func TestWrapSQLError(t *testing.T) {
+	assert.Nil(t, wrapSQLError(nil))
+	assert.True(t, errors.Is(wrapSQLError(driver.ErrBadConn), ErrUnavailable))
+	assert.True(t, errors.Is(wrapSQLError(&net.OpError{Op: "dial", Err: errors.New("connection refused")}), ErrUnavailable))
+	assert.True(t, errors.Is(wrapSQLError(errors.New("database is locked")), ErrThrottled))
+
+	syntax := errors.New("syntax error")
+	assert.Equal(t, syntax, wrapSQLError(syntax))
+}
+
+func TestNotFound(t *testing.T) {
+	ctx := context.Background()
+	_, err := NewMemoryClient().Get(ctx, "missing")
+	assert.True(t, errors.Is(err, ErrNotFound))
+
+	db := newTestSQLClient(t)
+	defer db.Close()
+	_, err = db.Get(ctx, "missing")
+	assert.True(t, errors.Is(err, ErrNotFound))
+}
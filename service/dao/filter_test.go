@@ -0,0 +1,83 @@
+package dao
+
+import (
+	"context"
+	"faceit/model"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// assertOperators seeds the test users and checks each filter operator returns the expected nicknames
+func assertOperators(t *testing.T, db pager) {
+	ctx := context.Background()
+	users, err := LoadSeedFile("../../testdata/users.json")
+	assert.Nil(t, err)
+	for _, user := range users {
+		assert.Nil(t, db.Insert(ctx, user, nil))
+	}
+
+	tests := []struct {
+		name       string
+		conditions []*model.FilterCondition
+		expected   []string
+	}{
+		{
+			name:       "equal",
+			conditions: []*model.FilterCondition{{Query: "country", Operator: model.OpEqual, Value: "FRA"}},
+			expected:   []string{"NBK-"},
+		}, {
+			name:       "not equal",
+			conditions: []*model.FilterCondition{{Query: "country", Operator: model.OpNotEqual, Value: "NZL"}},
+			expected:   []string{"NBK-", "GeT_RiGhT", "Xyp9x"},
+		}, {
+			name:       "prefix is case sensitive",
+			conditions: []*model.FilterCondition{{Query: "nickname", Operator: model.OpPrefix, Value: "Ge"}},
+			expected:   []string{"GeT_RiGhT"},
+		}, {
+			name:       "prefix without match",
+			conditions: []*model.FilterCondition{{Query: "nickname", Operator: model.OpPrefix, Value: "ge"}},
+			expected:   nil,
+		}, {
+			name:       "contains",
+			conditions: []*model.FilterCondition{{Query: "email", Operator: model.OpContains, Value: "@github"}},
+			expected:   []string{"lemming52"},
+		}, {
+			name:       "in",
+			conditions: []*model.FilterCondition{{Query: "country", Operator: model.OpIn, Value: []string{"FRA", "DNK"}}},
+			expected:   []string{"NBK-", "Xyp9x"},
+		}, {
+			name: "combined",
+			conditions: []*model.FilterCondition{
+				{Query: "country", Operator: model.OpIn, Value: []string{"NZL", "SWE"}},
+				{Query: "surname", Operator: model.OpNotEqual, Value: "s"},
+			},
+			expected: []string{"GeT_RiGhT", "Gratisfaction"},
+		},
+	}
+	for _, test := range tests {
+		tt := test
+		t.Run(tt.name, func(t *testing.T) {
+			results, _, err := db.Filter(ctx, tt.conditions, &model.Page{})
+			assert.Nil(t, err)
+			var nicknames []string
+			for _, user := range results {
+				nicknames = append(nicknames, user.Nickname)
+			}
+			assert.ElementsMatch(t, tt.expected, nicknames)
+		})
+	}
+
+	_, _, err = db.Filter(ctx, []*model.FilterCondition{{Query: "country", Operator: "like", Value: "NZL"}}, &model.Page{})
+	assert.NotNil(t, err)
+}
+
+func TestMemoryClientOperators(t *testing.T) {
+	assertOperators(t, NewMemoryClient())
+}
+
+func TestSQLClientOperators(t *testing.T) {
+	db := newTestSQLClient(t)
+	defer db.Close()
+	assertOperators(t, db)
+}
@@ -0,0 +1,355 @@
+package dao
+
+import (
+	"context"
+	"encoding/json"
+	"faceit/model"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+)
+
+// MemoryClient is an in-process store of users, intended for local runs and tests where
+// a dynamo instance is not available. It mirrors the behaviour of the DynamoClient
+type MemoryClient struct {
+	mu     sync.RWMutex
+	users  map[string]*model.User
+	unique map[string]string // unique key to the ID of the user holding it
+	outbox []*OutboxEntry    // undelivered entries only, delivered ones are dropped
+}
+
+// NewMemoryClient instantiates a new in-memory client, optionally seeded with users
+func NewMemoryClient(users ...*model.User) *MemoryClient {
+	client := &MemoryClient{
+		users:  map[string]*model.User{},
+		unique: map[string]string{},
+	}
+	for _, user := range users {
+		client.users[user.Id] = copyUser(user)
+		for _, key := range uniqueKeys(user) {
+			client.unique[key] = user.Id
+		}
+	}
+	return client
+}
+
+// Get recovers a user object from the store given a userID
+func (db *MemoryClient) Get(ctx context.Context, id string) (*model.User, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	user, ok := db.users[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return copyUser(user), nil
+}
+
+// Insert takes a user object and stores it keyed on user ID, overwriting any existing entry.
+// The user version must match the stored version, or be zero for a new user, otherwise
+// ErrPreconditionFailed is returned. On success the version of the user is incremented.
+// A ConflictError is returned if another user holds the same nickname or email.
+// A non-nil message is added to the outbox with the user
+func (db *MemoryClient) Insert(ctx context.Context, user *model.User, msg *model.Message) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	var current int64
+	if existing, ok := db.users[user.Id]; ok {
+		current = existing.Version
+	}
+	if user.Version != current {
+		return ErrPreconditionFailed
+	}
+	err := db.store(user)
+	if err != nil {
+		return err
+	}
+	db.enqueue(msg)
+	return nil
+}
+
+// Update writes only the named fields of a user, under the same version and uniqueness rules as Insert.
+// ErrNotFound is returned if the user is not stored
+func (db *MemoryClient) Update(ctx context.Context, user *model.User, fields []string, msg *model.Message) error {
+	err := checkUpdate(fields)
+	if err != nil {
+		return err
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	existing, ok := db.users[user.Id]
+	if !ok {
+		return ErrNotFound
+	}
+	if existing.Version != user.Version {
+		return ErrPreconditionFailed
+	}
+	updated := copyUser(existing)
+	applyUpdate(updated, user, fields)
+	err = db.store(updated)
+	if err != nil {
+		return err
+	}
+	user.Version = updated.Version
+	db.enqueue(msg)
+	return nil
+}
+
+// store claims the unique keys of a user and writes it with an incremented version. The caller must
+// hold the write lock and have checked the version
+func (db *MemoryClient) store(user *model.User) error {
+	keys := uniqueKeys(user)
+	for _, field := range uniqueFields {
+		holder, ok := db.unique[keys[field]]
+		if ok && holder != user.Id {
+			return &ConflictError{Field: field}
+		}
+	}
+	db.release(user.Id)
+	for _, key := range keys {
+		db.unique[key] = user.Id
+	}
+	user.Version++
+	db.users[user.Id] = copyUser(user)
+	return nil
+}
+
+// Delete removes the entry for a given User ID if it is still at the given version.
+// A non-nil message is added to the outbox if the user is removed
+func (db *MemoryClient) Delete(ctx context.Context, id string, version int64, msg *model.Message) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	existing, ok := db.users[id]
+	if !ok {
+		return nil
+	}
+	if existing.Version != version {
+		return ErrPreconditionFailed
+	}
+	db.release(id)
+	delete(db.users, id)
+	db.enqueue(msg)
+	return nil
+}
+
+// enqueue adds a message to the outbox, the caller must hold the write lock
+func (db *MemoryClient) enqueue(msg *model.Message) {
+	if msg != nil {
+		db.outbox = append(db.outbox, newOutboxEntry(msg))
+	}
+}
+
+// Pending returns up to limit undelivered outbox entries, oldest first
+func (db *MemoryClient) Pending(ctx context.Context, limit int) ([]*OutboxEntry, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	var entries []*OutboxEntry
+	for _, entry := range db.outbox {
+		if len(entries) == limit {
+			break
+		}
+		c := *entry
+		entries = append(entries, &c)
+	}
+	return entries, nil
+}
+
+// PendingCount returns the number of undelivered outbox entries
+func (db *MemoryClient) PendingCount(ctx context.Context) (int, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return len(db.outbox), nil
+}
+
+// MarkDelivered drops a published entry from the outbox, so it is no longer pending
+func (db *MemoryClient) MarkDelivered(ctx context.Context, id string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	for i, entry := range db.outbox {
+		if entry.Id == id {
+			db.outbox = append(db.outbox[:i], db.outbox[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+// MarkFailed records a failed attempt to publish an outbox entry, which remains pending
+func (db *MemoryClient) MarkFailed(ctx context.Context, id string, cause error) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	for _, entry := range db.outbox {
+		if entry.Id == id {
+			entry.Attempts++
+			entry.LastError = cause.Error()
+		}
+	}
+	return nil
+}
+
+// release frees the unique keys held by a stored user, the caller must hold the write lock
+func (db *MemoryClient) release(id string) {
+	existing, ok := db.users[id]
+	if !ok {
+		return
+	}
+	for _, key := range uniqueKeys(existing) {
+		delete(db.unique, key)
+	}
+}
+
+// Filter returns the users that exactly match every one of the provided conditions, ordered by user ID
+func (db *MemoryClient) Filter(ctx context.Context, conditions []*model.FilterCondition, page *model.Page) ([]*model.User, string, error) {
+	start, err := decodeCursor(page.Cursor)
+	if err != nil {
+		return nil, "", err
+	}
+	for _, condition := range conditions {
+		switch condition.Operator {
+		case model.OpEqual, model.OpNotEqual, model.OpPrefix, model.OpContains, model.OpIn, "":
+		default:
+			return nil, "", fmt.Errorf("unknown filter operator: %s", condition.Operator)
+		}
+	}
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	ids := make([]string, 0, len(db.users))
+	for id := range db.users {
+		if id > start {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	var users []*model.User
+	for _, id := range ids {
+		user := db.users[id]
+		if !matches(user, conditions) {
+			continue
+		}
+		if page.Limit > 0 && len(users) == page.Limit {
+			return users, encodeCursor(users[len(users)-1].Id), nil
+		}
+		users = append(users, copyUser(user))
+	}
+	return users, "", nil
+}
+
+// GetAll returns all stored users, ordered by user ID
+func (db *MemoryClient) GetAll(ctx context.Context, page *model.Page) ([]*model.User, string, error) {
+	return db.Filter(ctx, nil, page)
+}
+
+// matches checks a user against a set of conditions, an unknown attribute never matches
+func matches(user *model.User, conditions []*model.FilterCondition) bool {
+	for _, condition := range conditions {
+		value, ok := attribute(user, condition.Query)
+		if !ok || !compare(value, condition) {
+			return false
+		}
+	}
+	return true
+}
+
+// compare applies the operator of a condition to an attribute value
+func compare(value string, condition *model.FilterCondition) bool {
+	switch condition.Operator {
+	case model.OpEqual, "":
+		return value == condition.Value
+	case model.OpNotEqual:
+		return value != condition.Value
+	case model.OpPrefix:
+		return strings.HasPrefix(value, fmt.Sprint(condition.Value))
+	case model.OpContains:
+		return strings.Contains(value, fmt.Sprint(condition.Value))
+	case model.OpIn:
+		values, _ := condition.Value.([]string)
+		for _, v := range values {
+			if value == v {
+				return true
+			}
+		}
+		return false
+	default:
+		return false
+	}
+}
+
+// attribute returns the value of a user field by its stored attribute name
+func attribute(user *model.User, name string) (string, bool) {
+	switch name {
+	case "userId":
+		return user.Id, true
+	case "forename":
+		return user.Forename, true
+	case "surname":
+		return user.Surname, true
+	case "nickname":
+		return user.Nickname, true
+	case "password":
+		return user.Password, true
+	case "email":
+		return user.Email, true
+	case "country":
+		return user.Country, true
+	default:
+		return "", false
+	}
+}
+
+// setAttribute is the counterpart of attribute, names that are not string attributes are ignored
+func setAttribute(user *model.User, name, value string) {
+	switch name {
+	case "forename":
+		user.Forename = value
+	case "surname":
+		user.Surname = value
+	case "nickname":
+		user.Nickname = value
+	case "password":
+		user.Password = value
+	case "email":
+		user.Email = value
+	case "country":
+		user.Country = value
+	}
+}
+
+// copyUser prevents callers from mutating the stored entries through shared pointers
+func copyUser(user *model.User) *model.User {
+	c := *user
+	return &c
+}
+
+// LoadSeedFile reads users from a dynamo batch-write-item request file, such as the one
+// used by localstack.sh, so the memory store can be populated with the same test data.
+// Requests for tables other than the users table are ignored
+func LoadSeedFile(path string) ([]*model.User, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	requests := map[string][]struct {
+		PutRequest struct {
+			Item map[string]*dynamodb.AttributeValue
+		}
+	}{}
+	err = json.Unmarshal(content, &requests)
+	if err != nil {
+		return nil, err
+	}
+	users := []*model.User{}
+	for _, request := range requests[UsersTable] {
+		user := &model.User{}
+		err = dynamodbattribute.UnmarshalMap(request.PutRequest.Item, user)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, nil
+}
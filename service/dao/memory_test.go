@@ -0,0 +1,82 @@
+package dao
+
+import (
+	"context"
+	"faceit/model"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryClient(t *testing.T) {
+	ctx := context.Background()
+	db := NewMemoryClient(&model.User{
+		Id:       "dummy-test-user",
+		Forename: "Nicolai",
+		Surname:  "Reedtz",
+		Nickname: "dev1ce",
+		Email:    "nr@notarealemail.com",
+		Country:  "DEN",
+	})
+
+	user, err := db.Get(ctx, "dummy-test-user")
+	assert.Nil(t, err)
+	assert.Equal(t, "dev1ce", user.Nickname)
+
+	// returned users are copies, mutating them does not alter the store
+	user.Nickname = "changed"
+	stored, err := db.Get(ctx, "dummy-test-user")
+	assert.Nil(t, err)
+	assert.Equal(t, "dev1ce", stored.Nickname)
+
+	_, err = db.Get(ctx, "missing")
+	assert.NotNil(t, err)
+
+	results, _, err := db.Filter(ctx, []*model.FilterCondition{{Query: "country", Value: "DEN"}}, &model.Page{})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(results))
+
+	results, _, err = db.Filter(ctx, []*model.FilterCondition{{Query: "country", Value: "FRA"}}, &model.Page{})
+	assert.Nil(t, err)
+	assert.Nil(t, results)
+
+	err = db.Delete(ctx, "dummy-test-user", 0, nil)
+	assert.Nil(t, err)
+	results, _, err = db.GetAll(ctx, &model.Page{})
+	assert.Nil(t, err)
+	assert.Nil(t, results)
+}
+
+func TestMemoryClientConcurrent(t *testing.T) {
+	ctx := context.Background()
+	db := NewMemoryClient()
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			id := fmt.Sprintf("user-%d", i)
+			assert.Nil(t, db.Insert(ctx, &model.User{Id: id, Country: "FRA"}, nil))
+			_, err := db.Get(ctx, id)
+			assert.Nil(t, err)
+			_, _, err = db.Filter(ctx, []*model.FilterCondition{{Query: "country", Value: "FRA"}}, &model.Page{})
+			assert.Nil(t, err)
+		}(i)
+	}
+	wg.Wait()
+	results, _, err := db.GetAll(ctx, &model.Page{})
+	assert.Nil(t, err)
+	assert.Equal(t, 50, len(results))
+}
+
+func TestLoadSeedFile(t *testing.T) {
+	users, err := LoadSeedFile("../../testdata/users.json")
+	assert.Nil(t, err)
+	assert.Equal(t, 5, len(users))
+	db := NewMemoryClient(users...)
+	user, err := db.Get(context.Background(), "testing")
+	assert.Nil(t, err)
+	assert.Equal(t, "lemming52", user.Nickname)
+}
@@ -0,0 +1,53 @@
+package dao
+
+import (
+	"encoding/json"
+	"faceit/model"
+	"time"
+)
+
+// outboxTimeLayout is a fixed width timestamp, so stored creation times sort in order as strings
+const outboxTimeLayout = "2006-01-02T15:04:05.000000000Z"
+
+// OutboxEntry is an event written in the same transaction as the user change it describes. It stays
+// pending until the relay has published it and marked it delivered
+type OutboxEntry struct {
+	Id        string
+	Message   *model.Message
+	Created   time.Time
+	Attempts  int
+	LastError string
+}
+
+// newOutboxEntry wraps a message for storage in the outbox, keyed by its event Id
+func newOutboxEntry(msg *model.Message) *OutboxEntry {
+	return &OutboxEntry{
+		Id:      msg.EventId,
+		Message: msg,
+		Created: time.Now().UTC(),
+	}
+}
+
+// formatOutboxTime converts a creation time to its stored form
+func formatOutboxTime(t time.Time) string {
+	return t.UTC().Format(outboxTimeLayout)
+}
+
+// marshalTraceContext converts the trace context of a message to its stored form, empty if there is none
+func marshalTraceContext(trace map[string]string) (string, error) {
+	if len(trace) == 0 {
+		return "", nil
+	}
+	data, err := json.Marshal(trace)
+	return string(data), err
+}
+
+// unmarshalTraceContext reads a stored trace context
+func unmarshalTraceContext(data string) (map[string]string, error) {
+	if data == "" {
+		return nil, nil
+	}
+	trace := map[string]string{}
+	err := json.Unmarshal([]byte(data), &trace)
+	return trace, err
+}
@@ -0,0 +1,103 @@
+package dao
+
+import (
+	"context"
+	"errors"
+	"faceit/model"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type outbox interface {
+	updater
+	Pending(ctx context.Context, limit int) ([]*OutboxEntry, error)
+	MarkDelivered(ctx context.Context, id string) error
+	MarkFailed(ctx context.Context, id string, cause error) error
+	PendingCount(ctx context.Context) (int, error)
+}
+
+// assertOutbox checks a message is only stored when the user change it describes is written, and
+// stays pending in write order until it is marked delivered
+func assertOutbox(t *testing.T, db outbox) {
+	ctx := context.Background()
+	user := &model.User{Id: "outbox", Nickname: "ZywOo", Country: "FRA"}
+	add := model.NewMessage(user.Id, model.UserAdd)
+	add.TraceContext = map[string]string{"traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"}
+	assert.Nil(t, db.Insert(ctx, user, add))
+	assert.Nil(t, db.Insert(ctx, &model.User{Id: "other", Nickname: "apEX"}, nil))
+
+	// rejected writes leave nothing behind
+	assert.Equal(t, ErrPreconditionFailed, db.Insert(ctx, &model.User{Id: user.Id}, model.NewMessage(user.Id, model.UserUpdate)))
+	assert.Equal(t, &ConflictError{Field: "nickname"}, db.Insert(ctx, &model.User{Id: "third", Nickname: "apex"}, model.NewMessage("third", model.UserAdd)))
+	assert.Equal(t, ErrPreconditionFailed, db.Delete(ctx, user.Id, 7, model.NewMessage(user.Id, model.UserDelete)))
+
+	update := model.NewMessage(user.Id, model.UserUpdate)
+	update.Fields = []string{"country"}
+	user.Country = "BEL"
+	assert.Nil(t, db.Update(ctx, user, []string{"country"}, update))
+	assert.Nil(t, db.Delete(ctx, user.Id, user.Version, model.NewMessage(user.Id, model.UserDelete)))
+
+	pending, err := db.Pending(ctx, 10)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(pending))
+	actions := []string{}
+	for _, entry := range pending {
+		assert.Equal(t, user.Id, entry.Message.Id)
+		actions = append(actions, entry.Message.Action)
+	}
+	assert.Equal(t, []string{model.UserAdd, model.UserUpdate, model.UserDelete}, actions)
+	assert.Equal(t, []string{"country"}, pending[1].Message.Fields)
+	// the trace context is stored alongside the message
+	assert.Equal(t, add.TraceContext, pending[0].Message.TraceContext)
+	assert.Empty(t, pending[1].Message.TraceContext)
+	count, err := db.PendingCount(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, count)
+
+	limited, err := db.Pending(ctx, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(limited))
+	assert.Equal(t, pending[0].Id, limited[0].Id)
+
+	assert.Nil(t, db.MarkFailed(ctx, pending[0].Id, errors.New("topic unavailable")))
+	assert.Nil(t, db.MarkFailed(ctx, pending[0].Id, errors.New("topic unavailable")))
+	assert.Nil(t, db.MarkDelivered(ctx, pending[1].Id))
+
+	remaining, err := db.Pending(ctx, 10)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(remaining))
+	assert.Equal(t, pending[0].Id, remaining[0].Id)
+	assert.Equal(t, 2, remaining[0].Attempts)
+	assert.Equal(t, "topic unavailable", remaining[0].LastError)
+	assert.Equal(t, pending[2].Id, remaining[1].Id)
+	count, err = db.PendingCount(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, count)
+}
+
+func TestMemoryClientOutbox(t *testing.T) {
+	assertOutbox(t, NewMemoryClient())
+}
+
+func TestMemoryClientOutboxDropsDelivered(t *testing.T) {
+	ctx := context.Background()
+	db := NewMemoryClient()
+	for _, id := range []string{"a", "b", "c"} {
+		assert.Nil(t, db.Insert(ctx, &model.User{Id: id, Nickname: id}, model.NewMessage(id, model.UserAdd)))
+	}
+	pending, err := db.Pending(ctx, 10)
+	assert.Nil(t, err)
+	for _, entry := range pending {
+		assert.Nil(t, db.MarkDelivered(ctx, entry.Id))
+	}
+	// delivering an unknown or already delivered entry is a no-op
+	assert.Nil(t, db.MarkDelivered(ctx, pending[0].Id))
+	assert.Empty(t, db.outbox)
+}
+
+func TestSQLClientOutbox(t *testing.T) {
+	db := newTestSQLClient(t)
+	defer db.Close()
+	assertOutbox(t, db)
+}
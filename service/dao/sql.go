@@ -0,0 +1,595 @@
+package dao
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"faceit/model"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// PostgresDriver is the database/sql driver name registered by github.com/lib/pq
+	PostgresDriver = "postgres"
+	// SQLiteDriver is the database/sql driver name registered by github.com/mattn/go-sqlite3
+	SQLiteDriver = "sqlite3"
+)
+
+// migrations are applied in order, each exactly once, and recorded in the schema_migrations table.
+// Existing entries must never be altered, changes to the schema are made by appending a new entry
+var migrations = []string{
+	`CREATE TABLE users (
+		user_id  TEXT PRIMARY KEY,
+		forename TEXT NOT NULL DEFAULT '',
+		surname  TEXT NOT NULL DEFAULT '',
+		nickname TEXT NOT NULL DEFAULT '',
+		password TEXT NOT NULL DEFAULT '',
+		email    TEXT NOT NULL DEFAULT '',
+		country  TEXT NOT NULL DEFAULT ''
+	)`,
+	`CREATE UNIQUE INDEX users_nickname_key ON users (lower(nickname)) WHERE nickname <> ''`,
+	`CREATE UNIQUE INDEX users_email_key ON users (lower(email)) WHERE email <> ''`,
+	`ALTER TABLE users ADD COLUMN version BIGINT NOT NULL DEFAULT 0`,
+	`CREATE TABLE outbox (
+		event_id     TEXT PRIMARY KEY,
+		message      TEXT NOT NULL,
+		created_at   TEXT NOT NULL,
+		attempts     INTEGER NOT NULL DEFAULT 0,
+		last_error   TEXT NOT NULL DEFAULT '',
+		delivered_at TEXT
+	)`,
+	`CREATE INDEX outbox_pending ON outbox (created_at, event_id) WHERE delivered_at IS NULL`,
+	`CREATE TABLE dead_letters (
+		id        TEXT PRIMARY KEY,
+		target    TEXT NOT NULL,
+		message   TEXT NOT NULL,
+		error     TEXT NOT NULL,
+		attempts  INTEGER NOT NULL,
+		failed_at TEXT NOT NULL
+	)`,
+	`ALTER TABLE outbox ADD COLUMN trace_context TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE dead_letters ADD COLUMN trace_context TEXT NOT NULL DEFAULT ''`,
+}
+
+// uniqueIndexes maps the unique index names to the field they enforce. Drivers report a violation
+// with different error types, but both name the index in the message
+var uniqueIndexes = map[string]string{
+	"users_nickname_key": "nickname",
+	"users_email_key":    "email",
+}
+
+// columns maps the stored attribute names used in filter conditions to the table columns.
+// It doubles as the allow list of columns that may appear in generated queries
+var columns = map[string]string{
+	"userId":   "user_id",
+	"forename": "forename",
+	"surname":  "surname",
+	"nickname": "nickname",
+	"password": "password",
+	"email":    "email",
+	"country":  "country",
+}
+
+const userColumns = "user_id, forename, surname, nickname, password, email, country, version"
+
+// SQLClient stores users in a relational database through database/sql, it is tested against
+// SQLite and intended for use with Postgres
+type SQLClient struct {
+	db     *sql.DB
+	driver string
+}
+
+// NewSQLClient opens a connection to the given database and migrates the schema to the latest version.
+// The driver must be registered by the caller, e.g. by importing github.com/lib/pq
+func NewSQLClient(ctx context.Context, driver, dsn string) (*SQLClient, error) {
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	if driver == SQLiteDriver {
+		// SQLite only allows a single writer, and each connection to an in-memory database is distinct
+		db.SetMaxOpenConns(1)
+	}
+	client := &SQLClient{
+		db:     db,
+		driver: driver,
+	}
+	err = client.migrate(ctx)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return client, nil
+}
+
+// Close releases the underlying database connections
+func (db *SQLClient) Close() error {
+	return db.db.Close()
+}
+
+// Check pings the database, failing if it is unreachable
+func (db *SQLClient) Check(ctx context.Context) error {
+	return wrapSQLError(db.db.PingContext(ctx))
+}
+
+// migrate applies any migrations newer than the current schema version, each in its own transaction
+func (db *SQLClient) migrate(ctx context.Context) error {
+	_, err := db.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER PRIMARY KEY)`)
+	if err != nil {
+		return err
+	}
+	var current int
+	err = db.db.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&current)
+	if err != nil {
+		return err
+	}
+	for i := current; i < len(migrations); i++ {
+		tx, err := db.db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, migrations[i])
+		if err == nil {
+			_, err = tx.ExecContext(ctx, db.rebind(`INSERT INTO schema_migrations (version) VALUES (?)`), i+1)
+		}
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("unable to apply migration %d: %v", i+1, err)
+		}
+		err = tx.Commit()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Get recovers a user object from the DB given a userID
+func (db *SQLClient) Get(ctx context.Context, id string) (*model.User, error) {
+	row := db.db.QueryRowContext(ctx, db.rebind(`SELECT `+userColumns+` FROM users WHERE user_id = ?`), id)
+	user, err := scanUser(row)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, wrapSQLError(err)
+	}
+	return user, nil
+}
+
+// Insert takes a user object and stores it keyed on user ID, replacing any existing row.
+// The user version must match the stored version, or be zero for a new user, otherwise
+// ErrPreconditionFailed is returned. On success the version of the user is incremented.
+// A ConflictError is returned if another user holds the same nickname or email.
+// A non-nil message is added to the outbox in the same transaction
+func (db *SQLClient) Insert(ctx context.Context, user *model.User, msg *model.Message) error {
+	next := user.Version + 1
+	var err error
+	if user.Version == 0 {
+		// users stored before versioning hold version 0, so may also be replaced
+		query := `INSERT INTO users (` + userColumns + `) VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT (user_id) DO UPDATE SET
+				forename = excluded.forename,
+				surname = excluded.surname,
+				nickname = excluded.nickname,
+				password = excluded.password,
+				email = excluded.email,
+				country = excluded.country,
+				version = excluded.version
+			WHERE users.version = 0`
+		err = db.write(ctx, msg, query,
+			user.Id, user.Forename, user.Surname, user.Nickname, user.Password, user.Email, user.Country, next)
+	} else {
+		query := `UPDATE users SET forename = ?, surname = ?, nickname = ?, password = ?, email = ?, country = ?, version = ?
+			WHERE user_id = ? AND version = ?`
+		err = db.write(ctx, msg, query,
+			user.Forename, user.Surname, user.Nickname, user.Password, user.Email, user.Country, next, user.Id, user.Version)
+	}
+	if err == errNoRows {
+		return ErrPreconditionFailed
+	}
+	if err != nil {
+		return err
+	}
+	user.Version = next
+	return nil
+}
+
+// uniqueViolation converts a unique index violation to a ConflictError, other errors are unchanged
+func uniqueViolation(err error) error {
+	if err == nil {
+		return nil
+	}
+	for index, field := range uniqueIndexes {
+		if strings.Contains(err.Error(), index) {
+			return &ConflictError{Field: field}
+		}
+	}
+	return err
+}
+
+// Update writes only the columns of the named fields, under the same version, uniqueness and outbox
+// rules as Insert. ErrNotFound is returned if the user is not stored
+func (db *SQLClient) Update(ctx context.Context, user *model.User, fields []string, msg *model.Message) error {
+	err := checkUpdate(fields)
+	if err != nil {
+		return err
+	}
+	var assignments []string
+	var args []interface{}
+	for _, field := range fields {
+		value, _ := attribute(user, field)
+		assignments = append(assignments, columns[field]+" = ?")
+		args = append(args, value)
+	}
+	next := user.Version + 1
+	assignments = append(assignments, "version = ?")
+	args = append(args, next, user.Id, user.Version)
+	query := `UPDATE users SET ` + strings.Join(assignments, ", ") + ` WHERE user_id = ? AND version = ?`
+	err = db.write(ctx, msg, query, args...)
+	if err == errNoRows {
+		// nothing was updated, either the user is missing or it has been modified
+		_, err = db.Get(ctx, user.Id)
+		if err != nil {
+			return err
+		}
+		return ErrPreconditionFailed
+	}
+	if err != nil {
+		return err
+	}
+	user.Version = next
+	return nil
+}
+
+// Delete removes the row for a given User ID if it is still at the given version.
+// A non-nil message is added to the outbox in the same transaction
+func (db *SQLClient) Delete(ctx context.Context, id string, version int64, msg *model.Message) error {
+	err := db.write(ctx, msg, `DELETE FROM users WHERE user_id = ? AND version = ?`, id, version)
+	if err != errNoRows {
+		return err
+	}
+	// nothing was deleted, either the user is already gone or it has been modified
+	_, err = db.Get(ctx, id)
+	if isMissing(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return ErrPreconditionFailed
+}
+
+// errNoRows is returned by write when the statement matched no rows
+var errNoRows = errors.New("no rows written")
+
+// write executes a statement on the users table and, if it changed a row, adds the message to the outbox
+// in the same transaction. errNoRows is returned, and nothing committed, if no row was changed
+func (db *SQLClient) write(ctx context.Context, msg *model.Message, query string, args ...interface{}) error {
+	tx, err := db.db.BeginTx(ctx, nil)
+	if err != nil {
+		return wrapSQLError(err)
+	}
+	res, err := tx.ExecContext(ctx, db.rebind(query), args...)
+	if err != nil {
+		tx.Rollback()
+		return wrapSQLError(uniqueViolation(err))
+	}
+	rows, err := res.RowsAffected()
+	if err == nil && rows == 0 {
+		err = errNoRows
+	}
+	if err == nil && msg != nil {
+		err = db.enqueue(ctx, tx, newOutboxEntry(msg))
+	}
+	if err != nil {
+		tx.Rollback()
+		if err == errNoRows {
+			return err
+		}
+		return wrapSQLError(err)
+	}
+	return wrapSQLError(tx.Commit())
+}
+
+// enqueue inserts an outbox entry within a transaction
+func (db *SQLClient) enqueue(ctx context.Context, tx *sql.Tx, entry *OutboxEntry) error {
+	message, err := json.Marshal(entry.Message)
+	if err != nil {
+		return err
+	}
+	trace, err := marshalTraceContext(entry.Message.TraceContext)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, db.rebind(`INSERT INTO outbox (event_id, message, created_at, trace_context) VALUES (?, ?, ?, ?)`),
+		entry.Id, string(message), formatOutboxTime(entry.Created), trace)
+	return err
+}
+
+// Pending returns up to limit undelivered outbox entries, oldest first
+func (db *SQLClient) Pending(ctx context.Context, limit int) ([]*OutboxEntry, error) {
+	query := `SELECT event_id, message, created_at, attempts, last_error, trace_context FROM outbox
+		WHERE delivered_at IS NULL ORDER BY created_at, event_id LIMIT ?`
+	rows, err := db.db.QueryContext(ctx, db.rebind(query), limit)
+	if err != nil {
+		return nil, wrapSQLError(err)
+	}
+	defer rows.Close()
+	var entries []*OutboxEntry
+	for rows.Next() {
+		entry := &OutboxEntry{}
+		var message, created, trace string
+		err = rows.Scan(&entry.Id, &message, &created, &entry.Attempts, &entry.LastError, &trace)
+		if err != nil {
+			return nil, err
+		}
+		err = json.Unmarshal([]byte(message), &entry.Message)
+		if err != nil {
+			return nil, err
+		}
+		entry.Message.TraceContext, err = unmarshalTraceContext(trace)
+		if err != nil {
+			return nil, err
+		}
+		entry.Created, err = time.Parse(outboxTimeLayout, created)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, wrapSQLError(rows.Err())
+}
+
+// PendingCount returns the number of undelivered outbox entries
+func (db *SQLClient) PendingCount(ctx context.Context) (int, error) {
+	var count int
+	err := db.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM outbox WHERE delivered_at IS NULL`).Scan(&count)
+	if err != nil {
+		return 0, wrapSQLError(err)
+	}
+	return count, nil
+}
+
+// MarkDelivered records an outbox entry as published, so it is no longer pending
+func (db *SQLClient) MarkDelivered(ctx context.Context, id string) error {
+	_, err := db.db.ExecContext(ctx, db.rebind(`UPDATE outbox SET delivered_at = ? WHERE event_id = ?`),
+		formatOutboxTime(time.Now()), id)
+	return wrapSQLError(err)
+}
+
+// MarkFailed records a failed attempt to publish an outbox entry, which remains pending
+func (db *SQLClient) MarkFailed(ctx context.Context, id string, cause error) error {
+	_, err := db.db.ExecContext(ctx, db.rebind(`UPDATE outbox SET attempts = attempts + 1, last_error = ? WHERE event_id = ?`),
+		cause.Error(), id)
+	return wrapSQLError(err)
+}
+
+// AddDeadLetter stores a message that could not be published
+func (db *SQLClient) AddDeadLetter(ctx context.Context, letter *model.DeadLetter) error {
+	message, err := json.Marshal(letter.Message)
+	if err != nil {
+		return err
+	}
+	trace, err := marshalTraceContext(letter.Message.TraceContext)
+	if err != nil {
+		return err
+	}
+	_, err = db.db.ExecContext(ctx, db.rebind(`INSERT INTO dead_letters (id, target, message, error, attempts, failed_at, trace_context) VALUES (?, ?, ?, ?, ?, ?, ?)`),
+		letter.Id, letter.Target, string(message), letter.Error, letter.Attempts, formatOutboxTime(letter.Failed), trace)
+	return wrapSQLError(err)
+}
+
+// DeadLetters returns up to limit dead letters, oldest first. A zero limit returns all of them
+func (db *SQLClient) DeadLetters(ctx context.Context, limit int) ([]*model.DeadLetter, error) {
+	query := deadLetterQuery + ` ORDER BY failed_at, id`
+	args := []interface{}{}
+	if limit > 0 {
+		query += ` LIMIT ?`
+		args = append(args, limit)
+	}
+	rows, err := db.db.QueryContext(ctx, db.rebind(query), args...)
+	if err != nil {
+		return nil, wrapSQLError(err)
+	}
+	defer rows.Close()
+	letters := []*model.DeadLetter{}
+	for rows.Next() {
+		letter, err := scanDeadLetter(rows)
+		if err != nil {
+			return nil, err
+		}
+		letters = append(letters, letter)
+	}
+	return letters, wrapSQLError(rows.Err())
+}
+
+// DeadLetterCount returns the number of dead letters
+func (db *SQLClient) DeadLetterCount(ctx context.Context) (int, error) {
+	var count int
+	err := db.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM dead_letters`).Scan(&count)
+	if err != nil {
+		return 0, wrapSQLError(err)
+	}
+	return count, nil
+}
+
+// GetDeadLetter returns a single dead letter, or ErrNotFound
+func (db *SQLClient) GetDeadLetter(ctx context.Context, id string) (*model.DeadLetter, error) {
+	row := db.db.QueryRowContext(ctx, db.rebind(deadLetterQuery+` WHERE id = ?`), id)
+	letter, err := scanDeadLetter(row)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	return letter, wrapSQLError(err)
+}
+
+// RemoveDeadLetter deletes a dead letter once it has been replayed
+func (db *SQLClient) RemoveDeadLetter(ctx context.Context, id string) error {
+	_, err := db.db.ExecContext(ctx, db.rebind(`DELETE FROM dead_letters WHERE id = ?`), id)
+	return wrapSQLError(err)
+}
+
+const deadLetterQuery = `SELECT id, target, message, error, attempts, failed_at, trace_context FROM dead_letters`
+
+// scanDeadLetter reads a dead letter from a row of the dead_letters table
+func scanDeadLetter(row scanner) (*model.DeadLetter, error) {
+	letter := &model.DeadLetter{}
+	var message, failed, trace string
+	err := row.Scan(&letter.Id, &letter.Target, &message, &letter.Error, &letter.Attempts, &failed, &trace)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal([]byte(message), &letter.Message)
+	if err != nil {
+		return nil, err
+	}
+	letter.Message.TraceContext, err = unmarshalTraceContext(trace)
+	if err != nil {
+		return nil, err
+	}
+	letter.Failed, err = time.Parse(outboxTimeLayout, failed)
+	if err != nil {
+		return nil, err
+	}
+	return letter, nil
+}
+
+// Filter compiles the filter conditions into a parameterised WHERE clause, paging on the user ID
+func (db *SQLClient) Filter(ctx context.Context, conditions []*model.FilterCondition, page *model.Page) ([]*model.User, string, error) {
+	start, err := decodeCursor(page.Cursor)
+	if err != nil {
+		return nil, "", err
+	}
+	var clauses []string
+	var args []interface{}
+	for _, condition := range conditions {
+		clause, values, err := db.toClause(condition)
+		if err != nil {
+			return nil, "", err
+		}
+		clauses = append(clauses, clause)
+		args = append(args, values...)
+	}
+	if start != "" {
+		clauses = append(clauses, "user_id > ?")
+		args = append(args, start)
+	}
+	query := `SELECT ` + userColumns + ` FROM users`
+	if len(clauses) > 0 {
+		query += ` WHERE ` + strings.Join(clauses, " AND ")
+	}
+	query += ` ORDER BY user_id`
+	if page.Limit > 0 {
+		// one extra row tells us whether there is a further page
+		query += ` LIMIT ?`
+		args = append(args, page.Limit+1)
+	}
+	users, err := db.query(ctx, query, args...)
+	if err != nil {
+		return nil, "", err
+	}
+	if page.Limit > 0 && len(users) > page.Limit {
+		users = users[:page.Limit]
+		return users, encodeCursor(users[len(users)-1].Id), nil
+	}
+	return users, "", nil
+}
+
+// toClause translates a filter condition and its operator to a WHERE clause and its arguments.
+// Prefix and substring matches avoid LIKE, as it is case insensitive in SQLite but not Postgres
+func (db *SQLClient) toClause(condition *model.FilterCondition) (string, []interface{}, error) {
+	column, ok := columns[condition.Query]
+	if !ok {
+		return "", nil, fmt.Errorf("unknown filter attribute: %s", condition.Query)
+	}
+	switch condition.Operator {
+	case model.OpEqual, "":
+		return column + " = ?", []interface{}{condition.Value}, nil
+	case model.OpNotEqual:
+		return column + " <> ?", []interface{}{condition.Value}, nil
+	case model.OpPrefix:
+		value := fmt.Sprint(condition.Value)
+		return "substr(" + column + ", 1, ?) = ?", []interface{}{len([]rune(value)), value}, nil
+	case model.OpContains:
+		position := "instr"
+		if db.driver == PostgresDriver {
+			position = "strpos"
+		}
+		return position + "(" + column + ", ?) > 0", []interface{}{fmt.Sprint(condition.Value)}, nil
+	case model.OpIn:
+		values, ok := condition.Value.([]string)
+		if !ok || len(values) == 0 {
+			return "", nil, fmt.Errorf("in filter on %s requires a list of values", condition.Query)
+		}
+		placeholders := make([]string, len(values))
+		args := make([]interface{}, len(values))
+		for i, value := range values {
+			placeholders[i] = "?"
+			args[i] = value
+		}
+		return column + " IN (" + strings.Join(placeholders, ", ") + ")", args, nil
+	default:
+		return "", nil, fmt.Errorf("unknown filter operator: %s", condition.Operator)
+	}
+}
+
+// GetAll returns every stored user, paging on the user ID
+func (db *SQLClient) GetAll(ctx context.Context, page *model.Page) ([]*model.User, string, error) {
+	return db.Filter(ctx, nil, page)
+}
+
+// query runs a select for users, returning nil if there are no rows to match the DynamoClient
+func (db *SQLClient) query(ctx context.Context, query string, args ...interface{}) ([]*model.User, error) {
+	rows, err := db.db.QueryContext(ctx, db.rebind(query), args...)
+	if err != nil {
+		return nil, wrapSQLError(err)
+	}
+	defer rows.Close()
+	var users []*model.User
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, wrapSQLError(rows.Err())
+}
+
+// rebind converts the ? placeholders used in this file to the style expected by the driver
+func (db *SQLClient) rebind(query string) string {
+	if db.driver != PostgresDriver {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// scanner is satisfied by both sql.Row and sql.Rows
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanUser reads a row selected with userColumns into a user
+func scanUser(row scanner) (*model.User, error) {
+	user := &model.User{}
+	err := row.Scan(&user.Id, &user.Forename, &user.Surname, &user.Nickname, &user.Password, &user.Email, &user.Country, &user.Version)
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
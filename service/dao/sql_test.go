@@ -0,0 +1,135 @@
+package dao
+
+import (
+	"context"
+	"faceit/model"
+	"fmt"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestSQLClient creates a client against a fresh in-memory SQLite database
+func newTestSQLClient(t *testing.T) *SQLClient {
+	dsn := fmt.Sprintf("file:%s?mode=memory&cache=shared", t.Name())
+	db, err := NewSQLClient(context.Background(), SQLiteDriver, dsn)
+	assert.Nil(t, err)
+	return db
+}
+
+func TestSQLClient(t *testing.T) {
+	ctx := context.Background()
+	db := newTestSQLClient(t)
+	defer db.Close()
+
+	user := &model.User{
+		Id:       "dummy-test-user",
+		Forename: "Kenny",
+		Surname:  "Schrub",
+		Nickname: "kennyS",
+		Password: "envyus",
+		Email:    "ks@notarealemail.com",
+		Country:  "FRA",
+	}
+	assert.Nil(t, db.Insert(ctx, user, nil))
+
+	stored, err := db.Get(ctx, user.Id)
+	assert.Nil(t, err)
+	assert.Equal(t, user, stored)
+
+	// inserting an existing ID replaces the row
+	user.Password = "g2esports"
+	assert.Nil(t, db.Insert(ctx, user, nil))
+	stored, err = db.Get(ctx, user.Id)
+	assert.Nil(t, err)
+	assert.Equal(t, "g2esports", stored.Password)
+
+	_, err = db.Get(ctx, "missing")
+	assert.NotNil(t, err)
+
+	assert.Nil(t, db.Delete(ctx, user.Id, user.Version, nil))
+	_, err = db.Get(ctx, user.Id)
+	assert.NotNil(t, err)
+}
+
+func TestSQLClientFilter(t *testing.T) {
+	ctx := context.Background()
+	db := newTestSQLClient(t)
+	defer db.Close()
+	users, err := LoadSeedFile("../../testdata/users.json")
+	assert.Nil(t, err)
+	for _, user := range users {
+		assert.Nil(t, db.Insert(ctx, user, nil))
+	}
+
+	tests := []struct {
+		name       string
+		conditions []*model.FilterCondition
+		expected   int
+		fail       bool
+	}{
+		{
+			name:       "all",
+			conditions: nil,
+			expected:   5,
+		}, {
+			name:       "single condition",
+			conditions: []*model.FilterCondition{{Query: "country", Value: "NZL"}},
+			expected:   2,
+		}, {
+			name: "multiple conditions",
+			conditions: []*model.FilterCondition{
+				{Query: "country", Value: "NZL"},
+				{Query: "nickname", Value: "lemming52"},
+			},
+			expected: 1,
+		}, {
+			name:       "no matches",
+			conditions: []*model.FilterCondition{{Query: "country", Value: "GBR"}},
+			expected:   0,
+		}, {
+			name:       "injection is parameterised",
+			conditions: []*model.FilterCondition{{Query: "country", Value: "NZ' OR '1'='1"}},
+			expected:   0,
+		}, {
+			name:       "unknown attribute",
+			conditions: []*model.FilterCondition{{Query: "country; DROP TABLE users", Value: "NZ"}},
+			fail:       true,
+		},
+	}
+	for _, test := range tests {
+		tt := test
+		t.Run(tt.name, func(t *testing.T) {
+			results, _, err := db.Filter(ctx, tt.conditions, &model.Page{})
+			if tt.fail {
+				assert.NotNil(t, err)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, tt.expected, len(results))
+			if tt.expected == 0 {
+				assert.Nil(t, results)
+			}
+		})
+	}
+}
+
+func TestSQLClientMigrate(t *testing.T) {
+	ctx := context.Background()
+	db := newTestSQLClient(t)
+	defer db.Close()
+
+	// reapplying is a no-op once the schema is current
+	assert.Nil(t, db.migrate(ctx))
+	var version int
+	err := db.db.QueryRowContext(ctx, `SELECT MAX(version) FROM schema_migrations`).Scan(&version)
+	assert.Nil(t, err)
+	assert.Equal(t, len(migrations), version)
+}
+
+func TestRebind(t *testing.T) {
+	query := `SELECT * FROM users WHERE a = ? AND b = ?`
+	assert.Equal(t, query, (&SQLClient{driver: SQLiteDriver}).rebind(query))
+	assert.Equal(t, `SELECT * FROM users WHERE a = $1 AND b = $2`, (&SQLClient{driver: PostgresDriver}).rebind(query))
+}
@@ -0,0 +1,22 @@
+package dao
+
+import (
+	"faceit/model"
+	"strings"
+)
+
+// uniqueFields are the user attributes no two users may share, compared case insensitively
+var uniqueFields = []string{"nickname", "email"}
+
+// uniqueKeys returns the lookup key claimed by each unique field of a user, e.g. nickname#xyp9x.
+// Empty fields claim nothing
+func uniqueKeys(user *model.User) map[string]string {
+	keys := map[string]string{}
+	for _, field := range uniqueFields {
+		value, _ := attribute(user, field)
+		if value != "" {
+			keys[field] = field + "#" + strings.ToLower(value)
+		}
+	}
+	return keys
+}
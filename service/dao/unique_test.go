@@ -0,0 +1,47 @@
+package dao
+
+import (
+	"context"
+	"errors"
+	"faceit/model"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// assertUnique checks nicknames and emails cannot be shared, ignoring case, and are freed on change or delete
+func assertUnique(t *testing.T, db pager, remove func(ctx context.Context, id string, version int64, msg *model.Message) error) {
+	ctx := context.Background()
+	first := &model.User{Id: "first", Nickname: "device", Email: "nr@notarealemail.com", Country: "DNK"}
+	assert.Nil(t, db.Insert(ctx, first, nil))
+
+	// rewriting the same user keeps its own claims
+	assert.Nil(t, db.Insert(ctx, first, nil))
+
+	err := db.Insert(ctx, &model.User{Id: "second", Nickname: "DEVICE", Email: "other@notarealemail.com"}, nil)
+	assert.True(t, errors.Is(err, ErrConflict))
+	assert.Equal(t, &ConflictError{Field: "nickname"}, err)
+
+	err = db.Insert(ctx, &model.User{Id: "second", Nickname: "dupreeh", Email: "NR@notarealemail.com"}, nil)
+	assert.Equal(t, &ConflictError{Field: "email"}, err)
+
+	// changing the nickname releases the old one
+	first.Nickname = "dev1ce"
+	assert.Nil(t, db.Insert(ctx, first, nil))
+	assert.Nil(t, db.Insert(ctx, &model.User{Id: "second", Nickname: "device", Email: "other@notarealemail.com"}, nil))
+
+	// deleting releases both
+	assert.Nil(t, remove(ctx, "first", first.Version, nil))
+	assert.Nil(t, db.Insert(ctx, &model.User{Id: "third", Nickname: "dev1ce", Email: "nr@notarealemail.com"}, nil))
+}
+
+func TestMemoryClientUnique(t *testing.T) {
+	db := NewMemoryClient()
+	assertUnique(t, db, db.Delete)
+}
+
+func TestSQLClientUnique(t *testing.T) {
+	db := newTestSQLClient(t)
+	defer db.Close()
+	assertUnique(t, db, db.Delete)
+}
@@ -0,0 +1,37 @@
+package dao
+
+import (
+	"faceit/model"
+	"fmt"
+)
+
+// updatableFields are the attributes a partial update may write, the ID and version are managed by the store
+var updatableFields = map[string]bool{
+	"forename": true,
+	"surname":  true,
+	"nickname": true,
+	"password": true,
+	"email":    true,
+	"country":  true,
+}
+
+// checkUpdate verifies every field named in a partial update may be written
+func checkUpdate(fields []string) error {
+	if len(fields) == 0 {
+		return fmt.Errorf("update requires at least one field")
+	}
+	for _, field := range fields {
+		if !updatableFields[field] {
+			return fmt.Errorf("field cannot be updated: %s", field)
+		}
+	}
+	return nil
+}
+
+// applyUpdate copies the named fields from one user to another
+func applyUpdate(dst, src *model.User, fields []string) {
+	for _, field := range fields {
+		value, _ := attribute(src, field)
+		setAttribute(dst, field, value)
+	}
+}
@@ -0,0 +1,59 @@
+package dao
+
+import (
+	"context"
+	"faceit/model"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type updater interface {
+	versioned
+	Update(ctx context.Context, user *model.User, fields []string, msg *model.Message) error
+}
+
+// assertUpdate checks a partial update only writes the named fields, and follows the version and
+// uniqueness rules of a full write
+func assertUpdate(t *testing.T, db updater) {
+	ctx := context.Background()
+	user := &model.User{Id: "updated", Forename: "Nikola", Nickname: "NiKo", Email: "nk@notarealemail.com", Country: "BIH"}
+	assert.Nil(t, db.Insert(ctx, user, nil))
+	assert.Nil(t, db.Insert(ctx, &model.User{Id: "other", Nickname: "huNter-", Email: "nm@notarealemail.com"}, nil))
+
+	// only the named fields are written
+	patch := &model.User{Id: user.Id, Forename: "ignored", Country: "HRV", Version: user.Version}
+	assert.Nil(t, db.Update(ctx, patch, []string{"country"}, nil))
+	assert.Equal(t, int64(2), patch.Version)
+	stored, err := db.Get(ctx, user.Id)
+	assert.Nil(t, err)
+	assert.Equal(t, "Nikola", stored.Forename)
+	assert.Equal(t, "HRV", stored.Country)
+	assert.Equal(t, "NiKo", stored.Nickname)
+	assert.Equal(t, int64(2), stored.Version)
+
+	// the earlier read is stale
+	assert.Equal(t, ErrPreconditionFailed, db.Update(ctx, user, []string{"country"}, nil))
+
+	stored.Nickname = "HUNTER-"
+	assert.Equal(t, &ConflictError{Field: "nickname"}, db.Update(ctx, stored, []string{"nickname"}, nil))
+
+	// changing the nickname releases the old one
+	stored.Nickname = "NiKo2"
+	assert.Nil(t, db.Update(ctx, stored, []string{"nickname"}, nil))
+	assert.Nil(t, db.Insert(ctx, &model.User{Id: "third", Nickname: "niko"}, nil))
+
+	assert.True(t, isMissing(db.Update(ctx, &model.User{Id: "missing"}, []string{"country"}, nil)))
+	assert.NotNil(t, db.Update(ctx, stored, []string{"userId"}, nil))
+	assert.NotNil(t, db.Update(ctx, stored, nil, nil))
+}
+
+func TestMemoryClientUpdate(t *testing.T) {
+	assertUpdate(t, NewMemoryClient())
+}
+
+func TestSQLClientUpdate(t *testing.T) {
+	db := newTestSQLClient(t)
+	defer db.Close()
+	assertUpdate(t, db)
+}
@@ -0,0 +1,63 @@
+package dao
+
+import (
+	"context"
+	"faceit/model"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type versioned interface {
+	Get(ctx context.Context, id string) (*model.User, error)
+	Insert(ctx context.Context, user *model.User, msg *model.Message) error
+	Delete(ctx context.Context, id string, version int64, msg *model.Message) error
+}
+
+// assertVersioning checks every write increments the version, and writes or deletes based on a stale
+// version are rejected without altering the stored user
+func assertVersioning(t *testing.T, db versioned) {
+	ctx := context.Background()
+	user := &model.User{Id: "versioned", Nickname: "s1mple", Country: "UKR"}
+	assert.Nil(t, db.Insert(ctx, user, nil))
+	assert.Equal(t, int64(1), user.Version)
+
+	first, err := db.Get(ctx, user.Id)
+	assert.Nil(t, err)
+	second, err := db.Get(ctx, user.Id)
+	assert.Nil(t, err)
+
+	first.Country = "RUS"
+	assert.Nil(t, db.Insert(ctx, first, nil))
+	assert.Equal(t, int64(2), first.Version)
+
+	// the second read is now stale
+	second.Country = "DEU"
+	assert.Equal(t, ErrPreconditionFailed, db.Insert(ctx, second, nil))
+	assert.Equal(t, int64(1), second.Version)
+	assert.Equal(t, ErrPreconditionFailed, db.Insert(ctx, &model.User{Id: user.Id, Nickname: "s1mple"}, nil))
+	assert.Equal(t, ErrPreconditionFailed, db.Delete(ctx, user.Id, second.Version, nil))
+
+	stored, err := db.Get(ctx, user.Id)
+	assert.Nil(t, err)
+	assert.Equal(t, "RUS", stored.Country)
+	assert.Equal(t, int64(2), stored.Version)
+
+	assert.Nil(t, db.Delete(ctx, user.Id, stored.Version, nil))
+	_, err = db.Get(ctx, user.Id)
+	assert.True(t, isMissing(err))
+
+	// deleting a missing user is not a failure, whatever the version
+	assert.Nil(t, db.Delete(ctx, user.Id, 5, nil))
+	assert.Equal(t, ErrPreconditionFailed, db.Insert(ctx, &model.User{Id: "missing", Version: 3}, nil))
+}
+
+func TestMemoryClientVersioning(t *testing.T) {
+	assertVersioning(t, NewMemoryClient())
+}
+
+func TestSQLClientVersioning(t *testing.T) {
+	db := newTestSQLClient(t)
+	defer db.Close()
+	assertVersioning(t, db)
+}
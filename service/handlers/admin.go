@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"faceit/model"
+	"faceit/service/dao"
+	"faceit/service/logging"
+	"faceit/service/publisher"
+	"faceit/service/replay"
+
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+)
+
+// DeadLetterClient is the set of operations on the messages that could not be published
+type DeadLetterClient interface {
+	DeadLetters(ctx context.Context, limit int) ([]*model.DeadLetter, error)
+	Replay(ctx context.Context, id string) error
+}
+
+// ReplayClient runs the replays of user snapshots and reports their progress
+type ReplayClient interface {
+	Start(conditions []*model.FilterCondition, filter map[string]string, perSecond float64) *model.ReplayJob
+	Job(id string) (*model.ReplayJob, bool)
+}
+
+// AdminHandler exposes the operational endpoints of the service, which are not part of the user API
+type AdminHandler struct {
+	letters DeadLetterClient
+	replays ReplayClient
+}
+
+// NewAdminHandler instantiates a new admin handler Object
+func NewAdminHandler(letters DeadLetterClient, replays ReplayClient) *AdminHandler {
+	return &AdminHandler{
+		letters: letters,
+		replays: replays,
+	}
+}
+
+// ListDeadLetters returns the oldest dead letters, up to the limit query param
+func (h *AdminHandler) ListDeadLetters(r *http.Request) (int, interface{}, error) {
+	ctx := r.Context()
+	logger := logging.FromContext(ctx)
+	page, err := preparePage(r.URL.Query())
+	if err != nil {
+		logger.WithField("error", err).Error("invalid page")
+		return http.StatusBadRequest, nil, err
+	}
+
+	logger.WithField("limit", page.Limit).Info("list dead letters")
+	letters, err := h.letters.DeadLetters(ctx, page.Limit)
+	if err != nil {
+		logger.WithField("error", err).Error("unable to list dead letters")
+		return http.StatusInternalServerError, nil, fmt.Errorf("unable to list dead letters")
+	}
+	response := &model.DeadLetterResponse{
+		Results: letters,
+		Count:   len(letters),
+	}
+	return http.StatusOK, response, nil
+}
+
+// ReplayDeadLetter publishes a dead letter again to the publisher that refused it, removing it once delivered
+func (h *AdminHandler) ReplayDeadLetter(r *http.Request) (int, interface{}, error) {
+	ctx := r.Context()
+	logger := logging.FromContext(ctx)
+	id := mux.Vars(r)["id"]
+
+	logger.WithField("id", id).Info("replay dead letter")
+	err := h.letters.Replay(ctx, id)
+	if err != nil {
+		logger.WithFields(log.Fields{
+			"id":    id,
+			"error": err,
+		}).Error("unable to replay dead letter")
+		switch {
+		case errors.Is(err, dao.ErrNotFound):
+			return http.StatusNotFound, nil, fmt.Errorf("unable to find dead letter: %s", id)
+		case errors.Is(err, publisher.ErrUnknownTarget):
+			return http.StatusConflict, nil, err
+		case errors.Is(err, publisher.ErrReplayFailed):
+			return http.StatusBadGateway, nil, fmt.Errorf("unable to publish dead letter: %s", id)
+		}
+		return http.StatusInternalServerError, nil, fmt.Errorf("unable to replay dead letter: %s", id)
+	}
+	return http.StatusNoContent, nil, nil
+}
+
+// StartReplay begins emitting a UserSnapshot event for every user matching the optional filter of
+// the request, and returns the job that reports its progress
+func (h *AdminHandler) StartReplay(r *http.Request) (int, interface{}, error) {
+	logger := logging.FromContext(r.Context())
+	request := &model.ReplayRequest{}
+	err := json.NewDecoder(r.Body).Decode(request)
+	if err != nil && err != io.EOF {
+		logger.WithField("error", err).Error("unable to unmarshal request")
+		return http.StatusBadRequest, nil, err
+	}
+	if request.Rate == 0 {
+		request.Rate = replay.DefaultRate
+	}
+	if request.Rate < 0 || request.Rate > replay.MaxRate {
+		return http.StatusBadRequest, nil, fmt.Errorf("ratePerSecond must be between 0 and %d: %v", replay.MaxRate, request.Rate)
+	}
+
+	logger.Info("prepare filter conditions")
+	conditions := []*model.FilterCondition{}
+	for query, value := range request.Filter {
+		condition, err := prepareFilter(query, []string{value})
+		if err != nil {
+			logger.Error(err.Error())
+			return http.StatusBadRequest, nil, err
+		}
+		conditions = append(conditions, condition)
+	}
+
+	job := h.replays.Start(conditions, request.Filter, request.Rate)
+	logger.WithFields(log.Fields{
+		"job":    job.Id,
+		"filter": request.Filter,
+		"rate":   request.Rate,
+	}).Info("started replay")
+	header := http.Header{}
+	header.Set("Location", fmt.Sprintf("%s/%s", r.URL.Path, job.Id))
+	return http.StatusAccepted, &headerPayload{payload: job, header: header}, nil
+}
+
+// GetReplay returns the progress of a replay
+func (h *AdminHandler) GetReplay(r *http.Request) (int, interface{}, error) {
+	logger := logging.FromContext(r.Context())
+	id := mux.Vars(r)["id"]
+	job, ok := h.replays.Job(id)
+	if !ok {
+		logger.WithField("id", id).Error("unknown replay")
+		return http.StatusNotFound, nil, fmt.Errorf("unable to find replay: %s", id)
+	}
+	return http.StatusOK, job, nil
+}
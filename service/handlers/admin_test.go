@@ -0,0 +1,222 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"faceit/model"
+	"faceit/service/dao"
+	"faceit/service/publisher"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+type mockDeadLetterClient struct {
+	letters  []*model.DeadLetter
+	limit    int
+	replayed string
+	failErr  error
+}
+
+func (m *mockDeadLetterClient) DeadLetters(ctx context.Context, limit int) ([]*model.DeadLetter, error) {
+	m.limit = limit
+	return m.letters, m.failErr
+}
+
+func (m *mockDeadLetterClient) Replay(ctx context.Context, id string) error {
+	m.replayed = id
+	return m.failErr
+}
+
+type mockReplayClient struct {
+	wasCalled  bool
+	conditions []*model.FilterCondition
+	rate       float64
+}
+
+func (m *mockReplayClient) Start(conditions []*model.FilterCondition, filter map[string]string, perSecond float64) *model.ReplayJob {
+	m.wasCalled = true
+	m.conditions = conditions
+	m.rate = perSecond
+	return &model.ReplayJob{Id: "job", Status: model.JobRunning, Filter: filter, Rate: perSecond}
+}
+
+func (m *mockReplayClient) Job(id string) (*model.ReplayJob, bool) {
+	if id != "job" {
+		return nil, false
+	}
+	return &model.ReplayJob{Id: "job", Status: model.JobCompleted, Emitted: 3}, true
+}
+
+func TestListDeadLetters(t *testing.T) {
+	tests := []struct {
+		name          string
+		query         string
+		failErr       error
+		expectedCode  int
+		expectedLimit int
+	}{
+		{
+			name:          "default limit",
+			query:         "",
+			expectedCode:  200,
+			expectedLimit: DefaultPageSize,
+		}, {
+			name:          "limit",
+			query:         "?limit=5",
+			expectedCode:  200,
+			expectedLimit: 5,
+		}, {
+			name:         "invalid limit",
+			query:        "?limit=none",
+			expectedCode: 400,
+		}, {
+			name:          "store failure",
+			query:         "",
+			failErr:       errors.New("unable to read"),
+			expectedCode:  500,
+			expectedLimit: DefaultPageSize,
+		},
+	}
+	for _, test := range tests {
+		tt := test
+		t.Run(tt.name, func(t *testing.T) {
+			letters := &mockDeadLetterClient{
+				letters: []*model.DeadLetter{{Id: "letter", Target: "sns"}},
+				failErr: tt.failErr,
+			}
+			handler := NewAdminHandler(letters, &mockReplayClient{})
+			req, err := http.NewRequest(http.MethodGet, "/admin/dead-letters"+tt.query, nil)
+			assert.Nil(t, err)
+
+			code, res, err := handler.ListDeadLetters(req)
+			assert.Equal(t, tt.expectedCode, code)
+			assert.Equal(t, tt.expectedLimit, letters.limit)
+			if code != 200 {
+				assert.NotNil(t, err)
+				return
+			}
+			response := res.(*model.DeadLetterResponse)
+			assert.Equal(t, 1, response.Count)
+			assert.Equal(t, "letter", response.Results[0].Id)
+		})
+	}
+}
+
+func TestReplayDeadLetter(t *testing.T) {
+	tests := []struct {
+		name         string
+		failErr      error
+		expectedCode int
+	}{
+		{
+			name:         "replayed",
+			expectedCode: 204,
+		}, {
+			name:         "missing",
+			failErr:      dao.ErrNotFound,
+			expectedCode: 404,
+		}, {
+			name:         "unknown target",
+			failErr:      fmt.Errorf("%w: kafka", publisher.ErrUnknownTarget),
+			expectedCode: 409,
+		}, {
+			name:         "still failing",
+			failErr:      fmt.Errorf("%w: timeout", publisher.ErrReplayFailed),
+			expectedCode: 502,
+		}, {
+			name:         "store failure",
+			failErr:      errors.New("unable to remove"),
+			expectedCode: 500,
+		},
+	}
+	for _, test := range tests {
+		tt := test
+		t.Run(tt.name, func(t *testing.T) {
+			letters := &mockDeadLetterClient{failErr: tt.failErr}
+			handler := NewAdminHandler(letters, &mockReplayClient{})
+			req, err := http.NewRequest(http.MethodPost, "/admin/dead-letters/letter/replay", nil)
+			assert.Nil(t, err)
+			req = mux.SetURLVars(req, map[string]string{"id": "letter"})
+
+			code, _, err := handler.ReplayDeadLetter(req)
+			assert.Equal(t, tt.expectedCode, code)
+			assert.Equal(t, tt.failErr != nil, err != nil)
+			assert.Equal(t, "letter", letters.replayed)
+		})
+	}
+}
+
+func TestStartReplay(t *testing.T) {
+	tests := []struct {
+		name               string
+		payload            string
+		expectedCode       int
+		expectedConditions int
+		expectedRate       float64
+	}{
+		{
+			name:               "all users",
+			payload:            "",
+			expectedCode:       202,
+			expectedConditions: 0,
+			expectedRate:       100,
+		}, {
+			name:               "filtered",
+			payload:            `{"filter": {"country": "DNK", "nickname[prefix]": "dev"}, "ratePerSecond": 20}`,
+			expectedCode:       202,
+			expectedConditions: 2,
+			expectedRate:       20,
+		}, {
+			name:         "invalid filter",
+			payload:      `{"filter": {"password": "secret"}}`,
+			expectedCode: 400,
+		}, {
+			name:         "invalid rate",
+			payload:      `{"ratePerSecond": -1}`,
+			expectedCode: 400,
+		}, {
+			name:         "malformed",
+			payload:      `{"filter": `,
+			expectedCode: 400,
+		},
+	}
+	for _, test := range tests {
+		tt := test
+		t.Run(tt.name, func(t *testing.T) {
+			replays := &mockReplayClient{}
+			handler := NewAdminHandler(&mockDeadLetterClient{}, replays)
+			req, err := http.NewRequest(http.MethodPost, "/admin/events/replay", strings.NewReader(tt.payload))
+			assert.Nil(t, err)
+
+			code, res, err := handler.StartReplay(req)
+			assert.Equal(t, tt.expectedCode, code)
+			assert.Equal(t, tt.expectedCode == 202, replays.wasCalled)
+			if code != 202 {
+				assert.NotNil(t, err)
+				return
+			}
+			assert.Equal(t, tt.expectedConditions, len(replays.conditions))
+			assert.Equal(t, tt.expectedRate, replays.rate)
+			assert.Equal(t, "/admin/events/replay/job", res.(*headerPayload).header.Get("Location"))
+			assert.Equal(t, "job", unwrap(res).(*model.ReplayJob).Id)
+		})
+	}
+}
+
+func TestGetReplay(t *testing.T) {
+	handler := NewAdminHandler(&mockDeadLetterClient{}, &mockReplayClient{})
+	for id, expectedCode := range map[string]int{"job": 200, "missing": 404} {
+		req, err := http.NewRequest(http.MethodGet, "/admin/events/replay/"+id, nil)
+		assert.Nil(t, err)
+		req = mux.SetURLVars(req, map[string]string{"id": id})
+
+		code, _, _ := handler.GetReplay(req)
+		assert.Equal(t, expectedCode, code)
+	}
+}
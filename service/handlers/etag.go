@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"faceit/model"
+)
+
+// etag formats a user version as a strong entity tag
+func etag(version int64) string {
+	return strconv.Quote(strconv.FormatInt(version, 10))
+}
+
+// ifMatch reports whether the If-Match header of a request permits a write to a user at the given version.
+// Requests without the header are always permitted. Weak tags never match, as If-Match requires strong comparison
+func ifMatch(r *http.Request, version int64) bool {
+	headers := r.Header["If-Match"]
+	if len(headers) == 0 {
+		return true
+	}
+	tag := etag(version)
+	for _, header := range headers {
+		for _, candidate := range strings.Split(header, ",") {
+			candidate = strings.TrimSpace(candidate)
+			if candidate == "*" || candidate == tag {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// withETag attaches the entity tag of a user to the response
+func withETag(user *model.User) interface{} {
+	header := http.Header{}
+	header.Set("ETag", etag(user.Version))
+	return &headerPayload{
+		payload: user,
+		header:  header,
+	}
+}
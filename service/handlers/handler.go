@@ -5,250 +5,540 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"faceit/model"
+	"faceit/service/dao"
+	"faceit/service/logging"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
 	log "github.com/sirupsen/logrus"
 )
 
-type daoClient interface {
+const (
+	// DefaultPageSize is the number of users returned by a search when no limit is requested
+	DefaultPageSize = 100
+	// MaxPageSize is the largest limit a search may request
+	MaxPageSize = 1000
+
+	limitParam  = "limit"
+	cursorParam = "cursor"
+)
+
+// DAOClient is the set of storage operations the handler requires of a user store
+type DAOClient interface {
 	Get(ctx context.Context, id string) (*model.User, error)
-	Insert(ctx context.Context, user *model.User) error
-	Delete(ctx context.Context, userId string) error
-	Filter(ctx context.Context, conditions []*model.FilterCondition) ([]*model.User, error)
-	GetAll(ctx context.Context) ([]*model.User, error)
+	Insert(ctx context.Context, user *model.User, msg *model.Message) error
+	Update(ctx context.Context, user *model.User, fields []string, msg *model.Message) error
+	Delete(ctx context.Context, userId string, version int64, msg *model.Message) error
+	Filter(ctx context.Context, conditions []*model.FilterCondition, page *model.Page) ([]*model.User, string, error)
+	GetAll(ctx context.Context, page *model.Page) ([]*model.User, string, error)
 }
 
-type msgClient interface {
-	Publish(ctx context.Context, msg *model.Message) error
+// Notifier is told when a message has been written to the outbox with a user change, so it can be
+// published without waiting for the next poll
+type Notifier interface {
+	Notify()
 }
 
 // Handler is a struct that exposes specific functions for the different endpoints, and stores
 // the references to clients to external services
 type Handler struct {
-	db  daoClient
-	msg msgClient
+	db     DAOClient
+	outbox Notifier
 }
 
 // NewHandler instantiates a new handler Object
-func NewHandler(db daoClient, msg msgClient) *Handler {
+func NewHandler(db DAOClient, outbox Notifier) *Handler {
 	return &Handler{
-		db:  db,
-		msg: msg,
+		db:     db,
+		outbox: outbox,
 	}
 }
 
 // GetUser is used to return a specific user, given an ID
 func (h *Handler) GetUser(r *http.Request) (int, interface{}, error) {
 	ctx := r.Context()
+	logger := logging.FromContext(ctx)
 	id := mux.Vars(r)["id"]
 
-	log.WithField("id", id).Info("retrieve user")
+	logger.WithField("id", id).Info("retrieve user")
 	user, err := h.db.Get(ctx, id)
 	if err != nil {
-		// Here an internal error looks like a missing entry, extra code can distinguish
-		log.WithField("id", id).Error(fmt.Sprintf("unable to retrieve id. err: %v", err))
-		err = fmt.Errorf("unable to find user: %s", id)
-		return http.StatusNotFound, nil, err
+		logger.WithField("id", id).Error(fmt.Sprintf("unable to retrieve id. err: %v", err))
+		code, err := daoFailure(err, id, fmt.Sprintf("unable to retrieve user: %s", id))
+		return code, nil, err
 	}
 
-	log.WithField("user", user).Info("retrieved user")
-	return http.StatusOK, user, nil
+	logger.WithField("user", user).Info("retrieved user")
+	return http.StatusOK, withETag(user), nil
 }
 
 // AddUser converts an add request to a user object and stores it in the DAO
 func (h *Handler) AddUser(r *http.Request) (int, interface{}, error) {
 	ctx := r.Context()
-	user := &model.User{
-		Id: uuid.New().String(),
-	}
+	logger := logging.FromContext(ctx)
 
-	log.Info("unmarshal request")
-	err := json.NewDecoder(r.Body).Decode(user)
+	logger.Info("unmarshal request")
+	request := &model.AddRequest{}
+	err := json.NewDecoder(r.Body).Decode(request)
 	if err != nil {
-		log.Error("unable to unmarshal request")
+		logger.Error("unable to unmarshal request")
 		return http.StatusBadRequest, nil, err
 	}
 
-	log.WithField("user", user).Info("insert user")
-	err = h.db.Insert(ctx, user)
+	logger.Info("validate request")
+	err = validateUser(request)
 	if err != nil {
-		log.WithFields(log.Fields{
-			"user":  user,
-			"error": err,
-		}).Error("unable to store user")
+		logger.WithField("error", err).Error("invalid request")
+		return http.StatusUnprocessableEntity, nil, err
+	}
+
+	logger.Info("hash password")
+	hash, err := hashPassword(request.Password)
+	if err != nil {
+		logger.WithField("error", err).Error("unable to hash password")
 		return http.StatusInternalServerError, nil, errors.New("unable to store user")
 	}
+	user := &model.User{
+		Id:       uuid.New().String(),
+		Forename: request.Forename,
+		Surname:  request.Surname,
+		Nickname: request.Nickname,
+		Password: hash,
+		Email:    request.Email,
+		Country:  request.Country,
+	}
 
-	log.WithField("user", user).Info("publish message")
-	err = h.msg.Publish(ctx, model.NewMessage(user.Id, model.UserAdd))
+	logger.WithField("user", user).Info("insert user")
+	err = h.db.Insert(ctx, user, model.NewChangeMessage(model.UserAdd, nil, user, false))
 	if err != nil {
-		// User was still created, for the sake of the exercise not going to implement any kind of rollback
-		log.WithFields(log.Fields{
+		logger.WithFields(log.Fields{
 			"user":  user,
 			"error": err,
-		}).Error("unable to publish message, user created")
-		return http.StatusCreated, user, nil
+		}).Error("unable to store user")
+		code, err := daoFailure(err, user.Id, "unable to store user")
+		return code, nil, err
 	}
-	return http.StatusCreated, user, nil
+	h.outbox.Notify()
+	return http.StatusCreated, withETag(user), nil
 }
 
 // RemoveUser deletes the given user from the id from the DAO
 func (h *Handler) RemoveUser(r *http.Request) (int, interface{}, error) {
 	ctx := r.Context()
+	logger := logging.FromContext(ctx)
 	id := mux.Vars(r)["id"]
 
-	log.WithField("id", id).Info("check for user")
+	logger.WithField("id", id).Info("check for user")
 	user, err := h.db.Get(ctx, id)
 	if err != nil {
-		log.WithField("id", id).Error(fmt.Sprintf("unable to retrieve id. err: %v", err))
-		err = fmt.Errorf("unable to find user: %s", id)
-		return http.StatusNotFound, nil, err
+		logger.WithField("id", id).Error(fmt.Sprintf("unable to retrieve id. err: %v", err))
+		code, err := daoFailure(err, id, fmt.Sprintf("unable to remove user: %s", id))
+		return code, nil, err
 	}
-
-	log.WithField("id", id).Info("delete user")
-	err = h.db.Delete(ctx, id)
-	if err != nil {
-		log.WithFields(log.Fields{
-			"user":  user,
-			"error": err,
-		}).Error("unable to delete user")
-		return http.StatusInternalServerError, nil, fmt.Errorf("unable to remove user: %s", id)
+	if !ifMatch(r, user.Version) {
+		logger.WithField("id", id).Error("user has been modified")
+		return http.StatusPreconditionFailed, nil, fmt.Errorf("user has been modified: %s", id)
 	}
 
-	log.WithField("id", id).Info("publish message")
-	err = h.msg.Publish(ctx, model.NewMessage(user.Id, model.UserDelete))
+	logger.WithField("id", id).Info("delete user")
+	err = h.db.Delete(ctx, id, user.Version, model.NewChangeMessage(model.UserDelete, user, nil, false))
 	if err != nil {
-		log.WithFields(log.Fields{
+		logger.WithFields(log.Fields{
 			"user":  user,
 			"error": err,
-		}).Error("unable to publish message, user deleted")
-		return http.StatusNoContent, nil, nil
+		}).Error("unable to delete user")
+		code, err := daoFailure(err, id, fmt.Sprintf("unable to remove user: %s", id))
+		return code, nil, err
 	}
+	h.outbox.Notify()
 	return http.StatusNoContent, nil, nil
 }
 
 // UpdateUser takes a new user definition request and overwrites the existing definition in the DAO
 func (h *Handler) UpdateUser(r *http.Request) (int, interface{}, error) {
 	ctx := r.Context()
+	logger := logging.FromContext(ctx)
 	id := mux.Vars(r)["id"]
 
-	log.WithField("id", id).Info("check for user")
+	logger.WithField("id", id).Info("check for user")
 	user, err := h.db.Get(ctx, id)
 	if err != nil {
-		log.WithField("id", id).Error(fmt.Sprintf("unable to retrieve id. err: %v", err))
-		return http.StatusNotFound, nil, fmt.Errorf("unable to find user: %s", id)
+		logger.WithField("id", id).Error(fmt.Sprintf("unable to retrieve id. err: %v", err))
+		code, err := daoFailure(err, id, fmt.Sprintf("unable to update user: %s", id))
+		return code, nil, err
+	}
+	if !ifMatch(r, user.Version) {
+		logger.WithField("id", id).Error("user has been modified")
+		return http.StatusPreconditionFailed, nil, fmt.Errorf("user has been modified: %s", id)
 	}
 
-	log.Info("unmarshal request")
-	update := &model.User{}
-	err = json.NewDecoder(r.Body).Decode(update)
+	logger.Info("unmarshal request")
+	request := &model.UpdateRequest{}
+	err = json.NewDecoder(r.Body).Decode(request)
 	if err != nil {
-		log.Error("unable to unmarshal request")
+		logger.Error("unable to unmarshal request")
 		return http.StatusBadRequest, nil, err
 	}
 
-	log.WithField("user", user).Info("insert updated user")
-	update.Id = user.Id
-	err = h.db.Insert(ctx, update)
+	logger.Info("validate request")
+	// update requests share the fields and rules of add requests
+	err = validateUpdate((*model.AddRequest)(request), user)
+	if err != nil {
+		logger.WithField("error", err).Error("invalid request")
+		return http.StatusUnprocessableEntity, nil, err
+	}
+
+	update := &model.User{
+		Id:       user.Id,
+		Forename: request.Forename,
+		Surname:  request.Surname,
+		Nickname: request.Nickname,
+		Password: user.Password,
+		Email:    request.Email,
+		Country:  request.Country,
+		Version:  user.Version,
+	}
+	// a fresh hash of the same password never matches the stored one, so compare the plaintext
+	same, legacy := checkPassword(user.Password, request.Password)
+	if !same || legacy {
+		logger.Info("hash password")
+		update.Password, err = hashPassword(request.Password)
+		if err != nil {
+			logger.WithField("error", err).Error("unable to hash password")
+			return http.StatusInternalServerError, nil, fmt.Errorf("unable to update user: %s", id)
+		}
+	}
+
+	logger.WithField("user", user).Info("insert updated user")
+	err = h.db.Insert(ctx, update, model.NewChangeMessage(model.UserUpdate, user, update, !same))
 	if err != nil {
-		log.WithFields(log.Fields{
+		logger.WithFields(log.Fields{
 			"user":  user,
 			"error": err,
 		}).Error("unable to store user")
-		return http.StatusInternalServerError, nil, fmt.Errorf("unable to update user: %s", id)
+		code, err := daoFailure(err, id, fmt.Sprintf("unable to update user: %s", id))
+		return code, nil, err
+	}
+	h.outbox.Notify()
+	return http.StatusOK, withETag(update), nil
+}
+
+// PatchUser applies a JSON Merge Patch or JSON Patch, chosen by the content type, to a user. Only the
+// fields the patch changes are validated and written, and the published message lists them
+func (h *Handler) PatchUser(r *http.Request) (int, interface{}, error) {
+	ctx := r.Context()
+	logger := logging.FromContext(ctx)
+	id := mux.Vars(r)["id"]
+
+	logger.WithField("id", id).Info("check for user")
+	user, err := h.db.Get(ctx, id)
+	if err != nil {
+		logger.WithField("id", id).Error(fmt.Sprintf("unable to retrieve id. err: %v", err))
+		code, err := daoFailure(err, id, fmt.Sprintf("unable to update user: %s", id))
+		return code, nil, err
+	}
+	if !ifMatch(r, user.Version) {
+		logger.WithField("id", id).Error("user has been modified")
+		return http.StatusPreconditionFailed, nil, fmt.Errorf("user has been modified: %s", id)
+	}
+
+	logger.Info("read patch")
+	patch, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		logger.Error("unable to read patch")
+		return http.StatusBadRequest, nil, err
+	}
+
+	logger.Info("apply patch")
+	request, err := applyPatch(user, r.Header.Get("Content-Type"), patch)
+	if err != nil {
+		logger.WithField("error", err).Error("unable to apply patch")
+		switch {
+		case errors.Is(err, errUnsupportedPatch):
+			return http.StatusUnsupportedMediaType, nil, err
+		case errors.Is(err, errMalformedPatch):
+			return http.StatusBadRequest, nil, err
+		case errors.Is(err, errPatchConflict):
+			return http.StatusConflict, nil, err
+		default:
+			return http.StatusUnprocessableEntity, nil, err
+		}
+	}
+	fields := changedFields(user, request)
+	// a patch may resend the current password, which is not a change even though its hash would differ
+	passwordChanged := request.Password != ""
+	if passwordChanged {
+		same, legacy := checkPassword(user.Password, request.Password)
+		passwordChanged = !same
+		if same && !legacy {
+			fields = removeField(fields, "password")
+			request.Password = ""
+		}
+	}
+	if len(fields) == 0 {
+		logger.WithField("id", id).Info("patch changes nothing")
+		return http.StatusOK, withETag(user), nil
 	}
 
-	log.WithField("id", id).Info("publish message")
-	err = h.msg.Publish(ctx, model.NewMessage(user.Id, model.UserDelete))
+	logger.WithField("fields", fields).Info("validate changed fields")
+	err = validateFields(request, fields)
 	if err != nil {
-		log.WithFields(log.Fields{
+		logger.WithField("error", err).Error("invalid request")
+		return http.StatusUnprocessableEntity, nil, err
+	}
+	update := &model.User{
+		Id:       user.Id,
+		Forename: request.Forename,
+		Surname:  request.Surname,
+		Nickname: request.Nickname,
+		Password: user.Password,
+		Email:    request.Email,
+		Country:  request.Country,
+		Version:  user.Version,
+	}
+	if request.Password != "" {
+		logger.Info("hash password")
+		update.Password, err = hashPassword(request.Password)
+		if err != nil {
+			logger.WithField("error", err).Error("unable to hash password")
+			return http.StatusInternalServerError, nil, fmt.Errorf("unable to update user: %s", id)
+		}
+	}
+
+	logger.WithFields(log.Fields{
+		"id":     id,
+		"fields": fields,
+	}).Info("update user")
+	err = h.db.Update(ctx, update, fields, model.NewChangeMessage(model.UserUpdate, user, update, passwordChanged))
+	if err != nil {
+		logger.WithFields(log.Fields{
 			"user":  user,
 			"error": err,
-		}).Error("unable to publish message, user deleted")
-		return http.StatusOK, user, nil
+		}).Error("unable to update user")
+		code, err := daoFailure(err, id, fmt.Sprintf("unable to update user: %s", id))
+		return code, nil, err
+	}
+	h.outbox.Notify()
+	return http.StatusOK, withETag(update), nil
+}
+
+// VerifyPassword checks a supplied password against the stored hash for a user. Users stored before
+// hashing was introduced hold a plaintext password, which is replaced by a hash on the first successful check.
+// The migration writes only the password, but as any write it moves the version on, changing the user's ETag
+func (h *Handler) VerifyPassword(r *http.Request) (int, interface{}, error) {
+	ctx := r.Context()
+	logger := logging.FromContext(ctx)
+	id := mux.Vars(r)["id"]
+
+	logger.WithField("id", id).Info("check for user")
+	user, err := h.db.Get(ctx, id)
+	if err != nil {
+		logger.WithField("id", id).Error(fmt.Sprintf("unable to retrieve id. err: %v", err))
+		code, err := daoFailure(err, id, fmt.Sprintf("unable to verify password: %s", id))
+		return code, nil, err
+	}
+
+	logger.Info("unmarshal request")
+	request := &model.VerifyPasswordRequest{}
+	err = json.NewDecoder(r.Body).Decode(request)
+	if err != nil {
+		logger.Error("unable to unmarshal request")
+		return http.StatusBadRequest, nil, err
+	}
+	if request.Password == "" {
+		logger.Error("no password supplied")
+		return http.StatusBadRequest, nil, errors.New("password is required")
+	}
+
+	logger.WithField("id", id).Info("verify password")
+	ok, legacy := checkPassword(user.Password, request.Password)
+	if !ok {
+		logger.WithField("id", id).Info("password mismatch")
+		return http.StatusUnauthorized, nil, errors.New("invalid credentials")
+	}
+
+	if legacy {
+		logger.WithField("id", id).Info("migrate plaintext password")
+		user.Password, err = hashPassword(request.Password)
+		if err == nil {
+			// the stored password is an internal detail, so no message is written
+			err = h.db.Update(ctx, user, []string{"password"}, nil)
+		}
+		if err != nil {
+			// The password was still correct, the migration is retried on the next check
+			logger.WithFields(log.Fields{
+				"id":    id,
+				"error": err,
+			}).Error("unable to migrate plaintext password")
+		}
 	}
-	return http.StatusOK, update, nil
+	return http.StatusOK, &model.VerifyPasswordResponse{
+		Id:       id,
+		Verified: true,
+	}, nil
 }
 
 // FilterUsers takes query parameters and applies them as filter conditions to all Users in the DAO
 func (h *Handler) FilterUsers(r *http.Request) (int, interface{}, error) {
 	ctx := r.Context()
+	logger := logging.FromContext(ctx)
+	params := r.URL.Query()
+
+	logger.Info("determine page params")
+	page, err := preparePage(params)
+	if err != nil {
+		logger.Error(err.Error())
+		return http.StatusBadRequest, nil, err
+	}
+	params.Del(limitParam)
+	params.Del(cursorParam)
 
-	log.Info("determine filter params")
+	logger.Info("determine filter params")
 	conditions := []*model.FilterCondition{}
-	if len(r.URL.Query()) == 0 {
-		log.Info("no query params, return all")
-		return h.GetAllUsers(ctx)
-	}
-
-	log.Info("prepare filter conditions")
-	for query, value := range r.URL.Query() {
-		condition, ok := prepareFilter(query, value)
-		if !ok {
-			msg := fmt.Sprintf("malformed filter query %s: %s", query, value)
-			log.Error(msg)
-			return http.StatusBadRequest, nil, errors.New(msg)
+	if len(params) == 0 {
+		logger.Info("no query params, return all")
+		return h.GetAllUsers(ctx, page)
+	}
+
+	logger.Info("prepare filter conditions")
+	for query, value := range params {
+		condition, err := prepareFilter(query, value)
+		if err != nil {
+			// the value can be an email or a mistyped password, so only the query is logged
+			logger.WithFields(log.Fields{
+				"query": query,
+				"error": err,
+			}).Error("invalid filter query")
+			return http.StatusBadRequest, nil, err
 		}
 		conditions = append(conditions, condition)
 	}
 
-	log.Info("filter users")
-	results, err := h.db.Filter(ctx, conditions)
+	logger.Info("filter users")
+	results, next, err := h.db.Filter(ctx, conditions, page)
+	if errors.Is(err, dao.ErrInvalidCursor) {
+		logger.WithField("cursor", page.Cursor).Error("invalid cursor")
+		return http.StatusBadRequest, nil, fmt.Errorf("invalid cursor: %s", page.Cursor)
+	}
 	if err != nil {
-		log.WithFields(log.Fields{
+		logger.WithFields(log.Fields{
 			"results": results,
 			"error":   err,
 		}).Error("unable to filter users")
-		return http.StatusInternalServerError, nil, errors.New("unable to search for users")
+		code, err := daoFailure(err, "", "unable to search for users")
+		return code, nil, err
 	}
 	if results == nil {
-		log.Info("no results found for filters")
+		logger.Info("no results found for filters")
 		return http.StatusOK, "no results found", nil
 	}
 
-	log.WithField("results", results).Info("filtered users")
+	logger.WithField("results", results).Info("filtered users")
 	response := &model.FilterResponse{
-		Results: results,
-		Count:   len(results),
+		Results:    results,
+		Count:      len(results),
+		NextCursor: next,
 	}
 	return http.StatusOK, response, nil
 }
 
-// perpareFilter is a slight convenience function, and also allows for extra conditions / handling of alternative types
-func prepareFilter(query string, value []string) (*model.FilterCondition, bool) {
+// preparePage reads the pagination query params, applying the default page size if no limit is given
+func preparePage(query map[string][]string) (*model.Page, error) {
+	page := &model.Page{
+		Limit: DefaultPageSize,
+	}
+	if values, ok := query[limitParam]; ok && len(values) > 0 {
+		limit, err := strconv.Atoi(values[0])
+		if err != nil || limit < 1 || limit > MaxPageSize {
+			return nil, fmt.Errorf("limit must be an integer between 1 and %d: %s", MaxPageSize, values[0])
+		}
+		page.Limit = limit
+	}
+	if values, ok := query[cursorParam]; ok && len(values) > 0 {
+		page.Cursor = values[0]
+	}
+	return page, nil
+}
+
+// perpareFilter is a slight convenience function, and also allows for extra conditions / handling of alternative types.
+// Queries take the form field=value for exact matches, or field[operator]=value. Errors name the query but never
+// its value, which may be sensitive
+func prepareFilter(query string, value []string) (*model.FilterCondition, error) {
 	if len(value) == 0 {
-		return nil, false
-	}
-	switch query {
-	case "country", "nickname", "surname", "forename", "email", "password": // Do we really want to filter on password?
-		return &model.FilterCondition{
-			Query: query,
-			Value: value[0], // assuming one value per query param
-		}, true
+		return nil, fmt.Errorf("malformed filter query %s", query)
+	}
+	field, operator := query, model.OpEqual
+	if open := strings.Index(query, "["); open >= 0 && strings.HasSuffix(query, "]") {
+		field, operator = query[:open], query[open+1:len(query)-1]
+	}
+	switch field {
+	case "country", "nickname", "surname", "forename", "email": // passwords are hashed, so cannot be filtered
+	default:
+		return nil, fmt.Errorf("malformed filter query %s", query)
+	}
+	condition := &model.FilterCondition{
+		Query:    field,
+		Operator: operator,
+		Value:    value[0], // assuming one value per query param
+	}
+	switch operator {
+	case model.OpEqual, model.OpNotEqual:
+		return condition, nil
+	case model.OpPrefix, model.OpContains:
+		// an empty value would match every user, and DynamoDB rejects it outright
+		if value[0] == "" {
+			return nil, fmt.Errorf("filter query %s requires a value", query)
+		}
+		return condition, nil
+	case model.OpIn:
+		var values []string
+		for _, v := range strings.Split(value[0], ",") {
+			if v != "" {
+				values = append(values, v)
+			}
+		}
+		if len(values) == 0 {
+			return nil, fmt.Errorf("filter query %s requires a comma separated list of values", query)
+		}
+		condition.Value = values
+		return condition, nil
 	default:
-		return nil, false
+		return nil, fmt.Errorf("unknown filter operator %q in %s, expected one of %s",
+			operator, query, strings.Join(filterOperators, ", "))
 	}
 }
 
-// GetAllUsers returns all users stored in the DAO
-func (h *Handler) GetAllUsers(ctx context.Context) (int, interface{}, error) {
-	log.Info("retrieve all users")
-	results, err := h.db.GetAll(ctx)
+// filterOperators lists the supported operators for error messages
+var filterOperators = []string{model.OpEqual, model.OpNotEqual, model.OpPrefix, model.OpContains, model.OpIn}
+
+// GetAllUsers returns a page of all users stored in the DAO
+func (h *Handler) GetAllUsers(ctx context.Context, page *model.Page) (int, interface{}, error) {
+	logger := logging.FromContext(ctx)
+	logger.Info("retrieve all users")
+	results, next, err := h.db.GetAll(ctx, page)
+	if errors.Is(err, dao.ErrInvalidCursor) {
+		logger.WithField("cursor", page.Cursor).Error("invalid cursor")
+		return http.StatusBadRequest, nil, fmt.Errorf("invalid cursor: %s", page.Cursor)
+	}
 	if err != nil {
-		log.WithFields(log.Fields{
+		logger.WithFields(log.Fields{
 			"results": results,
 			"error":   err,
 		}).Error("unable to retrieve users")
-		return http.StatusInternalServerError, nil, errors.New("unable to retrieve users")
+		code, err := daoFailure(err, "", "unable to retrieve users")
+		return code, nil, err
 	}
 	response := &model.FilterResponse{
-		Results: results,
-		Count:   len(results),
+		Results:    results,
+		Count:      len(results),
+		NextCursor: next,
 	}
-	log.WithField("results", results).Info("filtered users")
+	logger.WithField("results", results).Info("filtered users")
 	return http.StatusOK, response, nil
 }
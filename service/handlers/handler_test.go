@@ -1,16 +1,25 @@
 package handlers
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"faceit/model"
+	"faceit/service/dao"
+	"faceit/service/logging"
 	"fmt"
+	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
 	"reflect"
 	"strings"
 	"testing"
 
+	"github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
 )
 
 // Mock Clients
@@ -21,6 +30,11 @@ type mockDaoClient struct {
 	failFunc   string
 	payload    *model.User
 	results    []*model.User
+	page       *model.Page
+	next       string
+	fields     []string
+	msg        *model.Message
+	failErr    error
 }
 
 func NewMockDaoClient(payload *model.User, results []*model.User, failFunc string) *mockDaoClient {
@@ -33,74 +47,106 @@ func NewMockDaoClient(payload *model.User, results []*model.User, failFunc strin
 	}
 }
 
+// fail returns the error set on the mock, or the default error for the failing function
+func (m *mockDaoClient) fail(err error) error {
+	if m.failErr != nil {
+		return m.failErr
+	}
+	return err
+}
+
 func (m *mockDaoClient) Get(ctx context.Context, id string) (*model.User, error) {
 	m.wasCalled = true
 	m.calledFunc = "Get"
 	if m.failFunc == "Get" {
-		return nil, errors.New("unable to get")
+		return nil, m.fail(dao.ErrNotFound)
 	}
 	return m.payload, nil
 }
 
-func (m *mockDaoClient) Insert(ctx context.Context, user *model.User) error {
+func (m *mockDaoClient) Insert(ctx context.Context, user *model.User, msg *model.Message) error {
 	m.wasCalled = true
 	m.calledFunc = "Insert"
 	m.payload = user
+	m.msg = msg
 	if m.failFunc == "Insert" {
-		return errors.New("unable to insert")
+		return m.fail(errors.New("unable to insert"))
+	}
+	if m.failFunc == "InsertConflict" {
+		return &dao.ConflictError{Field: "nickname"}
+	}
+	return nil
+}
+
+func (m *mockDaoClient) Update(ctx context.Context, user *model.User, fields []string, msg *model.Message) error {
+	m.wasCalled = true
+	m.calledFunc = "Update"
+	m.payload = user
+	m.fields = fields
+	m.msg = msg
+	if m.failFunc == "Update" {
+		return m.fail(errors.New("unable to update"))
 	}
 	return nil
 }
 
-func (m *mockDaoClient) Delete(ctx context.Context, userId string) error {
+func (m *mockDaoClient) Delete(ctx context.Context, userId string, version int64, msg *model.Message) error {
 	m.wasCalled = true
 	m.calledFunc = "Delete"
+	m.msg = msg
 	if m.failFunc == "Delete" {
-		return errors.New("unable to delete")
+		return m.fail(errors.New("unable to delete"))
 	}
 	return nil
 }
 
-func (m *mockDaoClient) Filter(ctx context.Context, conditions []*model.FilterCondition) ([]*model.User, error) {
+func (m *mockDaoClient) Filter(ctx context.Context, conditions []*model.FilterCondition, page *model.Page) ([]*model.User, string, error) {
 	m.wasCalled = true
 	m.calledFunc = "Filter"
+	m.page = page
 	if m.failFunc == "Filter" {
-		return nil, errors.New("unable to filter")
+		return nil, "", m.fail(errors.New("unable to filter"))
 	}
-	return m.results, nil
+	if page.Cursor == "invalid" {
+		return nil, "", dao.ErrInvalidCursor
+	}
+	return m.results, m.next, nil
 }
 
-func (m *mockDaoClient) GetAll(ctx context.Context) ([]*model.User, error) {
+func (m *mockDaoClient) GetAll(ctx context.Context, page *model.Page) ([]*model.User, string, error) {
 	m.wasCalled = true
 	m.calledFunc = "GetAll"
+	m.page = page
 	if m.failFunc == "GetAll" {
-		return nil, errors.New("unable to get all")
+		return nil, "", m.fail(errors.New("unable to get all"))
+	}
+	if page.Cursor == "invalid" {
+		return nil, "", dao.ErrInvalidCursor
 	}
-	return m.results, nil
+	return m.results, m.next, nil
 }
 
-type mockMsgClient struct {
+type mockNotifier struct {
 	wasCalled bool
-	fail      bool
 }
 
-func NewMockMsgClient(fail bool) *mockMsgClient {
-	return &mockMsgClient{
+func NewMockNotifier() *mockNotifier {
+	return &mockNotifier{
 		wasCalled: false,
-		fail:      fail,
 	}
 }
 
-func (m *mockMsgClient) Publish(ctx context.Context, msg *model.Message) error {
+func (m *mockNotifier) Notify() {
 	m.wasCalled = true
-	if m.fail {
-		return errors.New("unable to publish")
-	}
-	return nil
 }
 
 // Handler Tests
 
+func init() {
+	// the default cost makes each hash take tens of milliseconds
+	passwordCost = bcrypt.MinCost
+}
+
 func TestGet(t *testing.T) {
 	payload := &model.User{
 		Id:       "dummy-test-user",
@@ -114,8 +160,8 @@ func TestGet(t *testing.T) {
 	id := "dummy-test-user"
 	expectedCode := 200
 	db := NewMockDaoClient(payload, nil, "None")
-	msg := NewMockMsgClient(false)
-	handler := NewHandler(db, msg)
+	outbox := NewMockNotifier()
+	handler := NewHandler(db, outbox)
 	uri := fmt.Sprintf("/users/%s", id)
 	req, err := http.NewRequest(http.MethodGet, uri, nil)
 	assert.Nil(t, err)
@@ -124,27 +170,80 @@ func TestGet(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, expectedCode, code)
 	assert.Equal(t, db.wasCalled, true)
-	assert.Equal(t, msg.wasCalled, false)
-	if !reflect.DeepEqual(payload, res) {
+	assert.Equal(t, outbox.wasCalled, false)
+	if !reflect.DeepEqual(payload, unwrap(res)) {
 		t.Errorf("expected response payload %v should match %v", payload, res)
 	}
+
+	// the version is returned as the ETag
+	payload.Version = 7
+	rec := httptest.NewRecorder()
+	ToHandlerFunc(handler.GetUser)(rec, req)
+	assert.Equal(t, `"7"`, rec.Header().Get("ETag"))
+	assert.Contains(t, rec.Body.String(), `"version":7`)
+}
+
+// unwrap removes any headers attached to a response payload
+func unwrap(res interface{}) interface{} {
+	if headers, ok := res.(*headerPayload); ok {
+		return headers.payload
+	}
+	return res
 }
 
 func TestGetFail(t *testing.T) {
-	id := "dummy-test-user"
-	expectedCode := 404
-	db := NewMockDaoClient(nil, nil, "Get")
-	msg := NewMockMsgClient(false)
-	handler := NewHandler(db, msg)
-	uri := fmt.Sprintf("/users/%s", id)
-	req, err := http.NewRequest(http.MethodGet, uri, nil)
-	assert.Nil(t, err)
+	tests := []struct {
+		name         string
+		err          error
+		expectedCode int
+		retryAfter   string
+	}{
+		{
+			name:         "not found",
+			err:          dao.ErrNotFound,
+			expectedCode: 404,
+		}, {
+			name:         "throttled",
+			err:          fmt.Errorf("%w: ProvisionedThroughputExceededException", dao.ErrThrottled),
+			expectedCode: 429,
+			retryAfter:   "1",
+		}, {
+			name:         "unavailable",
+			err:          fmt.Errorf("%w: RequestError", dao.ErrUnavailable),
+			expectedCode: 503,
+			retryAfter:   "1",
+		}, {
+			name:         "internal",
+			err:          errors.New("unable to decode"),
+			expectedCode: 500,
+		},
+	}
+	for _, test := range tests {
+		tt := test
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			id := "dummy-test-user"
+			db := NewMockDaoClient(nil, nil, "Get")
+			db.failErr = tt.err
+			outbox := NewMockNotifier()
+			handler := NewHandler(db, outbox)
+			uri := fmt.Sprintf("/users/%s", id)
+			req, err := http.NewRequest(http.MethodGet, uri, nil)
+			assert.Nil(t, err)
 
-	code, _, err := handler.GetUser(req)
-	assert.NotNil(t, err)
-	assert.Equal(t, expectedCode, code)
-	assert.Equal(t, db.wasCalled, true)
-	assert.Equal(t, msg.wasCalled, false)
+			rec := httptest.NewRecorder()
+			ToHandlerFunc(handler.GetUser)(rec, req)
+			assert.Equal(t, tt.expectedCode, rec.Code)
+			assert.Equal(t, tt.retryAfter, rec.Header().Get("Retry-After"))
+			assert.Equal(t, db.wasCalled, true)
+			assert.Equal(t, outbox.wasCalled, false)
+
+			response := &ErrorResponse{}
+			assert.Nil(t, json.NewDecoder(rec.Body).Decode(response))
+			assert.Equal(t, tt.expectedCode, response.Code)
+			assert.NotContains(t, response.Description, tt.err.Error(), "internal errors should not be exposed")
+		})
+	}
 }
 
 func TestAddUser(t *testing.T) {
@@ -152,7 +251,7 @@ func TestAddUser(t *testing.T) {
 		"forename": "Nathan",
 		"surname": "Schmitt",
 		"nickname": "NBK-",
-		"password": "og",
+		"password": "og2020cs",
 		"email": "ns@notarealemail.com",
 		"country": "FRA"
 	}`
@@ -161,14 +260,14 @@ func TestAddUser(t *testing.T) {
 		Forename: "Nathan",
 		Surname:  "Schmitt",
 		Nickname: "NBK-",
-		Password: "og",
+		Password: "og2020cs",
 		Email:    "ns@notarealemail.com",
 		Country:  "FRA",
 	}
 	expectedCode := 201
 	db := NewMockDaoClient(nil, nil, "None")
-	msg := NewMockMsgClient(false)
-	handler := NewHandler(db, msg)
+	outbox := NewMockNotifier()
+	handler := NewHandler(db, outbox)
 	uri := "/users"
 	req, err := http.NewRequest(http.MethodPost, uri, strings.NewReader(payload))
 	assert.Nil(t, err)
@@ -177,21 +276,84 @@ func TestAddUser(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, expectedCode, code)
 	assert.Equal(t, db.wasCalled, true)
-	assert.Equal(t, msg.wasCalled, true)
+	assert.Equal(t, outbox.wasCalled, true)
+	assert.Equal(t, model.UserAdd, db.msg.Action)
 	compareUser(t, expectedUser, res)
+
+	// the hash must never be serialised into the response
+	body, err := json.Marshal(unwrap(res))
+	assert.Nil(t, err)
+	assert.NotContains(t, string(body), "password")
+}
+
+// TestRequestLogger checks handlers log through the entry of the request, without the email or password
+func TestRequestLogger(t *testing.T) {
+	payload := `{
+		"forename": "Nathan",
+		"surname": "Schmitt",
+		"nickname": "NBK-",
+		"password": "og2020cs",
+		"email": "ns@notarealemail.com",
+		"country": "FRA"
+	}`
+	// redaction runs before the test hook records each entry
+	logger := logrus.New()
+	logger.SetOutput(ioutil.Discard)
+	logger.AddHook(logging.RedactHook{})
+	hook := logtest.NewLocal(logger)
+	handler := NewHandler(NewMockDaoClient(nil, nil, "None"), NewMockNotifier())
+	req, err := http.NewRequest(http.MethodPost, "/users", strings.NewReader(payload))
+	assert.Nil(t, err)
+	req = req.WithContext(logging.NewContext(req.Context(), logger.WithField("requestId", "abc")))
+
+	code, _, err := handler.AddUser(req)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusCreated, code)
+	assert.NotEmpty(t, hook.AllEntries())
+	for _, entry := range hook.AllEntries() {
+		assert.Equal(t, "abc", entry.Data["requestId"], entry.Message)
+		if user, ok := entry.Data["user"]; ok {
+			assert.Equal(t, logging.Redacted, user.(map[string]interface{})["email"])
+			assert.NotContains(t, user, "password")
+		}
+	}
+}
+
+func TestFilterRequestLogger(t *testing.T) {
+	logger := logrus.New()
+	var out bytes.Buffer
+	logger.SetOutput(&out)
+	logger.AddHook(logging.RedactHook{})
+	handler := NewHandler(NewMockDaoClient(nil, nil, "None"), NewMockNotifier())
+	for _, query := range []string{"emial=ns@notarealemail.com", "password=og2020cs", "email[in]=,"} {
+		req, err := http.NewRequest(http.MethodGet, "/users?"+query, nil)
+		assert.Nil(t, err)
+		req = req.WithContext(logging.NewContext(req.Context(), logrus.NewEntry(logger)))
+
+		code, _, err := handler.FilterUsers(req)
+		assert.Equal(t, http.StatusBadRequest, code, query)
+		assert.NotContains(t, err.Error(), "notarealemail")
+		assert.NotContains(t, err.Error(), "og2020cs")
+	}
+	assert.Contains(t, out.String(), "invalid filter query")
+	assert.NotContains(t, out.String(), "notarealemail")
+	assert.NotContains(t, out.String(), "og2020cs")
 }
 
 // compareUser is a convenience func for testing user equivalence with ID generation
 // in a full scenario i'd using something like gosert https://github.com/mina-akimi/gosert
 func compareUser(t *testing.T, expected *model.User, given interface{}) {
-	res := given.(*model.User)
+	res := unwrap(given).(*model.User)
 	assert.NotEqual(t, "", res.Id)
 	assert.Equal(t, expected.Forename, res.Forename)
 	assert.Equal(t, expected.Surname, res.Surname)
 	assert.Equal(t, expected.Nickname, res.Nickname)
 	assert.Equal(t, expected.Email, res.Email)
-	assert.Equal(t, expected.Password, res.Password)
 	assert.Equal(t, expected.Country, res.Country)
+	assert.NotEqual(t, expected.Password, res.Password, "password should be hashed")
+	ok, legacy := checkPassword(res.Password, expected.Password)
+	assert.True(t, ok)
+	assert.False(t, legacy)
 }
 
 // For this test I demonstrate how the mocks i've written can be configured to fail at specific points
@@ -211,13 +373,26 @@ func TestAddUserFail(t *testing.T) {
 				"forename": "Richard",
 				"surname": "Papillion",
 				"nickname": "shox",
-				"password": "vitality",
+				"password": "vitality2020",
 				"email": "rp@notarealemail.com",
 				"country": "FRA"
 			}`,
 			failFunc:     "Insert",
 			dbCalled:     true,
 			expectedCode: 500,
+		}, {
+			name: "conflict",
+			payload: `{
+				"forename": "Richard",
+				"surname": "Papillion",
+				"nickname": "shox",
+				"password": "vitality2020",
+				"email": "rp@notarealemail.com",
+				"country": "FRA"
+			}`,
+			failFunc:     "InsertConflict",
+			dbCalled:     true,
+			expectedCode: 409,
 		}, {
 			name:         "fail unmarshal",
 			payload:      `incorrect structure}`,
@@ -230,8 +405,8 @@ func TestAddUserFail(t *testing.T) {
 		tt := test
 		t.Run(tt.name, func(t *testing.T) {
 			db := NewMockDaoClient(nil, nil, tt.failFunc)
-			msg := NewMockMsgClient(false)
-			handler := NewHandler(db, msg)
+			outbox := NewMockNotifier()
+			handler := NewHandler(db, outbox)
 			uri := "/users"
 			req, err := http.NewRequest(http.MethodPost, uri, strings.NewReader(tt.payload))
 			assert.Nil(t, err)
@@ -240,7 +415,7 @@ func TestAddUserFail(t *testing.T) {
 			assert.NotNil(t, err)
 			assert.Equal(t, tt.expectedCode, code)
 			assert.Equal(t, db.wasCalled, tt.dbCalled)
-			assert.Equal(t, msg.wasCalled, false)
+			assert.Equal(t, outbox.wasCalled, false)
 			assert.Nil(t, res)
 		})
 	}
@@ -259,8 +434,8 @@ func TestDelete(t *testing.T) {
 	id := "dummy-test-user"
 	expectedCode := 204
 	db := NewMockDaoClient(payload, nil, "None")
-	msg := NewMockMsgClient(false)
-	handler := NewHandler(db, msg)
+	outbox := NewMockNotifier()
+	handler := NewHandler(db, outbox)
 	uri := fmt.Sprintf("/users/%s", id)
 	req, err := http.NewRequest(http.MethodDelete, uri, nil)
 	assert.Nil(t, err)
@@ -269,7 +444,7 @@ func TestDelete(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, expectedCode, code)
 	assert.Equal(t, db.wasCalled, true)
-	assert.Equal(t, msg.wasCalled, true)
+	assert.Equal(t, outbox.wasCalled, true)
 	assert.Nil(t, res)
 }
 
@@ -281,29 +456,29 @@ func TestUpdateUser(t *testing.T) {
 		Nickname: "Gratisfaction",
 		Password: "renegades",
 		Email:    "sk@notarealemail.com",
-		Country:  "NZ",
+		Country:  "NZL",
 	}
 	payload := `{
 		"forename": "Sean",
 		"surname": "Kaiwai",
 		"nickname": "Gratisfaction",
-		"password": "100T",
+		"password": "100Thieves",
 		"email": "sk@notarealemail.com",
-		"country": "NZ"
+		"country": "NZL"
 	}`
 	expectedUser := &model.User{
 		Id:       "dummy-test-user",
 		Forename: "Sean",
 		Surname:  "Kaiwai",
 		Nickname: "Gratisfaction",
-		Password: "100T",
+		Password: "100Thieves",
 		Email:    "sk@notarealemail.com",
-		Country:  "NZ",
+		Country:  "NZL",
 	}
 	expectedCode := 200
 	db := NewMockDaoClient(previous, nil, "None")
-	msg := NewMockMsgClient(false)
-	handler := NewHandler(db, msg)
+	outbox := NewMockNotifier()
+	handler := NewHandler(db, outbox)
 	uri := "/users/dummy-test-user"
 	req, err := http.NewRequest(http.MethodPost, uri, strings.NewReader(payload))
 	assert.Nil(t, err)
@@ -312,11 +487,474 @@ func TestUpdateUser(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, expectedCode, code)
 	assert.Equal(t, db.wasCalled, true)
-	assert.Equal(t, msg.wasCalled, true)
+	assert.Equal(t, outbox.wasCalled, true)
+	assert.Equal(t, model.UserUpdate, db.msg.Action)
 	compareUser(t, expectedUser, db.payload)
 	compareUser(t, expectedUser, res)
 }
 
+func TestUpdateUserConflict(t *testing.T) {
+	previous := &model.User{
+		Id:       "dummy-test-user",
+		Nickname: "Gratisfaction",
+	}
+	payload := `{
+		"forename": "Sean",
+		"surname": "Kaiwai",
+		"nickname": "Gratisfaction",
+		"password": "100Thieves",
+		"email": "sk@notarealemail.com",
+		"country": "NZL"
+	}`
+	db := NewMockDaoClient(previous, nil, "InsertConflict")
+	outbox := NewMockNotifier()
+	handler := NewHandler(db, outbox)
+	req, err := http.NewRequest(http.MethodPut, "/users/dummy-test-user", strings.NewReader(payload))
+	assert.Nil(t, err)
+
+	code, res, err := handler.UpdateUser(req)
+	assert.Equal(t, 409, code)
+	assert.Equal(t, "nickname is already in use", err.Error())
+	assert.Nil(t, res)
+	assert.Equal(t, outbox.wasCalled, false)
+}
+
+// the stored hash is salted, so resending the same password must not be reported as a change
+func TestUpdateUserSamePassword(t *testing.T) {
+	hash, err := hashPassword("100Thieves")
+	assert.Nil(t, err)
+	tests := []struct {
+		name           string
+		password       string
+		expectedFields []string
+	}{
+		{name: "same password", password: "100Thieves", expectedFields: []string{"country"}},
+		{name: "new password", password: "Renegades2015", expectedFields: []string{"password", "country"}},
+	}
+	for _, test := range tests {
+		tt := test
+		t.Run(tt.name, func(t *testing.T) {
+			previous := &model.User{
+				Id:       "dummy-test-user",
+				Forename: "Sean",
+				Surname:  "Kaiwai",
+				Nickname: "Gratisfaction",
+				Password: hash,
+				Email:    "sk@notarealemail.com",
+				Country:  "NZL",
+			}
+			payload := fmt.Sprintf(`{
+				"forename": "Sean",
+				"surname": "Kaiwai",
+				"nickname": "Gratisfaction",
+				"password": %q,
+				"email": "sk@notarealemail.com",
+				"country": "AUS"
+			}`, tt.password)
+			db := NewMockDaoClient(previous, nil, "None")
+			handler := NewHandler(db, NewMockNotifier())
+			req, err := http.NewRequest(http.MethodPut, "/users/dummy-test-user", strings.NewReader(payload))
+			assert.Nil(t, err)
+
+			code, _, err := handler.UpdateUser(req)
+			assert.Nil(t, err)
+			assert.Equal(t, 200, code)
+			assert.Equal(t, tt.expectedFields, db.msg.Fields)
+			ok, _ := checkPassword(db.payload.Password, tt.password)
+			assert.True(t, ok)
+		})
+	}
+
+	t.Run("patch with the same password", func(t *testing.T) {
+		previous := &model.User{Id: "dummy-test-user", Nickname: "Gratisfaction", Password: hash, Country: "NZL"}
+		db := NewMockDaoClient(previous, nil, "None")
+		handler := NewHandler(db, NewMockNotifier())
+		req, err := http.NewRequest(http.MethodPatch, "/users/dummy-test-user", strings.NewReader(`{"password": "100Thieves"}`))
+		assert.Nil(t, err)
+		req.Header.Set("Content-Type", MergePatchType)
+
+		code, _, err := handler.PatchUser(req)
+		assert.Nil(t, err)
+		assert.Equal(t, 200, code)
+		assert.Equal(t, "Get", db.calledFunc)
+	})
+}
+
+// users stored with a country code from before validation keep it, but cannot move to another invalid one
+func TestUpdateUserLegacyCountry(t *testing.T) {
+	tests := []struct {
+		name         string
+		country      string
+		expectedCode int
+	}{
+		{name: "unchanged", country: "NZ", expectedCode: 200},
+		{name: "changed to an iso code", country: "NZL", expectedCode: 200},
+		{name: "changed to another legacy code", country: "DEN", expectedCode: 422},
+	}
+	for _, test := range tests {
+		tt := test
+		t.Run(tt.name, func(t *testing.T) {
+			previous := &model.User{
+				Id:       "dummy-test-user",
+				Nickname: "Gratisfaction",
+				Country:  "NZ",
+			}
+			payload := fmt.Sprintf(`{
+				"forename": "Sean",
+				"surname": "Kaiwai",
+				"nickname": "Gratisfaction",
+				"password": "100Thieves",
+				"email": "sk@notarealemail.com",
+				"country": %q
+			}`, tt.country)
+			db := NewMockDaoClient(previous, nil, "None")
+			handler := NewHandler(db, NewMockNotifier())
+			req, err := http.NewRequest(http.MethodPut, "/users/dummy-test-user", strings.NewReader(payload))
+			assert.Nil(t, err)
+
+			code, _, err := handler.UpdateUser(req)
+			assert.Equal(t, tt.expectedCode, code, err)
+			if tt.expectedCode == 200 {
+				assert.Equal(t, tt.country, db.payload.Country)
+			}
+		})
+	}
+}
+
+func TestIfMatch(t *testing.T) {
+	payload := `{
+		"forename": "Oleksandr",
+		"surname": "Kostyliev",
+		"nickname": "s1mple",
+		"password": "navi2021",
+		"email": "ok@notarealemail.com",
+		"country": "UKR"
+	}`
+	tests := []struct {
+		name         string
+		method       string
+		ifMatch      string
+		failErr      error
+		dbCalledFunc string
+		expectedCode int
+	}{
+		{
+			name:         "update without header",
+			method:       http.MethodPut,
+			dbCalledFunc: "Insert",
+			expectedCode: 200,
+		}, {
+			name:         "update matching",
+			method:       http.MethodPut,
+			ifMatch:      `"1", "3"`,
+			dbCalledFunc: "Insert",
+			expectedCode: 200,
+		}, {
+			name:         "update any",
+			method:       http.MethodPut,
+			ifMatch:      "*",
+			dbCalledFunc: "Insert",
+			expectedCode: 200,
+		}, {
+			name:         "update stale",
+			method:       http.MethodPut,
+			ifMatch:      `"2"`,
+			dbCalledFunc: "Get",
+			expectedCode: 412,
+		}, {
+			name:         "update weak",
+			method:       http.MethodPut,
+			ifMatch:      `W/"3"`,
+			dbCalledFunc: "Get",
+			expectedCode: 412,
+		}, {
+			name:         "update raced",
+			method:       http.MethodPut,
+			failErr:      dao.ErrPreconditionFailed,
+			dbCalledFunc: "Insert",
+			expectedCode: 412,
+		}, {
+			name:         "delete matching",
+			method:       http.MethodDelete,
+			ifMatch:      `"3"`,
+			dbCalledFunc: "Delete",
+			expectedCode: 204,
+		}, {
+			name:         "delete stale",
+			method:       http.MethodDelete,
+			ifMatch:      `"2"`,
+			dbCalledFunc: "Get",
+			expectedCode: 412,
+		}, {
+			name:         "delete raced",
+			method:       http.MethodDelete,
+			failErr:      dao.ErrPreconditionFailed,
+			dbCalledFunc: "Delete",
+			expectedCode: 412,
+		},
+	}
+	for _, test := range tests {
+		tt := test
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			previous := &model.User{Id: "dummy-test-user", Nickname: "s1mple", Version: 3}
+			failFunc := "None"
+			if tt.failErr != nil {
+				failFunc = map[string]string{http.MethodPut: "Insert", http.MethodDelete: "Delete"}[tt.method]
+			}
+			db := NewMockDaoClient(previous, nil, failFunc)
+			db.failErr = tt.failErr
+			outbox := NewMockNotifier()
+			handler := NewHandler(db, outbox)
+			req, err := http.NewRequest(tt.method, "/users/dummy-test-user", strings.NewReader(payload))
+			assert.Nil(t, err)
+			if tt.ifMatch != "" {
+				req.Header.Set("If-Match", tt.ifMatch)
+			}
+
+			endpoint := handler.UpdateUser
+			if tt.method == http.MethodDelete {
+				endpoint = handler.RemoveUser
+			}
+			code, _, err := endpoint(req)
+			assert.Equal(t, tt.expectedCode, code)
+			assert.Equal(t, tt.dbCalledFunc, db.calledFunc)
+			assert.Equal(t, tt.expectedCode < 300, outbox.wasCalled)
+			if tt.expectedCode == 412 {
+				assert.Contains(t, err.Error(), "user has been modified")
+			}
+			if tt.method == http.MethodPut && tt.expectedCode == 200 {
+				// the update is written against the version that was read
+				assert.Equal(t, int64(3), db.payload.Version)
+			}
+		})
+	}
+}
+
+func TestPatchUser(t *testing.T) {
+	tests := []struct {
+		name           string
+		contentType    string
+		patch          string
+		expectedCode   int
+		expectedFields []string
+		expectedUser   *model.User
+	}{
+		{
+			name:           "merge patch",
+			contentType:    MergePatchType,
+			patch:          `{"country": "DNK", "surname": "Reedtz"}`,
+			expectedCode:   200,
+			expectedFields: []string{"surname", "country"},
+			expectedUser:   &model.User{Forename: "Nicolai", Surname: "Reedtz", Nickname: "dev1ce", Email: "nr@notarealemail.com", Country: "DNK"},
+		}, {
+			name:           "json patch",
+			contentType:    JSONPatchType + "; charset=utf-8",
+			patch:          `[{"op": "test", "path": "/nickname", "value": "dev1ce"}, {"op": "replace", "path": "/nickname", "value": "device"}]`,
+			expectedCode:   200,
+			expectedFields: []string{"nickname"},
+			expectedUser:   &model.User{Forename: "Nicolai", Surname: "Hansen", Nickname: "device", Email: "nr@notarealemail.com", Country: "SWE"},
+		}, {
+			name:           "password",
+			contentType:    JSONPatchType,
+			patch:          `[{"op": "replace", "path": "/password", "value": "astralis2018"}]`,
+			expectedCode:   200,
+			expectedFields: []string{"password"},
+			expectedUser:   &model.User{Forename: "Nicolai", Surname: "Hansen", Nickname: "dev1ce", Email: "nr@notarealemail.com", Country: "SWE"},
+		}, {
+			name:         "no change",
+			contentType:  MergePatchType,
+			patch:        `{"country": "SWE"}`,
+			expectedCode: 200,
+		}, {
+			name:         "failed test operation",
+			contentType:  JSONPatchType,
+			patch:        `[{"op": "test", "path": "/nickname", "value": "device"}]`,
+			expectedCode: 409,
+		}, {
+			name:         "removes required field",
+			contentType:  MergePatchType,
+			patch:        `{"email": null}`,
+			expectedCode: 422,
+		}, {
+			name:         "invalid value",
+			contentType:  MergePatchType,
+			patch:        `{"country": "Sweden"}`,
+			expectedCode: 422,
+		}, {
+			name:         "unknown field",
+			contentType:  JSONPatchType,
+			patch:        `[{"op": "add", "path": "/userId", "value": "someone-else"}]`,
+			expectedCode: 422,
+		}, {
+			name:         "malformed",
+			contentType:  JSONPatchType,
+			patch:        `{"op": "replace"`,
+			expectedCode: 400,
+		}, {
+			name:         "plain json",
+			contentType:  "application/json",
+			patch:        `{"country": "DNK"}`,
+			expectedCode: 415,
+		},
+	}
+	for _, test := range tests {
+		tt := test
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			previous := &model.User{
+				Id:       "dummy-test-user",
+				Forename: "Nicolai",
+				Surname:  "Hansen",
+				Nickname: "dev1ce",
+				Password: "stored-hash",
+				Email:    "nr@notarealemail.com",
+				Country:  "SWE",
+				Version:  4,
+			}
+			db := NewMockDaoClient(previous, nil, "None")
+			outbox := NewMockNotifier()
+			handler := NewHandler(db, outbox)
+			req, err := http.NewRequest(http.MethodPatch, "/users/dummy-test-user", strings.NewReader(tt.patch))
+			assert.Nil(t, err)
+			req.Header.Set("Content-Type", tt.contentType)
+
+			code, res, err := handler.PatchUser(req)
+			assert.Equal(t, tt.expectedCode, code, err)
+			if tt.expectedFields == nil {
+				assert.Equal(t, "Get", db.calledFunc)
+				assert.False(t, outbox.wasCalled)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, tt.expectedFields, db.fields)
+			assert.Equal(t, int64(4), db.payload.Version)
+			assert.Equal(t, model.UserUpdate, db.msg.Action)
+			assert.Equal(t, tt.expectedFields, db.msg.Fields)
+			assert.Equal(t, len(tt.expectedFields), len(db.msg.Diff))
+			assert.Equal(t, int64(5), db.msg.Version)
+			assert.Equal(t, "Hansen", db.msg.Before.Surname)
+			assert.Equal(t, tt.expectedUser.Surname, db.msg.After.Surname)
+			assert.Empty(t, db.msg.After.Password)
+
+			user := unwrap(res).(*model.User)
+			tt.expectedUser.Id = previous.Id
+			tt.expectedUser.Password = user.Password
+			tt.expectedUser.Version = user.Version
+			assert.Equal(t, tt.expectedUser, user)
+			if tt.expectedFields[0] == "password" {
+				ok, legacy := checkPassword(user.Password, "astralis2018")
+				assert.True(t, ok)
+				assert.False(t, legacy)
+			} else {
+				assert.Equal(t, "stored-hash", user.Password)
+			}
+		})
+	}
+}
+
+func TestVerifyPassword(t *testing.T) {
+	hash, err := hashPassword("faze-clan")
+	assert.Nil(t, err)
+	tests := []struct {
+		name         string
+		stored       string
+		payload      string
+		failFunc     string
+		expectedCode int
+		migrated     bool
+	}{
+		{
+			name:         "hashed match",
+			stored:       hash,
+			payload:      `{"password": "faze-clan"}`,
+			failFunc:     "None",
+			expectedCode: 200,
+		}, {
+			name:         "hashed mismatch",
+			stored:       hash,
+			payload:      `{"password": "navi"}`,
+			failFunc:     "None",
+			expectedCode: 401,
+		}, {
+			name:         "plaintext match migrates",
+			stored:       "faze-clan",
+			payload:      `{"password": "faze-clan"}`,
+			failFunc:     "None",
+			expectedCode: 200,
+			migrated:     true,
+		}, {
+			name:         "plaintext mismatch",
+			stored:       "faze-clan",
+			payload:      `{"password": "navi"}`,
+			failFunc:     "None",
+			expectedCode: 401,
+		}, {
+			name:         "missing user",
+			stored:       hash,
+			payload:      `{"password": "faze-clan"}`,
+			failFunc:     "Get",
+			expectedCode: 404,
+		}, {
+			name:         "malformed request",
+			stored:       hash,
+			payload:      `faze-clan`,
+			failFunc:     "None",
+			expectedCode: 400,
+		}, {
+			name:         "empty password",
+			stored:       "",
+			payload:      `{"password": ""}`,
+			failFunc:     "None",
+			expectedCode: 400,
+		}, {
+			name:         "no stored password",
+			stored:       "",
+			payload:      `{"password": "faze-clan"}`,
+			failFunc:     "None",
+			expectedCode: 401,
+		},
+	}
+	for _, test := range tests {
+		tt := test
+		t.Run(tt.name, func(t *testing.T) {
+			stored := &model.User{
+				Id:       "dummy-test-user",
+				Nickname: "rain",
+				Password: tt.stored,
+			}
+			db := NewMockDaoClient(stored, nil, tt.failFunc)
+			outbox := NewMockNotifier()
+			handler := NewHandler(db, outbox)
+			req, err := http.NewRequest(http.MethodPost, "/users/dummy-test-user/verify-password", strings.NewReader(tt.payload))
+			assert.Nil(t, err)
+
+			expectedFunc := "Get"
+			if tt.migrated {
+				expectedFunc = "Update"
+			}
+
+			code, res, err := handler.VerifyPassword(req)
+			assert.Equal(t, tt.expectedCode, code)
+			assert.Equal(t, expectedFunc, db.calledFunc)
+			assert.Equal(t, outbox.wasCalled, false)
+			if tt.expectedCode != 200 {
+				assert.NotNil(t, err)
+				assert.Nil(t, res)
+				return
+			}
+			assert.Nil(t, err)
+			assert.True(t, res.(*model.VerifyPasswordResponse).Verified)
+			if tt.migrated {
+				assert.Equal(t, []string{"password"}, db.fields)
+				ok, legacy := checkPassword(db.payload.Password, "faze-clan")
+				assert.True(t, ok)
+				assert.False(t, legacy)
+			}
+		})
+	}
+}
+
 // more a test for the sake of tests; as the filter logic is in the db implementation
 func TestFilter(t *testing.T) {
 	payload := []*model.User{
@@ -364,8 +1002,8 @@ func TestFilter(t *testing.T) {
 		tt := test
 		t.Run(tt.name, func(t *testing.T) {
 			db := NewMockDaoClient(nil, payload, "None")
-			msg := NewMockMsgClient(false)
-			handler := NewHandler(db, msg)
+			outbox := NewMockNotifier()
+			handler := NewHandler(db, outbox)
 			uri := "/users"
 			req, err := http.NewRequest(http.MethodGet, uri, nil)
 			q := req.URL.Query()
@@ -379,7 +1017,7 @@ func TestFilter(t *testing.T) {
 			assert.Equal(t, tt.expectedCode, code)
 			assert.Equal(t, db.wasCalled, true)
 			assert.Equal(t, tt.expectedFunc, db.calledFunc)
-			assert.Equal(t, msg.wasCalled, false)
+			assert.Equal(t, outbox.wasCalled, false)
 			if !reflect.DeepEqual(expectedResponse, res) {
 				t.Errorf("expected response payload %v should match %v", expectedResponse, res)
 			}
@@ -387,6 +1025,93 @@ func TestFilter(t *testing.T) {
 	}
 }
 
+func TestFilterPagination(t *testing.T) {
+	payload := []*model.User{
+		{
+			Id:       "dummy-test-user",
+			Forename: "Oleksandr",
+			Surname:  "Kostyliev",
+			Nickname: "s1mple",
+			Email:    "ok@notarealemail.com",
+			Country:  "UKR",
+		},
+	}
+	tests := []struct {
+		name          string
+		params        map[string]string
+		expectedCode  int
+		expectedFunc  string
+		expectedLimit int
+	}{
+		{
+			name:          "default limit",
+			params:        map[string]string{},
+			expectedCode:  200,
+			expectedFunc:  "GetAll",
+			expectedLimit: DefaultPageSize,
+		}, {
+			name:          "limit and cursor only",
+			params:        map[string]string{"limit": "1", "cursor": "abc"},
+			expectedCode:  200,
+			expectedFunc:  "GetAll",
+			expectedLimit: 1,
+		}, {
+			name:          "filter with limit",
+			params:        map[string]string{"country": "UKR", "limit": "10"},
+			expectedCode:  200,
+			expectedFunc:  "Filter",
+			expectedLimit: 10,
+		}, {
+			name:         "non numeric limit",
+			params:       map[string]string{"limit": "ten"},
+			expectedCode: 400,
+			expectedFunc: "None",
+		}, {
+			name:         "limit too large",
+			params:       map[string]string{"limit": "1001"},
+			expectedCode: 400,
+			expectedFunc: "None",
+		}, {
+			name:         "invalid cursor",
+			params:       map[string]string{"cursor": "invalid"},
+			expectedCode: 400,
+			expectedFunc: "GetAll",
+		}, {
+			name:         "invalid cursor with filter",
+			params:       map[string]string{"country": "UKR", "cursor": "invalid"},
+			expectedCode: 400,
+			expectedFunc: "Filter",
+		},
+	}
+	for _, test := range tests {
+		tt := test
+		t.Run(tt.name, func(t *testing.T) {
+			db := NewMockDaoClient(nil, payload, "None")
+			db.next = "next-page"
+			handler := NewHandler(db, NewMockNotifier())
+			req, err := http.NewRequest(http.MethodGet, "/users", nil)
+			assert.Nil(t, err)
+			q := req.URL.Query()
+			for field, query := range tt.params {
+				q.Add(field, query)
+			}
+			req.URL.RawQuery = q.Encode()
+
+			code, res, err := handler.FilterUsers(req)
+			assert.Equal(t, tt.expectedCode, code)
+			assert.Equal(t, tt.expectedFunc, db.calledFunc)
+			if tt.expectedCode != 200 {
+				assert.NotNil(t, err)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, tt.expectedLimit, db.page.Limit)
+			assert.Equal(t, tt.params["cursor"], db.page.Cursor)
+			assert.Equal(t, "next-page", res.(*model.FilterResponse).NextCursor)
+		})
+	}
+}
+
 func TestPrepareFilter(t *testing.T) {
 	tests := []struct {
 		name              string
@@ -400,10 +1125,61 @@ func TestPrepareFilter(t *testing.T) {
 			query: "country",
 			value: []string{"GBR"},
 			expectedCondition: &model.FilterCondition{
-				Query: "country",
-				Value: "GBR",
+				Query:    "country",
+				Operator: model.OpEqual,
+				Value:    "GBR",
 			},
 			expectedValid: true,
+		}, {
+			name:  "explicit operator",
+			query: "nickname[prefix]",
+			value: []string{"s1"},
+			expectedCondition: &model.FilterCondition{
+				Query:    "nickname",
+				Operator: model.OpPrefix,
+				Value:    "s1",
+			},
+			expectedValid: true,
+		}, {
+			name:  "list operator",
+			query: "country[in]",
+			value: []string{"FRA,DEN"},
+			expectedCondition: &model.FilterCondition{
+				Query:    "country",
+				Operator: model.OpIn,
+				Value:    []string{"FRA", "DEN"},
+			},
+			expectedValid: true,
+		}, {
+			name:              "empty list",
+			query:             "country[in]",
+			value:             []string{","},
+			expectedCondition: nil,
+			expectedValid:     false,
+		}, {
+			name:              "empty prefix",
+			query:             "nickname[prefix]",
+			value:             []string{""},
+			expectedCondition: nil,
+			expectedValid:     false,
+		}, {
+			name:              "empty contains",
+			query:             "surname[contains]",
+			value:             []string{""},
+			expectedCondition: nil,
+			expectedValid:     false,
+		}, {
+			name:              "unknown operator",
+			query:             "surname[like]",
+			value:             []string{"X"},
+			expectedCondition: nil,
+			expectedValid:     false,
+		}, {
+			name:              "operator on invalid query",
+			query:             "rank[ne]",
+			value:             []string{"1"},
+			expectedCondition: nil,
+			expectedValid:     false,
 		}, {
 			name:              "invalid query",
 			query:             "rank",
@@ -422,11 +1198,23 @@ func TestPrepareFilter(t *testing.T) {
 		tt := test
 		t.Run(tt.name, func(t *testing.T) {
 			t.Parallel()
-			res, ok := prepareFilter(tt.query, tt.value)
-			assert.Equal(t, tt.expectedValid, ok)
+			res, err := prepareFilter(tt.query, tt.value)
+			assert.Equal(t, tt.expectedValid, err == nil)
 			if !reflect.DeepEqual(tt.expectedCondition, res) {
 				t.Errorf("filter condition should match expected %v %v", tt.expectedCondition, res)
 			}
 		})
 	}
 }
+
+func TestFilterUnknownOperator(t *testing.T) {
+	db := NewMockDaoClient(nil, nil, "None")
+	handler := NewHandler(db, NewMockNotifier())
+	req, err := http.NewRequest(http.MethodGet, "/users?surname[like]=X", nil)
+	assert.Nil(t, err)
+
+	code, _, err := handler.FilterUsers(req)
+	assert.Equal(t, 400, code)
+	assert.Contains(t, err.Error(), `unknown filter operator "like"`)
+	assert.False(t, db.wasCalled)
+}
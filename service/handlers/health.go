@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+
+	"faceit/model"
+	"faceit/service/logging"
+)
+
+// ReadinessClient reports whether the dependencies of the service are reachable
+type ReadinessClient interface {
+	Report(ctx context.Context) *model.ReadinessReport
+}
+
+// GetLivenessHandler constructs a function to report that the process is serving. It does not check
+// dependencies or fail during shutdown, as restarting the service would fix neither
+func GetLivenessHandler(service, version string) func(w http.ResponseWriter, r *http.Request) {
+	return ToHandlerFunc(func(r *http.Request) (int, interface{}, error) {
+		return http.StatusOK, &HealthCheck{
+			Service: service,
+			Version: version,
+		}, nil
+	})
+}
+
+// GetReadinessHandler constructs a function to report each dependency of the service, failing
+// if any is unreachable or the service is shutting down, so orchestrators stop routing to it
+func GetReadinessHandler(probe ReadinessClient, lifecycle *Lifecycle) func(w http.ResponseWriter, r *http.Request) {
+	return ToHandlerFunc(func(r *http.Request) (int, interface{}, error) {
+		if lifecycle.ShuttingDown() {
+			return http.StatusServiceUnavailable, &model.ReadinessReport{
+				Status:       model.ReadinessShuttingDown,
+				Dependencies: []*model.DependencyStatus{},
+			}, nil
+		}
+		report := probe.Report(r.Context())
+		if report.Status != model.ReadinessReady {
+			logging.FromContext(r.Context()).WithField("report", report).Warn("service is not ready")
+			return http.StatusServiceUnavailable, report, nil
+		}
+		return http.StatusOK, report, nil
+	})
+}
@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"faceit/model"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// mockProbe returns its report, counting the reports requested
+type mockProbe struct {
+	report *model.ReadinessReport
+	calls  int
+}
+
+func (m *mockProbe) Report(ctx context.Context) *model.ReadinessReport {
+	m.calls++
+	return m.report
+}
+
+func TestLiveness(t *testing.T) {
+	handler := GetLivenessHandler("faceit-users", "0.0.1")
+
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodGet, "/livez", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	health := &HealthCheck{}
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(health))
+	assert.Equal(t, &HealthCheck{Service: "faceit-users", Version: "0.0.1"}, health)
+}
+
+func TestReadiness(t *testing.T) {
+	checked := time.Date(2021, 3, 14, 12, 0, 0, 0, time.UTC)
+	up := &model.DependencyStatus{Name: "dynamo", Status: model.DependencyUp, Latency: 1.5, Checked: checked}
+	down := &model.DependencyStatus{Name: "sns", Status: model.DependencyDown, Latency: 2000, Error: "timed out", Checked: checked}
+	tests := []struct {
+		name         string
+		report       *model.ReadinessReport
+		shuttingDown bool
+		expectedCode int
+		expected     *model.ReadinessReport
+		calls        int
+	}{
+		{
+			name:         "ready",
+			report:       &model.ReadinessReport{Status: model.ReadinessReady, Dependencies: []*model.DependencyStatus{up}},
+			expectedCode: http.StatusOK,
+			expected:     &model.ReadinessReport{Status: model.ReadinessReady, Dependencies: []*model.DependencyStatus{up}},
+			calls:        1,
+		},
+		{
+			name:         "unready",
+			report:       &model.ReadinessReport{Status: model.ReadinessUnready, Dependencies: []*model.DependencyStatus{up, down}},
+			expectedCode: http.StatusServiceUnavailable,
+			expected:     &model.ReadinessReport{Status: model.ReadinessUnready, Dependencies: []*model.DependencyStatus{up, down}},
+			calls:        1,
+		},
+		{
+			name:         "shutting down",
+			report:       &model.ReadinessReport{Status: model.ReadinessReady, Dependencies: []*model.DependencyStatus{up}},
+			shuttingDown: true,
+			expectedCode: http.StatusServiceUnavailable,
+			expected:     &model.ReadinessReport{Status: model.ReadinessShuttingDown, Dependencies: []*model.DependencyStatus{}},
+		},
+	}
+	for _, test := range tests {
+		tt := test
+		t.Run(tt.name, func(t *testing.T) {
+			probe := &mockProbe{report: tt.report}
+			lifecycle := &Lifecycle{}
+			if tt.shuttingDown {
+				lifecycle.BeginShutdown()
+			}
+			handler := GetReadinessHandler(probe, lifecycle)
+
+			w := httptest.NewRecorder()
+			handler(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+			assert.Equal(t, tt.expectedCode, w.Code)
+			report := &model.ReadinessReport{}
+			assert.NoError(t, json.NewDecoder(w.Body).Decode(report))
+			assert.Equal(t, tt.expected, report)
+			assert.Equal(t, tt.calls, probe.calls)
+		})
+	}
+}
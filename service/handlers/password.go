@@ -0,0 +1,32 @@
+package handlers
+
+import (
+	"crypto/subtle"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// passwordCost is the bcrypt work factor for newly hashed passwords
+var passwordCost = bcrypt.DefaultCost
+
+// hashPassword converts a plaintext password to the bcrypt hash that is stored
+func hashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), passwordCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// checkPassword compares a plaintext password against a stored value. Users stored before passwords
+// were hashed still hold plaintext, these are compared directly and reported as legacy so the caller
+// can migrate them to a hash. A user stored without a password never matches
+func checkPassword(stored, password string) (ok bool, legacy bool) {
+	if stored == "" {
+		return false, false
+	}
+	if _, err := bcrypt.Cost([]byte(stored)); err != nil {
+		return subtle.ConstantTimeCompare([]byte(stored), []byte(password)) == 1, true
+	}
+	return bcrypt.CompareHashAndPassword([]byte(stored), []byte(password)) == nil, false
+}
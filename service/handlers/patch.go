@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+
+	"faceit/model"
+
+	jsonpatch "github.com/evanphx/json-patch"
+)
+
+const (
+	// MergePatchType is the content type of a JSON Merge Patch, RFC 7386
+	MergePatchType = "application/merge-patch+json"
+	// JSONPatchType is the content type of a JSON Patch, RFC 6902
+	JSONPatchType = "application/json-patch+json"
+)
+
+var (
+	// errUnsupportedPatch is returned for a patch with any other content type
+	errUnsupportedPatch = fmt.Errorf("patch content type must be %s or %s", MergePatchType, JSONPatchType)
+	// errMalformedPatch is returned for a patch that cannot be parsed
+	errMalformedPatch = errors.New("malformed patch")
+	// errPatchConflict is returned for a patch that cannot be applied to the current user, e.g. a failed test operation
+	errPatchConflict = errors.New("patch cannot be applied")
+)
+
+// applyPatch applies a patch of the given content type to the JSON document of a user, the same document
+// accepted by add and update requests. The stored password is never exposed, it is empty in the document
+// unless the patch sets a new one
+func applyPatch(user *model.User, contentType string, patch []byte) (*model.AddRequest, error) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, errUnsupportedPatch
+	}
+	document, err := json.Marshal(&model.AddRequest{
+		Forename: user.Forename,
+		Surname:  user.Surname,
+		Nickname: user.Nickname,
+		Email:    user.Email,
+		Country:  user.Country,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var patched []byte
+	switch mediaType {
+	case MergePatchType:
+		patched, err = jsonpatch.MergePatch(document, patch)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", errMalformedPatch, err)
+		}
+	case JSONPatchType:
+		operations, err := jsonpatch.DecodePatch(patch)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", errMalformedPatch, err)
+		}
+		patched, err = operations.Apply(document)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", errPatchConflict, err)
+		}
+	default:
+		return nil, errUnsupportedPatch
+	}
+
+	request := &model.AddRequest{}
+	decoder := json.NewDecoder(bytes.NewReader(patched))
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(request)
+	if err != nil {
+		return nil, fmt.Errorf("patched user is invalid: %v", err)
+	}
+	return request, nil
+}
+
+// changedFields lists the fields of a patched request that differ from the user, in the order of requiredFields.
+// The password is changed whenever the patch sets one
+func changedFields(user *model.User, request *model.AddRequest) []string {
+	previous := map[string]string{
+		"forename": user.Forename,
+		"surname":  user.Surname,
+		"nickname": user.Nickname,
+		"password": "",
+		"email":    user.Email,
+		"country":  user.Country,
+	}
+	current := map[string]string{
+		"forename": request.Forename,
+		"surname":  request.Surname,
+		"nickname": request.Nickname,
+		"password": request.Password,
+		"email":    request.Email,
+		"country":  request.Country,
+	}
+	var fields []string
+	for _, field := range requiredFields {
+		if current[field] != previous[field] {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+// removeField returns the fields without the named one
+func removeField(fields []string, name string) []string {
+	kept := []string{}
+	for _, field := range fields {
+		if field != name {
+			kept = append(kept, field)
+		}
+	}
+	return kept
+}
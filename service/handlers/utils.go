@@ -2,17 +2,27 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"strconv"
+	"sync/atomic"
+
+	"faceit/service/dao"
 )
 
 const DocPath = "./docs/index.html"
 
+// RetryAfter is the number of seconds clients are asked to wait before retrying a request
+// that failed because storage was throttled or unavailable
+const RetryAfter = 1
+
 // ErrorResponse is the struct used to return error messages via the endpoints
 type ErrorResponse struct {
-	Code        int    `json:"code"`
-	Description string `json:"description"`
+	Code        int           `json:"code"`
+	Description string        `json:"description"`
+	Errors      []*FieldError `json:"errors,omitempty"`
 }
 
 // EndpointFunc defines the expected signature of any function used as an endpoint
@@ -25,6 +35,12 @@ func ToHandlerFunc(e EndpointFunc) func(w http.ResponseWriter, r *http.Request)
 		code, payload, err := e(r)
 		w.Header().Set("Content-Type", "application/json")
 		if err != nil {
+			var headers *headerError
+			if errors.As(err, &headers) {
+				for key, values := range headers.header {
+					w.Header()[key] = values
+				}
+			}
 			w.WriteHeader(code)
 			response := errorToResponse(code, err)
 			err = json.NewEncoder(w).Encode(response)
@@ -33,6 +49,12 @@ func ToHandlerFunc(e EndpointFunc) func(w http.ResponseWriter, r *http.Request)
 			}
 			return
 		}
+		if headers, ok := payload.(*headerPayload); ok {
+			for key, values := range headers.header {
+				w.Header()[key] = values
+			}
+			payload = headers.payload
+		}
 		w.WriteHeader(code)
 		if payload != nil {
 			err = json.NewEncoder(w).Encode(payload)
@@ -44,11 +66,63 @@ func ToHandlerFunc(e EndpointFunc) func(w http.ResponseWriter, r *http.Request)
 }
 
 // errorToResponse converts a HTTP status code and error description to an ErrorResponse
+// Validation errors also list each invalid field
 func errorToResponse(code int, err error) *ErrorResponse {
-	return &ErrorResponse{
+	response := &ErrorResponse{
 		Code:        code,
 		Description: err.Error(),
 	}
+	var validation *ValidationError
+	if errors.As(err, &validation) {
+		response.Description = "invalid user"
+		response.Errors = validation.Fields
+	}
+	return response
+}
+
+// headerError is an error that also sets headers on the error response, e.g. Retry-After
+type headerError struct {
+	error
+	header http.Header
+}
+
+func (e *headerError) Unwrap() error {
+	return e.error
+}
+
+// headerPayload is a payload that also sets headers on the response, e.g. ETag
+type headerPayload struct {
+	payload interface{}
+	header  http.Header
+}
+
+// retryLater attaches a Retry-After header to an error
+func retryLater(err error) error {
+	header := http.Header{}
+	header.Set("Retry-After", strconv.Itoa(RetryAfter))
+	return &headerError{
+		error:  err,
+		header: header,
+	}
+}
+
+// daoFailure converts a storage error to the status code and error returned to the caller. Missing users
+// are described using the id, and errors the caller cannot act upon are replaced by the given description
+func daoFailure(err error, id, description string) (int, error) {
+	switch {
+	case errors.Is(err, dao.ErrNotFound):
+		return http.StatusNotFound, fmt.Errorf("unable to find user: %s", id)
+	case errors.Is(err, dao.ErrConflict):
+		return http.StatusConflict, err
+	case errors.Is(err, dao.ErrPreconditionFailed):
+		return http.StatusPreconditionFailed, fmt.Errorf("user has been modified: %s", id)
+	case errors.Is(err, dao.ErrThrottled):
+		return http.StatusTooManyRequests, retryLater(errors.New("too many requests, retry later"))
+	case errors.Is(err, dao.ErrUnavailable):
+		return http.StatusServiceUnavailable, retryLater(errors.New("storage unavailable, retry later"))
+	default:
+		return http.StatusInternalServerError, errors.New(description)
+	}
 }
 
 // HealthCheck is the structure returned by the healthcheck endpoint
@@ -57,9 +131,29 @@ type HealthCheck struct {
 	Version string `json:"version"`
 }
 
-// GetHealthCheckHandler constructs a function to return service health information
-func GetHealthCheckHandler(service, version string) func(w http.ResponseWriter, r *http.Request) {
+// Lifecycle records whether the service has begun shutting down, from when it reports itself unready
+// so that load balancers stop routing to it while it drains
+type Lifecycle struct {
+	stopping int32
+}
+
+// BeginShutdown marks the service as shutting down
+func (l *Lifecycle) BeginShutdown() {
+	atomic.StoreInt32(&l.stopping, 1)
+}
+
+// ShuttingDown reports whether shutdown has begun
+func (l *Lifecycle) ShuttingDown() bool {
+	return atomic.LoadInt32(&l.stopping) == 1
+}
+
+// GetHealthCheckHandler constructs a function to return service health information, failing
+// once the service is shutting down
+func GetHealthCheckHandler(service, version string, lifecycle *Lifecycle) func(w http.ResponseWriter, r *http.Request) {
 	return ToHandlerFunc(func(r *http.Request) (int, interface{}, error) {
+		if lifecycle.ShuttingDown() {
+			return http.StatusServiceUnavailable, nil, errors.New("service is shutting down")
+		}
 		return http.StatusOK, &HealthCheck{
 			Service: service,
 			Version: version,
@@ -0,0 +1,31 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHealthCheck(t *testing.T) {
+	lifecycle := &Lifecycle{}
+	handler := GetHealthCheckHandler("faceit-users", "0.0.1", lifecycle)
+
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodGet, "/healthcheck", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	health := &HealthCheck{}
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(health))
+	assert.Equal(t, &HealthCheck{Service: "faceit-users", Version: "0.0.1"}, health)
+
+	lifecycle.BeginShutdown()
+	assert.True(t, lifecycle.ShuttingDown())
+	w = httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodGet, "/healthcheck", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	response := &ErrorResponse{}
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(response))
+	assert.Equal(t, "service is shutting down", response.Description)
+}
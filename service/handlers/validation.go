@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"fmt"
+	"net/mail"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"faceit/model"
+)
+
+// The limits below are mirrored in the UserInput schema of swagger.yaml, validation_test.go
+// checks the two stay in sync
+const (
+	// MaxNameLength is the longest forename or surname accepted
+	MaxNameLength = 64
+	// MinNicknameLength is the shortest nickname accepted
+	MinNicknameLength = 3
+	// MaxNicknameLength is the longest nickname accepted
+	MaxNicknameLength = 32
+	// MinPasswordLength is the shortest password accepted
+	MinPasswordLength = 8
+	// MaxPasswordLength is the longest password accepted, bcrypt ignores anything beyond 72 bytes
+	MaxPasswordLength = 72
+	// MaxEmailLength is the longest email address accepted
+	MaxEmailLength = 254
+
+	nicknamePattern = `^[A-Za-z0-9_-]+$`
+	countryPattern  = `^[A-Z]{3}$`
+)
+
+var nicknameRegexp = regexp.MustCompile(nicknamePattern)
+
+// requiredFields are the fields every add or update request must provide
+var requiredFields = []string{"forename", "surname", "nickname", "password", "email", "country"}
+
+// FieldError describes why a single field of a request is invalid
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError collects every field violation found in a request
+type ValidationError struct {
+	Fields []*FieldError
+}
+
+func (e *ValidationError) Error() string {
+	var messages []string
+	for _, field := range e.Fields {
+		messages = append(messages, fmt.Sprintf("%s %s", field.Field, field.Message))
+	}
+	return "invalid user: " + strings.Join(messages, ", ")
+}
+
+// validateUser checks every field of a user request, returning a ValidationError listing all violations
+func validateUser(request *model.AddRequest) error {
+	return validateFields(request, requiredFields)
+}
+
+// validateUpdate checks every field of a request replacing a stored user. Users stored before
+// countries were validated can hold a code that is not ISO 3166-1 alpha-3, such as DEN or NZ in the
+// original seed data. Their code is accepted while the update leaves it unchanged, so they can still
+// edit their other fields without first choosing a new country
+func validateUpdate(request *model.AddRequest, stored *model.User) error {
+	if request.Country == "" || request.Country != stored.Country {
+		return validateUser(request)
+	}
+	fields := []string{}
+	for _, field := range requiredFields {
+		if field != "country" {
+			fields = append(fields, field)
+		}
+	}
+	return validateFields(request, fields)
+}
+
+// validateFields checks only the named fields of a user request
+func validateFields(request *model.AddRequest, fields []string) error {
+	values := map[string]string{
+		"forename": request.Forename,
+		"surname":  request.Surname,
+		"nickname": request.Nickname,
+		"password": request.Password,
+		"email":    request.Email,
+		"country":  request.Country,
+	}
+	checks := map[string]func(string) string{
+		"forename": nameViolation,
+		"surname":  nameViolation,
+		"nickname": nicknameViolation,
+		"password": passwordViolation,
+		"email":    emailViolation,
+		"country":  countryViolation,
+	}
+	violations := &ValidationError{}
+	for _, field := range fields {
+		value := values[field]
+		message := "is required"
+		if value != "" {
+			message = checks[field](value)
+		}
+		if message != "" {
+			violations.Fields = append(violations.Fields, &FieldError{
+				Field:   field,
+				Message: message,
+			})
+		}
+	}
+	if len(violations.Fields) > 0 {
+		return violations
+	}
+	return nil
+}
+
+func nameViolation(name string) string {
+	if len([]rune(name)) > MaxNameLength {
+		return fmt.Sprintf("must be at most %d characters", MaxNameLength)
+	}
+	return ""
+}
+
+func nicknameViolation(nickname string) string {
+	if len(nickname) < MinNicknameLength || len(nickname) > MaxNicknameLength {
+		return fmt.Sprintf("must be between %d and %d characters", MinNicknameLength, MaxNicknameLength)
+	}
+	if !nicknameRegexp.MatchString(nickname) {
+		return "may only contain letters, digits, underscores and hyphens"
+	}
+	return ""
+}
+
+func passwordViolation(password string) string {
+	if len(password) < MinPasswordLength || len(password) > MaxPasswordLength {
+		return fmt.Sprintf("must be between %d and %d characters", MinPasswordLength, MaxPasswordLength)
+	}
+	var letter, digit bool
+	for _, r := range password {
+		letter = letter || unicode.IsLetter(r)
+		digit = digit || unicode.IsDigit(r)
+	}
+	if !letter || !digit {
+		return "must contain at least one letter and one digit"
+	}
+	return ""
+}
+
+func emailViolation(email string) string {
+	if len(email) > MaxEmailLength {
+		return fmt.Sprintf("must be at most %d characters", MaxEmailLength)
+	}
+	// ParseAddress also accepts display names, e.g. "Name <a@b.com>", only the bare address is allowed
+	address, err := mail.ParseAddress(email)
+	if err != nil || address.Address != email {
+		return "must be a valid email address"
+	}
+	return ""
+}
+
+func countryViolation(country string) string {
+	if !countryCodes[country] {
+		return "must be an ISO 3166-1 alpha-3 country code, e.g. FRA"
+	}
+	return ""
+}
+
+// countryCodes are the officially assigned ISO 3166-1 alpha-3 codes
+var countryCodes = map[string]bool{}
+
+func init() {
+	codes := `ABW AFG AGO AIA ALA ALB AND ARE ARG ARM ASM ATA ATF ATG AUS AUT AZE BDI BEL BEN BES BFA BGD BGR BHR
+		BHS BIH BLM BLR BLZ BMU BOL BRA BRB BRN BTN BVT BWA CAF CAN CCK CHE CHL CHN CIV CMR COD COG COK COL
+		COM CPV CRI CUB CUW CXR CYM CYP CZE DEU DJI DMA DNK DOM DZA ECU EGY ERI ESH ESP EST ETH FIN FJI FLK
+		FRA FRO FSM GAB GBR GEO GGY GHA GIB GIN GLP GMB GNB GNQ GRC GRD GRL GTM GUF GUM GUY HKG HMD HND HRV
+		HTI HUN IDN IMN IND IOT IRL IRN IRQ ISL ISR ITA JAM JEY JOR JPN KAZ KEN KGZ KHM KIR KNA KOR KWT LAO
+		LBN LBR LBY LCA LIE LKA LSO LTU LUX LVA MAC MAF MAR MCO MDA MDG MDV MEX MHL MKD MLI MLT MMR MNE MNG
+		MNP MOZ MRT MSR MTQ MUS MWI MYS MYT NAM NCL NER NFK NGA NIC NIU NLD NOR NPL NRU NZL OMN PAK PAN PCN
+		PER PHL PLW PNG POL PRI PRK PRT PRY PSE PYF QAT REU ROU RUS RWA SAU SDN SEN SGP SGS SHN SJM SLB SLE
+		SLV SMR SOM SPM SRB SSD STP SUR SVK SVN SWE SWZ SXM SYC SYR TCA TCD TGO THA TJK TKL TKM TLS TON TTO
+		TUN TUR TUV TWN TZA UGA UKR UMI URY USA UZB VAT VCT VEN VGB VIR VNM VUT WLF WSM YEM ZAF ZMB ZWE`
+	for _, code := range strings.Fields(codes) {
+		countryCodes[code] = true
+	}
+}
@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"faceit/model"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	yaml "gopkg.in/yaml.v2"
+)
+
+func validRequest() *model.AddRequest {
+	return &model.AddRequest{
+		Forename: "Mathieu",
+		Surname:  "Herbaut",
+		Nickname: "ZywOo",
+		Password: "vitality2019",
+		Email:    "mh@notarealemail.com",
+		Country:  "FRA",
+	}
+}
+
+func TestValidateUser(t *testing.T) {
+	tests := []struct {
+		name     string
+		modify   func(r *model.AddRequest)
+		expected []string
+	}{
+		{
+			name:     "valid",
+			modify:   func(r *model.AddRequest) {},
+			expected: nil,
+		}, {
+			name:     "empty",
+			modify:   func(r *model.AddRequest) { *r = model.AddRequest{} },
+			expected: []string{"forename", "surname", "nickname", "password", "email", "country"},
+		}, {
+			name:     "long name",
+			modify:   func(r *model.AddRequest) { r.Surname = strings.Repeat("a", MaxNameLength+1) },
+			expected: []string{"surname"},
+		}, {
+			name:     "short nickname",
+			modify:   func(r *model.AddRequest) { r.Nickname = "Zy" },
+			expected: []string{"nickname"},
+		}, {
+			name:     "nickname charset",
+			modify:   func(r *model.AddRequest) { r.Nickname = "Zyw Oo!" },
+			expected: []string{"nickname"},
+		}, {
+			name:     "short password",
+			modify:   func(r *model.AddRequest) { r.Password = "vit2019" },
+			expected: []string{"password"},
+		}, {
+			name:     "password without digit",
+			modify:   func(r *model.AddRequest) { r.Password = "vitalitybee" },
+			expected: []string{"password"},
+		}, {
+			name:     "long password",
+			modify:   func(r *model.AddRequest) { r.Password = strings.Repeat("a1", 37) },
+			expected: []string{"password"},
+		}, {
+			name:     "email syntax",
+			modify:   func(r *model.AddRequest) { r.Email = "mh.notarealemail.com" },
+			expected: []string{"email"},
+		}, {
+			name:     "email display name",
+			modify:   func(r *model.AddRequest) { r.Email = "Mathieu <mh@notarealemail.com>" },
+			expected: []string{"email"},
+		}, {
+			name:     "alpha-2 country",
+			modify:   func(r *model.AddRequest) { r.Country = "FR" },
+			expected: []string{"country"},
+		}, {
+			name:     "unassigned country",
+			modify:   func(r *model.AddRequest) { r.Country = "DEN" },
+			expected: []string{"country"},
+		}, {
+			name: "multiple",
+			modify: func(r *model.AddRequest) {
+				r.Country = "fra"
+				r.Email = ""
+			},
+			expected: []string{"email", "country"},
+		},
+	}
+	for _, test := range tests {
+		tt := test
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			request := validRequest()
+			tt.modify(request)
+			err := validateUser(request)
+			if tt.expected == nil {
+				assert.Nil(t, err)
+				return
+			}
+			validation, ok := err.(*ValidationError)
+			assert.True(t, ok)
+			var fields []string
+			for _, field := range validation.Fields {
+				fields = append(fields, field.Field)
+			}
+			assert.Equal(t, tt.expected, fields)
+		})
+	}
+}
+
+func TestAddUserInvalid(t *testing.T) {
+	db := NewMockDaoClient(nil, nil, "None")
+	outbox := NewMockNotifier()
+	handler := NewHandler(db, outbox)
+	req, err := http.NewRequest(http.MethodPost, "/users", strings.NewReader(`{}`))
+	assert.Nil(t, err)
+
+	code, res, err := handler.AddUser(req)
+	assert.Equal(t, 422, code)
+	assert.Nil(t, res)
+	assert.False(t, db.wasCalled)
+	assert.False(t, outbox.wasCalled)
+	response := errorToResponse(code, err)
+	assert.Equal(t, len(requiredFields), len(response.Errors))
+}
+
+// TestSwaggerUserInput checks the validation rules match those published in the UserInput schema
+func TestSwaggerUserInput(t *testing.T) {
+	content, err := ioutil.ReadFile("../../swagger.yaml")
+	assert.Nil(t, err)
+	type property struct {
+		MinLength int    `yaml:"minLength"`
+		MaxLength int    `yaml:"maxLength"`
+		Pattern   string `yaml:"pattern"`
+		Format    string `yaml:"format"`
+	}
+	spec := struct {
+		Components struct {
+			Schemas map[string]struct {
+				Required   []string            `yaml:"required"`
+				Properties map[string]property `yaml:"properties"`
+			} `yaml:"schemas"`
+		} `yaml:"components"`
+	}{}
+	assert.Nil(t, yaml.Unmarshal(content, &spec))
+	schema, ok := spec.Components.Schemas["UserInput"]
+	assert.True(t, ok, "swagger.yaml should define a UserInput schema")
+
+	assert.Equal(t, requiredFields, schema.Required)
+	expected := map[string]property{
+		"forename": {MinLength: 1, MaxLength: MaxNameLength},
+		"surname":  {MinLength: 1, MaxLength: MaxNameLength},
+		"nickname": {MinLength: MinNicknameLength, MaxLength: MaxNicknameLength, Pattern: nicknamePattern},
+		"password": {MinLength: MinPasswordLength, MaxLength: MaxPasswordLength},
+		"email":    {MaxLength: MaxEmailLength, Format: "email"},
+		"country":  {Pattern: countryPattern},
+	}
+	if !reflect.DeepEqual(expected, schema.Properties) {
+		t.Errorf("UserInput schema should match validation rules %v %v", expected, schema.Properties)
+	}
+}
@@ -0,0 +1,125 @@
+// Package health checks the dependencies of the service for the readiness probe
+package health
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"faceit/model"
+)
+
+const (
+	// DefaultTimeout bounds each dependency check
+	DefaultTimeout = 2 * time.Second
+	// DefaultCacheTTL is how long a check result is reused before the dependency is checked again
+	DefaultCacheTTL = 5 * time.Second
+)
+
+// Checker is implemented by clients of a dependency, failing if it is unreachable
+type Checker interface {
+	Check(ctx context.Context) error
+}
+
+// Check is a dependency checker and the name it is reported under
+type Check struct {
+	Name    string
+	Checker Checker
+}
+
+// Probe checks every dependency concurrently, each with its own timeout. Results are cached, so
+// frequent probes from several orchestrators do not load the dependencies, and a slow dependency
+// delays a report by at most the timeout
+type Probe struct {
+	checks  []*cachedCheck
+	timeout time.Duration
+	ttl     time.Duration
+	now     func() time.Time
+}
+
+// cachedCheck holds the latest result of a check. The lock is held while checking, so concurrent
+// reports wait for the same check rather than each making their own
+type cachedCheck struct {
+	*Check
+	mu      sync.Mutex
+	result  *model.DependencyStatus
+	expires time.Time
+}
+
+// NewProbe instantiates a probe of the given dependencies
+func NewProbe(timeout, ttl time.Duration, checks ...*Check) *Probe {
+	p := &Probe{
+		timeout: timeout,
+		ttl:     ttl,
+		now:     time.Now,
+	}
+	for _, check := range checks {
+		p.checks = append(p.checks, &cachedCheck{Check: check})
+	}
+	return p
+}
+
+// Report returns the status of every dependency, checking those without a fresh cached result.
+// The service is ready only if every dependency is up
+func (p *Probe) Report(ctx context.Context) *model.ReadinessReport {
+	report := &model.ReadinessReport{
+		Status:       model.ReadinessReady,
+		Dependencies: make([]*model.DependencyStatus, len(p.checks)),
+	}
+	var wg sync.WaitGroup
+	for i, check := range p.checks {
+		wg.Add(1)
+		go func(i int, check *cachedCheck) {
+			defer wg.Done()
+			report.Dependencies[i] = p.status(ctx, check)
+		}(i, check)
+	}
+	wg.Wait()
+	for _, dependency := range report.Dependencies {
+		if dependency.Status != model.DependencyUp {
+			report.Status = model.ReadinessUnready
+		}
+	}
+	return report
+}
+
+// status returns the cached result of a check, or checks the dependency again once it has expired
+func (p *Probe) status(ctx context.Context, check *cachedCheck) *model.DependencyStatus {
+	check.mu.Lock()
+	defer check.mu.Unlock()
+	if check.result != nil && p.now().Before(check.expires) {
+		copied := *check.result
+		return &copied
+	}
+
+	checkCtx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+	start := p.now()
+	err := check.Checker.Check(checkCtx)
+	if err == nil && checkCtx.Err() != nil {
+		// a checker ignoring its context still fails once the timeout has passed
+		err = checkCtx.Err()
+	}
+	result := &model.DependencyStatus{
+		Name:    check.Name,
+		Status:  model.DependencyUp,
+		Latency: float64(p.now().Sub(start)) / float64(time.Millisecond),
+		Checked: start.UTC(),
+	}
+	if err != nil {
+		result.Status = model.DependencyDown
+		result.Error = err.Error()
+		if checkCtx.Err() == context.DeadlineExceeded {
+			result.Error = fmt.Sprintf("timed out after %v: %v", p.timeout, err)
+		}
+	}
+
+	// a report abandoned by its caller says nothing about the dependency, so is not cached
+	if ctx.Err() == nil {
+		check.result = result
+		check.expires = start.Add(p.ttl)
+	}
+	copied := *result
+	return &copied
+}
@@ -0,0 +1,171 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"faceit/model"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// mockChecker counts its checks, failing with err or blocking until its context ends if slow
+type mockChecker struct {
+	mu     sync.Mutex
+	calls  int
+	err    error
+	slow   bool
+	ignore bool
+}
+
+func (m *mockChecker) Check(ctx context.Context) error {
+	m.mu.Lock()
+	m.calls++
+	m.mu.Unlock()
+	if m.slow {
+		if m.ignore {
+			time.Sleep(50 * time.Millisecond)
+			return nil
+		}
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	return m.err
+}
+
+func (m *mockChecker) count() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.calls
+}
+
+func TestReport(t *testing.T) {
+	tests := []struct {
+		name     string
+		checkers map[string]*mockChecker
+		status   string
+		errors   map[string]string
+	}{
+		{
+			name:   "no dependencies",
+			status: model.ReadinessReady,
+		},
+		{
+			name: "all up",
+			checkers: map[string]*mockChecker{
+				"dynamo": {},
+				"sns":    {},
+			},
+			status: model.ReadinessReady,
+		},
+		{
+			name: "one down",
+			checkers: map[string]*mockChecker{
+				"dynamo": {},
+				"sns":    {err: errors.New("connection refused")},
+			},
+			status: model.ReadinessUnready,
+			errors: map[string]string{"sns": "connection refused"},
+		},
+		{
+			name: "timeout",
+			checkers: map[string]*mockChecker{
+				"dynamo": {slow: true},
+				"sns":    {slow: true, ignore: true},
+			},
+			status: model.ReadinessUnready,
+			errors: map[string]string{
+				"dynamo": "timed out after 10ms: context deadline exceeded",
+				"sns":    "timed out after 10ms: context deadline exceeded",
+			},
+		},
+	}
+	for _, test := range tests {
+		tt := test
+		t.Run(tt.name, func(t *testing.T) {
+			var checks []*Check
+			for _, name := range []string{"dynamo", "sns"} {
+				if checker, ok := tt.checkers[name]; ok {
+					checks = append(checks, &Check{Name: name, Checker: checker})
+				}
+			}
+			probe := NewProbe(10*time.Millisecond, time.Minute, checks...)
+
+			start := time.Now()
+			report := probe.Report(context.Background())
+			assert.True(t, time.Since(start) < time.Second, "checks should run concurrently and time out")
+			assert.Equal(t, tt.status, report.Status)
+			assert.Len(t, report.Dependencies, len(checks))
+			for i, dependency := range report.Dependencies {
+				assert.Equal(t, checks[i].Name, dependency.Name)
+				assert.False(t, dependency.Checked.IsZero())
+				if expected, ok := tt.errors[dependency.Name]; ok {
+					assert.Equal(t, model.DependencyDown, dependency.Status)
+					assert.Equal(t, expected, dependency.Error)
+				} else {
+					assert.Equal(t, model.DependencyUp, dependency.Status)
+					assert.Empty(t, dependency.Error)
+				}
+			}
+		})
+	}
+}
+
+func TestReportCache(t *testing.T) {
+	checker := &mockChecker{err: errors.New("unreachable")}
+	probe := NewProbe(time.Second, 5*time.Second, &Check{Name: "dynamo", Checker: checker})
+	now := time.Date(2021, 3, 14, 12, 0, 0, 0, time.UTC)
+	probe.now = func() time.Time { return now }
+
+	first := probe.Report(context.Background())
+	assert.Equal(t, model.ReadinessUnready, first.Status)
+	assert.Equal(t, 1, checker.count())
+
+	// within the ttl the cached result is returned, even though the dependency recovered
+	checker.err = nil
+	now = now.Add(4 * time.Second)
+	cached := probe.Report(context.Background())
+	assert.Equal(t, first, cached)
+	assert.Equal(t, 1, checker.count())
+
+	now = now.Add(time.Second)
+	fresh := probe.Report(context.Background())
+	assert.Equal(t, model.ReadinessReady, fresh.Status)
+	assert.Equal(t, now, fresh.Dependencies[0].Checked)
+	assert.Equal(t, 2, checker.count())
+}
+
+func TestReportConcurrent(t *testing.T) {
+	checker := &mockChecker{slow: true, ignore: true}
+	probe := NewProbe(time.Second, time.Minute, &Check{Name: "dynamo", Checker: checker})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.Equal(t, model.ReadinessReady, probe.Report(context.Background()).Status)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 1, checker.count(), "concurrent reports should share a single check")
+}
+
+func TestReportCancelled(t *testing.T) {
+	checker := &mockChecker{slow: true}
+	probe := NewProbe(time.Second, time.Minute, &Check{Name: "dynamo", Checker: checker})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	report := probe.Report(ctx)
+	assert.Equal(t, model.ReadinessUnready, report.Status)
+
+	// the abandoned result is not cached
+	checker.slow = false
+	report = probe.Report(context.Background())
+	assert.Equal(t, model.ReadinessReady, report.Status)
+	assert.Equal(t, 2, checker.count())
+}
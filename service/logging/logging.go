@@ -0,0 +1,86 @@
+// Package logging ties the log lines of a request together with a request ID, and keeps user
+// passwords and emails out of the logs
+package logging
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// RequestIDHeader carries the request ID from the caller, and back in the response
+	RequestIDHeader = "X-Request-ID"
+
+	// maxRequestIDLength bounds the request IDs accepted from callers
+	maxRequestIDLength = 128
+)
+
+type contextKey int
+
+const (
+	entryKey contextKey = iota
+	requestIDKey
+)
+
+// Middleware assigns each request an ID, taken from the X-Request-ID header when the caller sent a
+// usable one, and returns it in the response. The context of the request carries the ID and an
+// entry of the logger with the request ID, method, path and any trace ID as fields
+func Middleware(logger *logrus.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id := r.Header.Get(RequestIDHeader)
+			if !validRequestID(id) {
+				id = uuid.New().String()
+			}
+			w.Header().Set(RequestIDHeader, id)
+
+			fields := logrus.Fields{
+				"requestId": id,
+				"method":    r.Method,
+				"path":      r.URL.Path,
+			}
+			if span := trace.SpanContextFromContext(r.Context()); span.IsValid() {
+				fields["traceId"] = span.TraceID().String()
+			}
+			ctx := context.WithValue(r.Context(), requestIDKey, id)
+			ctx = NewContext(ctx, logger.WithFields(fields))
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// validRequestID accepts IDs of printable ASCII without spaces, so a caller cannot forge log lines
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// NewContext returns a context carrying the log entry
+func NewContext(ctx context.Context, entry *logrus.Entry) context.Context {
+	return context.WithValue(ctx, entryKey, entry)
+}
+
+// FromContext returns the log entry of the request, or an entry of the standard logger outside one
+func FromContext(ctx context.Context) *logrus.Entry {
+	if entry, ok := ctx.Value(entryKey).(*logrus.Entry); ok {
+		return entry
+	}
+	return logrus.NewEntry(logrus.StandardLogger())
+}
+
+// RequestID returns the ID of the request, empty outside one
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
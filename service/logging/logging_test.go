@@ -0,0 +1,78 @@
+package logging
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestMiddleware(t *testing.T) {
+	traced := trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID: trace.TraceID{0x4b, 0xf9, 0x2f, 0x35, 0x77, 0xb3, 0x4d, 0xa6, 0xa3, 0xce, 0x92, 0x9d, 0x0e, 0x0e, 0x47, 0x36},
+		SpanID:  trace.SpanID{0x00, 0xf0, 0x67, 0xaa, 0x0b, 0xa9, 0x02, 0xb7},
+	}))
+	tests := []struct {
+		name      string
+		requestID string
+		ctx       context.Context
+		generated bool
+		traceID   string
+	}{
+		{name: "propagated", requestID: "7d8a4c2e-checkout", ctx: context.Background()},
+		{name: "missing", ctx: context.Background(), generated: true},
+		{name: "spaces", requestID: "forged\" level=error", ctx: context.Background(), generated: true},
+		{name: "too long", requestID: strings.Repeat("a", 129), ctx: context.Background(), generated: true},
+		{name: "traced", requestID: "traced", ctx: traced, traceID: "4bf92f3577b34da6a3ce929d0e0e4736"},
+	}
+	for _, test := range tests {
+		tt := test
+		t.Run(tt.name, func(t *testing.T) {
+			logger, hook := logtest.NewNullLogger()
+			var seen string
+			handler := Middleware(logger)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				seen = RequestID(r.Context())
+				FromContext(r.Context()).Info("handled")
+			}))
+			req := httptest.NewRequest(http.MethodGet, "/users/abc", nil).WithContext(tt.ctx)
+			if tt.requestID != "" {
+				req.Header.Set(RequestIDHeader, tt.requestID)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			id := w.Header().Get(RequestIDHeader)
+			if tt.generated {
+				assert.Len(t, id, 36)
+			} else {
+				assert.Equal(t, tt.requestID, id)
+			}
+			assert.Equal(t, id, seen)
+			entry := hook.LastEntry()
+			assert.Equal(t, id, entry.Data["requestId"])
+			assert.Equal(t, http.MethodGet, entry.Data["method"])
+			assert.Equal(t, "/users/abc", entry.Data["path"])
+			if tt.traceID == "" {
+				assert.NotContains(t, entry.Data, "traceId")
+			} else {
+				assert.Equal(t, tt.traceID, entry.Data["traceId"])
+			}
+		})
+	}
+}
+
+func TestFromContext(t *testing.T) {
+	// outside a request the standard logger is used
+	assert.Equal(t, logrus.StandardLogger(), FromContext(context.Background()).Logger)
+	assert.Equal(t, "", RequestID(context.Background()))
+
+	logger, _ := logtest.NewNullLogger()
+	entry := logger.WithField("requestId", "abc")
+	assert.Equal(t, entry, FromContext(NewContext(context.Background(), entry)))
+}
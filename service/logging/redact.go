@@ -0,0 +1,117 @@
+package logging
+
+import (
+	"encoding/json"
+	"net/url"
+	"reflect"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Redacted replaces the value of a sensitive field in the logs
+const Redacted = "[REDACTED]"
+
+// sensitive lists the fields masked wherever they appear, by their lower case name
+var sensitive = map[string]bool{
+	"password": true,
+	"email":    true,
+}
+
+// RedactHook masks passwords and emails in every log line, whether logged as a field of their own
+// or within a logged value such as a user or message. Values other than errors and scalars are
+// logged as their JSON form with the sensitive fields masked
+type RedactHook struct{}
+
+// Levels applies the hook at every level
+func (RedactHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// Fire replaces the fields of the entry with redacted copies. The entry logged is a copy of the one
+// the fields were added to, so this never changes the fields of an entry shared by a request
+func (RedactHook) Fire(entry *logrus.Entry) error {
+	data := make(logrus.Fields, len(entry.Data))
+	for key, value := range entry.Data {
+		data[key] = redactField(key, value)
+	}
+	entry.Data = data
+	return nil
+}
+
+// isSensitive reports whether a field is masked
+func isSensitive(key string) bool {
+	return sensitive[strings.ToLower(key)]
+}
+
+// RedactQuery masks the values of sensitive params in a raw query string, such as email filters. A
+// param is sensitive by its field name, so email[prefix] is masked as email is
+func RedactQuery(query string) string {
+	if query == "" {
+		return query
+	}
+	params := strings.Split(query, "&")
+	for i, param := range params {
+		key := strings.SplitN(param, "=", 2)[0]
+		name, err := url.QueryUnescape(key)
+		if err != nil {
+			name = key
+		}
+		if open := strings.Index(name, "["); open >= 0 {
+			name = name[:open]
+		}
+		if isSensitive(name) {
+			params[i] = key + "=" + Redacted
+		}
+	}
+	return strings.Join(params, "&")
+}
+
+// redactField masks a sensitive field, or the sensitive fields within a structured value
+func redactField(key string, value interface{}) interface{} {
+	if isSensitive(key) {
+		return Redacted
+	}
+	if _, ok := value.(error); ok || value == nil {
+		return value
+	}
+	switch reflect.ValueOf(value).Kind() {
+	case reflect.Struct, reflect.Ptr, reflect.Map, reflect.Slice, reflect.Array, reflect.Interface:
+	default:
+		return value
+	}
+	content, err := json.Marshal(value)
+	if err != nil {
+		return value
+	}
+	var decoded interface{}
+	err = json.Unmarshal(content, &decoded)
+	if err != nil {
+		return value
+	}
+	return redactValue(decoded)
+}
+
+// redactValue masks the sensitive fields of a decoded JSON value, including the before and after
+// values of a field change to one
+func redactValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		field, _ := v["field"].(string)
+		for key, item := range v {
+			if isSensitive(key) || (isSensitive(field) && (key == "before" || key == "after")) {
+				v[key] = Redacted
+				continue
+			}
+			v[key] = redactValue(item)
+		}
+		return v
+	case []interface{}:
+		for i, item := range v {
+			v[i] = redactValue(item)
+		}
+		return v
+	default:
+		return value
+	}
+}
@@ -0,0 +1,80 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+
+	"faceit/model"
+)
+
+func TestRedactHook(t *testing.T) {
+	user := &model.User{
+		Id:       "dummy-test-user",
+		Nickname: "Xyp9x",
+		Password: "$2a$10$hash",
+		Email:    "ah@notarealemail.com",
+		Country:  "DNK",
+	}
+	moved := *user
+	moved.Email = "ah@moved.com"
+
+	var buffer bytes.Buffer
+	logger := logrus.New()
+	logger.SetOutput(&buffer)
+	logger.SetFormatter(&logrus.JSONFormatter{})
+	logger.AddHook(RedactHook{})
+
+	request := logger.WithField("requestId", "abc")
+	request.WithFields(logrus.Fields{
+		"user":     user,
+		"message":  model.NewChangeMessage(model.UserUpdate, user, &moved, false),
+		"Email":    "ah@notarealemail.com",
+		"password": "astralis",
+		"fields":   []string{"email"},
+		"error":    errors.New("unable to store user"),
+		"count":    3,
+	}).Info("update user")
+
+	logged := map[string]interface{}{}
+	assert.NoError(t, json.Unmarshal(buffer.Bytes(), &logged))
+	assert.NotContains(t, buffer.String(), "notarealemail")
+	assert.NotContains(t, buffer.String(), "moved.com")
+	assert.NotContains(t, buffer.String(), "hash")
+	assert.NotContains(t, buffer.String(), "astralis")
+
+	assert.Equal(t, Redacted, logged["Email"])
+	assert.Equal(t, Redacted, logged["password"])
+	assert.Equal(t, "Xyp9x", logged["user"].(map[string]interface{})["nickname"])
+	assert.Equal(t, Redacted, logged["user"].(map[string]interface{})["email"])
+	message := logged["message"].(map[string]interface{})
+	assert.Equal(t, Redacted, message["after"].(map[string]interface{})["email"])
+	diff := message["diff"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, "email", diff["field"])
+	assert.Equal(t, Redacted, diff["before"])
+	assert.Equal(t, Redacted, diff["after"])
+	assert.Equal(t, []interface{}{"email"}, logged["fields"])
+	assert.Equal(t, "unable to store user", logged["error"])
+	assert.Equal(t, 3.0, logged["count"])
+	assert.Equal(t, "abc", logged["requestId"])
+
+	// the entry shared by the request keeps its fields
+	assert.Equal(t, logrus.Fields{"requestId": "abc"}, request.Data)
+}
+
+func TestRedactQuery(t *testing.T) {
+	tests := map[string]string{
+		"":                                     "",
+		"country=NZL":                          "country=NZL",
+		"email=ns%40notarealemail.com&limit=5": "email=[REDACTED]&limit=5",
+		"Email[contains]=notarealemail":        "Email[contains]=[REDACTED]",
+		"email%5Bin%5D=a,b&password":           "email%5Bin%5D=[REDACTED]&password=[REDACTED]",
+	}
+	for query, expected := range tests {
+		assert.Equal(t, expected, RedactQuery(query), query)
+	}
+}
@@ -0,0 +1,103 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"faceit/model"
+	"faceit/service/dao"
+	"faceit/service/handlers"
+	"faceit/service/outbox"
+)
+
+// Storage is a user store which also holds the outbox, as used by the handlers, relay and replays
+type Storage interface {
+	handlers.DAOClient
+	outbox.Store
+}
+
+// DAO decorates a storage, timing each operation and counting its errors
+type DAO struct {
+	db      Storage
+	metrics *Metrics
+}
+
+// DAO instruments a storage
+func (m *Metrics) DAO(db Storage) *DAO {
+	return &DAO{
+		db:      db,
+		metrics: m,
+	}
+}
+
+// Get times the storage Get
+func (d *DAO) Get(ctx context.Context, id string) (*model.User, error) {
+	start := time.Now()
+	user, err := d.db.Get(ctx, id)
+	d.metrics.observeDAO("Get", start, err)
+	return user, err
+}
+
+// Insert times the storage Insert
+func (d *DAO) Insert(ctx context.Context, user *model.User, msg *model.Message) error {
+	start := time.Now()
+	err := d.db.Insert(ctx, user, msg)
+	d.metrics.observeDAO("Insert", start, err)
+	return err
+}
+
+// Update times the storage Update
+func (d *DAO) Update(ctx context.Context, user *model.User, fields []string, msg *model.Message) error {
+	start := time.Now()
+	err := d.db.Update(ctx, user, fields, msg)
+	d.metrics.observeDAO("Update", start, err)
+	return err
+}
+
+// Delete times the storage Delete
+func (d *DAO) Delete(ctx context.Context, userId string, version int64, msg *model.Message) error {
+	start := time.Now()
+	err := d.db.Delete(ctx, userId, version, msg)
+	d.metrics.observeDAO("Delete", start, err)
+	return err
+}
+
+// Filter times the storage Filter
+func (d *DAO) Filter(ctx context.Context, conditions []*model.FilterCondition, page *model.Page) ([]*model.User, string, error) {
+	start := time.Now()
+	users, cursor, err := d.db.Filter(ctx, conditions, page)
+	d.metrics.observeDAO("Filter", start, err)
+	return users, cursor, err
+}
+
+// GetAll times the storage GetAll
+func (d *DAO) GetAll(ctx context.Context, page *model.Page) ([]*model.User, string, error) {
+	start := time.Now()
+	users, cursor, err := d.db.GetAll(ctx, page)
+	d.metrics.observeDAO("GetAll", start, err)
+	return users, cursor, err
+}
+
+// Pending times the outbox Pending
+func (d *DAO) Pending(ctx context.Context, limit int) ([]*dao.OutboxEntry, error) {
+	start := time.Now()
+	entries, err := d.db.Pending(ctx, limit)
+	d.metrics.observeDAO("Pending", start, err)
+	return entries, err
+}
+
+// MarkDelivered times the outbox MarkDelivered
+func (d *DAO) MarkDelivered(ctx context.Context, id string) error {
+	start := time.Now()
+	err := d.db.MarkDelivered(ctx, id)
+	d.metrics.observeDAO("MarkDelivered", start, err)
+	return err
+}
+
+// MarkFailed times the outbox MarkFailed
+func (d *DAO) MarkFailed(ctx context.Context, id string, cause error) error {
+	start := time.Now()
+	err := d.db.MarkFailed(ctx, id, cause)
+	d.metrics.observeDAO("MarkFailed", start, err)
+	return err
+}
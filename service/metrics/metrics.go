@@ -0,0 +1,226 @@
+// Package metrics collects Prometheus metrics of the endpoints, storage and publishers
+package metrics
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	log "github.com/sirupsen/logrus"
+
+	"faceit/service/dao"
+)
+
+const (
+	// Namespace prefixes the name of every metric of the service
+	Namespace = "faceit"
+
+	// ResultSuccess and ResultFailure label the outcome of a publish
+	ResultSuccess = "success"
+	ResultFailure = "failure"
+
+	// scrapeTimeout bounds the storage queries made for gauges on each scrape
+	scrapeTimeout = 2 * time.Second
+)
+
+// Metrics holds the collectors of the service, registered on its own registry
+type Metrics struct {
+	registry        *prometheus.Registry
+	requests        *prometheus.CounterVec
+	requestDuration *prometheus.HistogramVec
+	daoDuration     *prometheus.HistogramVec
+	daoErrors       *prometheus.CounterVec
+	publishes       *prometheus.CounterVec
+	publishDuration *prometheus.HistogramVec
+}
+
+// New instantiates the metrics of the service, along with the go runtime and process metrics
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Subsystem: "http",
+			Name:      "requests_total",
+			Help:      "Requests handled, by route template, method and status code.",
+		}, []string{"route", "method", "status"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: Namespace,
+			Subsystem: "http",
+			Name:      "request_duration_seconds",
+			Help:      "Time taken to handle requests, by route template, method and status code.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"route", "method", "status"}),
+		daoDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: Namespace,
+			Subsystem: "dao",
+			Name:      "operation_duration_seconds",
+			Help:      "Time taken by storage operations, by method.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"operation"}),
+		daoErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Subsystem: "dao",
+			Name:      "errors_total",
+			Help:      "Storage operations that failed, by method and kind of error.",
+		}, []string{"operation", "error"}),
+		publishes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Subsystem: "publisher",
+			Name:      "publishes_total",
+			Help:      "Attempts to publish a message, by publisher and result.",
+		}, []string{"target", "result"}),
+		publishDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: Namespace,
+			Subsystem: "publisher",
+			Name:      "publish_duration_seconds",
+			Help:      "Time taken by attempts to publish a message, by publisher.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"target"}),
+	}
+	m.registry.MustRegister(
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+		m.requests,
+		m.requestDuration,
+		m.daoDuration,
+		m.daoErrors,
+		m.publishes,
+		m.publishDuration,
+	)
+	return m
+}
+
+// Handler serves the metrics in the Prometheus exposition format
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// Middleware counts and times the requests of the routes of a router, labelled by the template of
+// the matched route. Used ahead of the authentication and policy middleware, the requests they
+// refuse are counted too
+func (m *Metrics) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+		labels := prometheus.Labels{
+			"route":  routeTemplate(r),
+			"method": r.Method,
+			"status": strconv.Itoa(recorder.status),
+		}
+		m.requests.With(labels).Inc()
+		m.requestDuration.With(labels).Observe(time.Since(start).Seconds())
+	})
+}
+
+// routeTemplate returns the template of the matched route, e.g. /users/{id}, so user ids do not
+// each become a label value
+func routeTemplate(r *http.Request) string {
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return "unmatched"
+	}
+	template, err := route.GetPathTemplate()
+	if err != nil {
+		return "unmatched"
+	}
+	return template
+}
+
+// statusRecorder records the status code written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	s.status = code
+	s.ResponseWriter.WriteHeader(code)
+}
+
+// observeDAO records the duration of a storage operation, and its error if it failed
+func (m *Metrics) observeDAO(operation string, start time.Time, err error) {
+	m.daoDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+	if err != nil {
+		m.daoErrors.WithLabelValues(operation, errorKind(err)).Inc()
+	}
+}
+
+// errorKind names the typed storage errors, anything else is internal
+func errorKind(err error) string {
+	switch {
+	case errors.Is(err, dao.ErrNotFound):
+		return "not_found"
+	case errors.Is(err, dao.ErrConflict):
+		return "conflict"
+	case errors.Is(err, dao.ErrPreconditionFailed):
+		return "precondition_failed"
+	case errors.Is(err, dao.ErrThrottled):
+		return "throttled"
+	case errors.Is(err, dao.ErrUnavailable):
+		return "unavailable"
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return "cancelled"
+	default:
+		return "internal"
+	}
+}
+
+// PendingCounter is implemented by outbox stores able to count their undelivered entries
+type PendingCounter interface {
+	PendingCount(ctx context.Context) (int, error)
+}
+
+// DeadLetterCounter is implemented by dead letter stores able to count their letters
+type DeadLetterCounter interface {
+	DeadLetterCount(ctx context.Context) (int, error)
+}
+
+// RegisterOutbox adds a gauge of the undelivered outbox entries, counted on each scrape
+func (m *Metrics) RegisterOutbox(store PendingCounter) {
+	m.registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: Namespace,
+		Subsystem: "outbox",
+		Name:      "pending_entries",
+		Help:      "Messages written to the outbox and not yet published.",
+	}, func() float64 {
+		ctx, cancel := context.WithTimeout(context.Background(), scrapeTimeout)
+		defer cancel()
+		count, err := store.PendingCount(ctx)
+		return gaugeValue("outbox", count, err)
+	}))
+}
+
+// RegisterDeadLetters adds a gauge of the stored dead letters, counted on each scrape
+func (m *Metrics) RegisterDeadLetters(store DeadLetterCounter) {
+	m.registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: Namespace,
+		Subsystem: "publisher",
+		Name:      "dead_letters",
+		Help:      "Messages a publisher refused after every retry, waiting to be replayed.",
+	}, func() float64 {
+		ctx, cancel := context.WithTimeout(context.Background(), scrapeTimeout)
+		defer cancel()
+		count, err := store.DeadLetterCount(ctx)
+		return gaugeValue("dead letters", count, err)
+	}))
+}
+
+// gaugeValue converts a count to a gauge value, a count that could not be read is not a number
+func gaugeValue(name string, count int, err error) float64 {
+	if err != nil {
+		log.WithFields(log.Fields{
+			"gauge": name,
+			"error": err,
+		}).Warn("unable to count for metrics")
+		return math.NaN()
+	}
+	return float64(count)
+}
@@ -0,0 +1,164 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+
+	"faceit/model"
+	"faceit/service/dao"
+	"faceit/service/handlers"
+)
+
+// scrape returns the metrics as served to Prometheus
+func scrape(t *testing.T, m *Metrics) string {
+	w := httptest.NewRecorder()
+	m.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	body, err := ioutil.ReadAll(w.Body)
+	assert.NoError(t, err)
+	return string(body)
+}
+
+func TestMiddleware(t *testing.T) {
+	m := New()
+	r := mux.NewRouter()
+	r.Use(m.Middleware)
+	r.HandleFunc("/healthcheck", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	// requests refused by the middleware of a subrouter are counted against the route they matched
+	api := r.NewRoute().Subrouter()
+	api.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") == "" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	})
+	api.HandleFunc("/users/{id}", handlers.ToHandlerFunc(func(r *http.Request) (int, interface{}, error) {
+		if mux.Vars(r)["id"] == "missing" {
+			return http.StatusNotFound, nil, errors.New("unable to find user: missing")
+		}
+		return http.StatusOK, &model.User{Id: mux.Vars(r)["id"]}, nil
+	})).Methods(http.MethodGet)
+
+	for _, path := range []string{"/users/a", "/users/b", "/users/missing", "/healthcheck"} {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Authorization", "Bearer token")
+		r.ServeHTTP(w, req)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/users/a", nil))
+
+	assert.Equal(t, 2.0, testutil.ToFloat64(m.requests.WithLabelValues("/users/{id}", http.MethodGet, "200")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.requests.WithLabelValues("/users/{id}", http.MethodGet, "404")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.requests.WithLabelValues("/users/{id}", http.MethodGet, "401")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.requests.WithLabelValues("/healthcheck", http.MethodGet, "200")))
+	assert.Equal(t, 4, testutil.CollectAndCount(m.requestDuration))
+	assert.Contains(t, scrape(t, m), `faceit_http_request_duration_seconds_count{method="GET",route="/users/{id}",status="200"} 2`)
+}
+
+func TestDAO(t *testing.T) {
+	m := New()
+	db := m.DAO(dao.NewMemoryClient())
+	ctx := context.Background()
+
+	user := &model.User{Id: "dao", Nickname: "s1mple"}
+	assert.NoError(t, db.Insert(ctx, user, model.NewMessage(user.Id, model.UserAdd)))
+	_, err := db.Get(ctx, user.Id)
+	assert.NoError(t, err)
+	_, err = db.Get(ctx, "missing")
+	assert.Equal(t, dao.ErrNotFound, err)
+	assert.Equal(t, &dao.ConflictError{Field: "nickname"}, db.Insert(ctx, &model.User{Id: "other", Nickname: "S1MPLE"}, nil))
+	pending, err := db.Pending(ctx, 10)
+	assert.NoError(t, err)
+	assert.NoError(t, db.MarkDelivered(ctx, pending[0].Id))
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.daoErrors.WithLabelValues("Get", "not_found")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.daoErrors.WithLabelValues("Insert", "conflict")))
+	assert.Equal(t, 2, testutil.CollectAndCount(m.daoErrors))
+	// Insert, Get, Pending and MarkDelivered
+	assert.Equal(t, 4, testutil.CollectAndCount(m.daoDuration))
+	assert.Contains(t, scrape(t, m), `faceit_dao_operation_duration_seconds_count{operation="Get"} 2`)
+}
+
+func TestErrorKind(t *testing.T) {
+	assert.Equal(t, "precondition_failed", errorKind(dao.ErrPreconditionFailed))
+	assert.Equal(t, "throttled", errorKind(dao.ErrThrottled))
+	assert.Equal(t, "unavailable", errorKind(dao.ErrUnavailable))
+	assert.Equal(t, "cancelled", errorKind(context.Canceled))
+	assert.Equal(t, "internal", errorKind(errors.New("unable to decode user")))
+}
+
+// mockClient fails every publish if given an error, recording whether it was closed
+type mockClient struct {
+	err    error
+	closed bool
+}
+
+func (c *mockClient) Publish(ctx context.Context, msg *model.Message) error {
+	return c.err
+}
+
+func (c *mockClient) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestPublisher(t *testing.T) {
+	m := New()
+	client := &mockClient{}
+	sns := m.Publisher("sns", client)
+	webhook := m.Publisher("webhook", &mockClient{err: errors.New("unable to post")})
+	msg := model.NewMessage("publisher", model.UserAdd)
+
+	assert.NoError(t, sns.Publish(context.Background(), msg))
+	assert.NoError(t, sns.Publish(context.Background(), msg))
+	assert.EqualError(t, webhook.Publish(context.Background(), msg), "unable to post")
+
+	assert.Equal(t, 2.0, testutil.ToFloat64(m.publishes.WithLabelValues("sns", ResultSuccess)))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.publishes.WithLabelValues("webhook", ResultFailure)))
+	assert.Equal(t, 2, testutil.CollectAndCount(m.publishDuration))
+
+	assert.NoError(t, sns.Close())
+	assert.True(t, client.closed)
+}
+
+// mockDeadLetters counts its letters, or fails with err
+type mockDeadLetters struct {
+	letters []*model.DeadLetter
+	err     error
+}
+
+func (m *mockDeadLetters) DeadLetterCount(ctx context.Context) (int, error) {
+	return len(m.letters), m.err
+}
+
+func TestGauges(t *testing.T) {
+	m := New()
+	db := dao.NewMemoryClient()
+	letters := &mockDeadLetters{letters: []*model.DeadLetter{{Id: "a"}, {Id: "b"}}}
+	m.RegisterOutbox(db)
+	m.RegisterDeadLetters(letters)
+
+	for _, id := range []string{"a", "b", "c"} {
+		assert.NoError(t, db.Insert(context.Background(), &model.User{Id: id, Nickname: id}, model.NewMessage(id, model.UserAdd)))
+	}
+	metrics := scrape(t, m)
+	assert.Contains(t, metrics, "faceit_outbox_pending_entries 3")
+	assert.Contains(t, metrics, "faceit_publisher_dead_letters 2")
+
+	letters.err = errors.New("unable to read dead letters")
+	assert.Contains(t, scrape(t, m), "faceit_publisher_dead_letters NaN")
+}
@@ -0,0 +1,48 @@
+package metrics
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"faceit/model"
+	"faceit/service/publisher"
+)
+
+// Publisher decorates a publisher, counting and timing each attempt to publish. Retries made by
+// the fan out are each counted, so failures show a publisher struggling before messages are dead lettered
+type Publisher struct {
+	target  string
+	client  publisher.MsgClient
+	metrics *Metrics
+}
+
+// Publisher instruments the publisher of a fan out target
+func (m *Metrics) Publisher(target string, client publisher.MsgClient) *Publisher {
+	return &Publisher{
+		target:  target,
+		client:  client,
+		metrics: m,
+	}
+}
+
+// Publish times the publish, counting its result
+func (p *Publisher) Publish(ctx context.Context, msg *model.Message) error {
+	start := time.Now()
+	err := p.client.Publish(ctx, msg)
+	p.metrics.publishDuration.WithLabelValues(p.target).Observe(time.Since(start).Seconds())
+	result := ResultSuccess
+	if err != nil {
+		result = ResultFailure
+	}
+	p.metrics.publishes.WithLabelValues(p.target, result).Inc()
+	return err
+}
+
+// Close closes the publisher if it holds connections, so the fan out can still close it once instrumented
+func (p *Publisher) Close() error {
+	if closer, ok := p.client.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package outbox
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"faceit/model"
+	"faceit/service/dao"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultInterval is how often the relay polls for pending entries when it is not notified of new ones
+	DefaultInterval = 5 * time.Second
+	// BatchSize is the number of pending entries read from the store at a time
+	BatchSize = 25
+	// MaxAttempts is the number of times an entry is published before the relay gives up until the next poll
+	MaxAttempts = 3
+	// InitialBackoff is the wait after the first failed attempt, it doubles with each further failure
+	InitialBackoff = 100 * time.Millisecond
+)
+
+// Store is the outbox storage the relay drains, every user store implements it
+type Store interface {
+	Pending(ctx context.Context, limit int) ([]*dao.OutboxEntry, error)
+	MarkDelivered(ctx context.Context, id string) error
+	MarkFailed(ctx context.Context, id string, cause error) error
+}
+
+// MsgClient is the messaging operation used to deliver events to other services
+type MsgClient interface {
+	Publish(ctx context.Context, msg *model.Message) error
+}
+
+// Relay publishes the entries of an outbox in the order they were written, marking each delivered once sent.
+// An entry that cannot be published holds back those after it, and is retried on the next poll
+type Relay struct {
+	store    Store
+	msg      MsgClient
+	interval time.Duration
+	backoff  time.Duration
+	wake     chan struct{}
+}
+
+// NewRelay instantiates a relay from a store to a publisher, polling at the given interval
+func NewRelay(store Store, msg MsgClient, interval time.Duration) *Relay {
+	return &Relay{
+		store:    store,
+		msg:      msg,
+		interval: interval,
+		backoff:  InitialBackoff,
+		wake:     make(chan struct{}, 1),
+	}
+}
+
+// Notify wakes the relay to publish newly written entries without waiting for the next poll.
+// It never blocks, notifications made while the relay is busy are coalesced
+func (r *Relay) Notify() {
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run drains the outbox on every poll or notification until the context is cancelled
+func (r *Relay) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		err := r.Flush(ctx)
+		if err != nil && ctx.Err() == nil {
+			log.WithField("error", err).Error("unable to flush outbox")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-r.wake:
+		}
+	}
+}
+
+// Flush publishes pending entries until the outbox is empty or an entry cannot be delivered
+func (r *Relay) Flush(ctx context.Context) error {
+	for {
+		entries, err := r.store.Pending(ctx, BatchSize)
+		if err != nil {
+			return fmt.Errorf("unable to read outbox: %w", err)
+		}
+		for _, entry := range entries {
+			err = r.deliver(ctx, entry)
+			if err != nil {
+				return err
+			}
+		}
+		if len(entries) < BatchSize {
+			return nil
+		}
+	}
+}
+
+// deliver publishes an entry, retrying with exponential backoff, and marks it delivered or failed
+func (r *Relay) deliver(ctx context.Context, entry *dao.OutboxEntry) error {
+	backoff := r.backoff
+	var err error
+	for attempt := 1; attempt <= MaxAttempts; attempt++ {
+		err = r.msg.Publish(ctx, entry.Message)
+		if err == nil {
+			log.WithField("event", entry.Id).Info("published outbox entry")
+			return r.store.MarkDelivered(ctx, entry.Id)
+		}
+		log.WithFields(log.Fields{
+			"event":   entry.Id,
+			"attempt": attempt,
+			"error":   err,
+		}).Warn("unable to publish outbox entry")
+		if attempt == MaxAttempts {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+	markErr := r.store.MarkFailed(ctx, entry.Id, err)
+	if markErr != nil {
+		log.WithFields(log.Fields{
+			"event": entry.Id,
+			"error": markErr,
+		}).Error("unable to record failed outbox entry")
+	}
+	return fmt.Errorf("unable to publish outbox entry %s: %w", entry.Id, err)
+}
@@ -0,0 +1,147 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"faceit/model"
+	"faceit/service/dao"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// flakyMsgClient fails the given number of publishes before succeeding
+type flakyMsgClient struct {
+	failures  int
+	attempts  int
+	published []*model.Message
+}
+
+func (m *flakyMsgClient) Publish(ctx context.Context, msg *model.Message) error {
+	m.attempts++
+	if m.failures > 0 {
+		m.failures--
+		return errors.New("unable to publish")
+	}
+	m.published = append(m.published, msg)
+	return nil
+}
+
+// newTestRelay returns a relay over an in memory store holding a message for each id
+func newTestRelay(t *testing.T, msg MsgClient, ids ...string) (*Relay, *dao.MemoryClient) {
+	store := dao.NewMemoryClient()
+	for _, id := range ids {
+		err := store.Insert(context.Background(), &model.User{Id: id}, model.NewMessage(id, model.UserAdd))
+		assert.Nil(t, err)
+	}
+	relay := NewRelay(store, msg, time.Hour)
+	relay.backoff = time.Millisecond
+	return relay, store
+}
+
+func TestFlush(t *testing.T) {
+	tests := []struct {
+		name              string
+		failures          int
+		expectedErr       bool
+		expectedAttempts  int
+		expectedPublished []string
+		expectedPending   int
+	}{
+		{
+			name:              "delivered",
+			failures:          0,
+			expectedErr:       false,
+			expectedAttempts:  2,
+			expectedPublished: []string{"first", "second"},
+			expectedPending:   0,
+		},
+		{
+			name:              "retried",
+			failures:          MaxAttempts - 1,
+			expectedErr:       false,
+			expectedAttempts:  MaxAttempts + 1,
+			expectedPublished: []string{"first", "second"},
+			expectedPending:   0,
+		},
+		{
+			name:              "failed",
+			failures:          MaxAttempts,
+			expectedErr:       true,
+			expectedAttempts:  MaxAttempts,
+			expectedPublished: []string{},
+			expectedPending:   2,
+		},
+	}
+	for _, test := range tests {
+		tt := test
+		t.Run(tt.name, func(t *testing.T) {
+			msg := &flakyMsgClient{failures: tt.failures, published: []*model.Message{}}
+			relay, store := newTestRelay(t, msg, "first", "second")
+
+			err := relay.Flush(context.Background())
+			assert.Equal(t, tt.expectedErr, err != nil)
+			assert.Equal(t, tt.expectedAttempts, msg.attempts)
+			published := []string{}
+			for _, m := range msg.published {
+				published = append(published, m.Id)
+			}
+			assert.Equal(t, tt.expectedPublished, published)
+
+			pending, err := store.Pending(context.Background(), BatchSize)
+			assert.Nil(t, err)
+			assert.Equal(t, tt.expectedPending, len(pending))
+			if tt.expectedErr {
+				// the failure is recorded and the later entry is held back
+				assert.Equal(t, "first", pending[0].Message.Id)
+				assert.Equal(t, 1, pending[0].Attempts)
+				assert.Equal(t, 0, pending[1].Attempts)
+			}
+		})
+	}
+}
+
+func TestFlushCancelled(t *testing.T) {
+	msg := &flakyMsgClient{failures: MaxAttempts}
+	relay, store := newTestRelay(t, msg, "first")
+	relay.backoff = time.Hour
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	err := relay.Flush(ctx)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	assert.Equal(t, 1, msg.attempts)
+	pending, err := store.Pending(context.Background(), BatchSize)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(pending))
+}
+
+func TestRunNotify(t *testing.T) {
+	msg := &flakyMsgClient{}
+	relay, store := newTestRelay(t, msg)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		relay.Run(ctx)
+		close(done)
+	}()
+
+	err := store.Insert(ctx, &model.User{Id: "notified"}, model.NewMessage("notified", model.UserAdd))
+	assert.Nil(t, err)
+	relay.Notify()
+	relay.Notify()
+
+	// the interval is an hour, so only the notification can deliver the entry
+	deadline := time.Now().Add(time.Second)
+	pending, err := store.Pending(ctx, BatchSize)
+	for err == nil && len(pending) > 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+		pending, err = store.Pending(ctx, BatchSize)
+	}
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(pending))
+	cancel()
+	<-done
+}
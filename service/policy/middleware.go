@@ -0,0 +1,71 @@
+package policy
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+
+	"faceit/service/auth"
+	"faceit/service/handlers"
+	"faceit/service/logging"
+)
+
+// Enforcer applies a policy to the requests of a router, after they have been authenticated
+type Enforcer struct {
+	policy *Policy
+}
+
+// NewEnforcer creates an enforcer of the policy
+func NewEnforcer(policy *Policy) *Enforcer {
+	return &Enforcer{
+		policy: policy,
+	}
+}
+
+// Middleware denies requests the policy does not allow with a 403, writing an audit log entry of
+// who was denied what and why. The caller is told no more than that access was denied
+func (e *Enforcer) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		identity := &Identity{}
+		if claims, ok := auth.FromContext(ctx); ok {
+			identity = &Identity{
+				Subject: claims.Subject,
+				Roles:   claims.Roles,
+				Scopes:  strings.Fields(claims.Scope),
+			}
+		}
+		route := ""
+		if current := mux.CurrentRoute(r); current != nil {
+			route, _ = current.GetPathTemplate()
+		}
+		vars := mux.Vars(r)
+		err := e.policy.Authorize(identity, route, r.Method, vars)
+		if err == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		logging.FromContext(ctx).WithFields(log.Fields{
+			"audit":   "authorization",
+			"subject": identity.Subject,
+			"roles":   identity.Roles,
+			"scopes":  identity.Scopes,
+			"route":   route,
+			"target":  vars[OwnerVar],
+			"reason":  err.Error(),
+		}).Warn("access denied")
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		err = json.NewEncoder(w).Encode(&handlers.ErrorResponse{
+			Code:        http.StatusForbidden,
+			Description: "access denied",
+		})
+		if err != nil {
+			log.WithField("error", err).Error("unable to write response")
+		}
+	})
+}
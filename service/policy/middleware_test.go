@@ -0,0 +1,128 @@
+package policy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/square/go-jose.v2/jwt"
+
+	"faceit/service/auth"
+	"faceit/service/handlers"
+	"faceit/service/logging"
+)
+
+func TestMiddleware(t *testing.T) {
+	p, err := Load("../../config/policy.yaml")
+	if !assert.NoError(t, err) {
+		return
+	}
+	tests := []struct {
+		name   string
+		claims *auth.Claims
+		method string
+		path   string
+		code   int
+		reason string
+	}{
+		{
+			name:   "owner",
+			claims: &auth.Claims{Claims: jwt.Claims{Subject: "testing"}, Roles: []string{"user"}},
+			method: http.MethodGet,
+			path:   "/users/testing",
+			code:   http.StatusOK,
+		},
+		{
+			name:   "not the owner",
+			claims: &auth.Claims{Claims: jwt.Claims{Subject: "testing"}, Roles: []string{"user"}, Scope: "profile email"},
+			method: http.MethodDelete,
+			path:   "/users/other",
+			code:   http.StatusForbidden,
+			reason: "access denied: not the owner of other",
+		},
+		{
+			name:   "service account",
+			claims: &auth.Claims{Claims: jwt.Claims{Subject: "search"}, Roles: []string{"service"}},
+			method: http.MethodGet,
+			path:   "/users/search",
+			code:   http.StatusForbidden,
+			reason: "access denied: no role or scope granted GET /users/{id}",
+		},
+		{
+			name:   "unauthenticated",
+			method: http.MethodGet,
+			path:   "/users/testing",
+			code:   http.StatusForbidden,
+			reason: "access denied: no role or scope granted GET /users/{id}",
+		},
+	}
+	for _, test := range tests {
+		tt := test
+		t.Run(tt.name, func(t *testing.T) {
+			logger, hook := logtest.NewNullLogger()
+			r := mux.NewRouter()
+			r.Use(func(next http.Handler) http.Handler {
+				return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					ctx := logging.NewContext(r.Context(), logrus.NewEntry(logger))
+					if tt.claims != nil {
+						ctx = auth.NewContext(ctx, tt.claims)
+					}
+					next.ServeHTTP(w, r.WithContext(ctx))
+				})
+			}, NewEnforcer(p).Middleware)
+			r.HandleFunc("/users/{id}", func(w http.ResponseWriter, r *http.Request) {}).
+				Methods(http.MethodGet, http.MethodDelete)
+
+			rr := httptest.NewRecorder()
+			r.ServeHTTP(rr, httptest.NewRequest(tt.method, tt.path, nil))
+			assert.Equal(t, tt.code, rr.Code)
+			if tt.code == http.StatusOK {
+				assert.Empty(t, hook.AllEntries())
+				return
+			}
+
+			var body handlers.ErrorResponse
+			assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &body))
+			assert.Equal(t, handlers.ErrorResponse{Code: http.StatusForbidden, Description: "access denied"}, body)
+			entry := hook.LastEntry()
+			if assert.NotNil(t, entry) {
+				assert.Equal(t, logrus.WarnLevel, entry.Level)
+				assert.Equal(t, "authorization", entry.Data["audit"])
+				assert.Equal(t, "/users/{id}", entry.Data["route"])
+				assert.Equal(t, tt.reason, entry.Data["reason"])
+				if tt.claims != nil {
+					assert.Equal(t, tt.claims.Subject, entry.Data["subject"])
+					assert.Equal(t, tt.claims.Roles, entry.Data["roles"])
+				}
+			}
+		})
+	}
+}
+
+func TestMiddlewareScopes(t *testing.T) {
+	p, err := Parse([]byte("rules:\n  - route: /users/{id}\n    methods: [GET]\n    scopes: [users:read]\n"))
+	if !assert.NoError(t, err) {
+		return
+	}
+	r := mux.NewRouter()
+	r.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims := &auth.Claims{Claims: jwt.Claims{Subject: "search"}, Scope: r.URL.Query().Get("scope")}
+			next.ServeHTTP(w, r.WithContext(auth.NewContext(r.Context(), claims)))
+		})
+	}, NewEnforcer(p).Middleware)
+	r.HandleFunc("/users/{id}", func(w http.ResponseWriter, r *http.Request) {}).Methods(http.MethodGet)
+
+	// the scope claim is a space separated list
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/users/testing?scope=openid+users:read", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/users/testing?scope=openid+users:reader", nil))
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+}
@@ -0,0 +1,181 @@
+// Package policy authorizes requests against a declarative policy mapping each route and method to
+// the roles allowed to call it
+package policy
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"gopkg.in/yaml.v2"
+)
+
+// OwnerVar is the path variable naming the user a request acts on, compared to the caller's subject
+// by owner grants
+const OwnerVar = "id"
+
+// Rule grants the roles in Roles, and tokens holding any of the scopes in Scopes, access to a route
+// for the given methods, and the roles in Owner access only when the {id} of the path is the
+// caller's own
+type Rule struct {
+	Route   string   `yaml:"route"`
+	Methods []string `yaml:"methods"`
+	Roles   []string `yaml:"roles"`
+	Scopes  []string `yaml:"scopes"`
+	Owner   []string `yaml:"owner"`
+}
+
+// Identity is who is calling, as authenticated from their token
+type Identity struct {
+	Subject string
+	Roles   []string
+	Scopes  []string
+}
+
+// DeniedError explains why a request was denied, for the audit log rather than the caller
+type DeniedError struct {
+	Reason string
+}
+
+func (e *DeniedError) Error() string {
+	return "access denied: " + e.Reason
+}
+
+// Policy holds the rules of each route and method. Requests matching no rule are denied
+type Policy struct {
+	rules map[string]*Rule
+}
+
+// key identifies the rule of a route and method
+func key(route, method string) string {
+	return strings.ToUpper(method) + " " + route
+}
+
+// methods are those a rule may list
+var methods = map[string]bool{
+	http.MethodGet:    true,
+	http.MethodPost:   true,
+	http.MethodPut:    true,
+	http.MethodPatch:  true,
+	http.MethodDelete: true,
+}
+
+// Load reads a policy from a YAML file
+func Load(path string) (*Policy, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read policy: %v", err)
+	}
+	return Parse(content)
+}
+
+// Parse reads a policy from YAML, checking each rule names a route, valid methods and at least one
+// role or scope, that owner grants are only given on routes with an {id}, and that no route and method has
+// more than one rule
+func Parse(content []byte) (*Policy, error) {
+	file := struct {
+		Rules []*Rule `yaml:"rules"`
+	}{}
+	err := yaml.UnmarshalStrict(content, &file)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse policy: %v", err)
+	}
+	p := &Policy{rules: map[string]*Rule{}}
+	var problems []string
+	for i, rule := range file.Rules {
+		problem := func(format string, args ...interface{}) {
+			problems = append(problems, fmt.Sprintf("rule %d: ", i+1)+fmt.Sprintf(format, args...))
+		}
+		if rule.Route == "" {
+			problem("no route")
+		}
+		if len(rule.Methods) == 0 {
+			problem("no methods")
+		}
+		if len(rule.Roles) == 0 && len(rule.Scopes) == 0 && len(rule.Owner) == 0 {
+			problem("no roles or scopes")
+		}
+		if len(rule.Owner) > 0 && !strings.Contains(rule.Route, "{"+OwnerVar+"}") {
+			problem("owner roles require a route with {%s}", OwnerVar)
+		}
+		for _, method := range rule.Methods {
+			if !methods[strings.ToUpper(method)] {
+				problem("unknown method %s", method)
+				continue
+			}
+			if _, ok := p.rules[key(rule.Route, method)]; ok {
+				problem("%s %s already has a rule", strings.ToUpper(method), rule.Route)
+				continue
+			}
+			p.rules[key(rule.Route, method)] = rule
+		}
+	}
+	if len(problems) > 0 {
+		return nil, errors.New("invalid policy: " + strings.Join(problems, "; "))
+	}
+	return p, nil
+}
+
+// Authorize allows the request if the caller has a role or scope granted the route and method, or
+// an owner role and the {id} of the path is their subject
+func (p *Policy) Authorize(identity *Identity, route, method string, vars map[string]string) error {
+	rule, ok := p.rules[key(route, method)]
+	if !ok {
+		return &DeniedError{Reason: "no rule for " + key(route, method)}
+	}
+	if hasAny(identity.Roles, rule.Roles) || hasAny(identity.Scopes, rule.Scopes) {
+		return nil
+	}
+	if hasAny(identity.Roles, rule.Owner) {
+		if identity.Subject != "" && vars[OwnerVar] == identity.Subject {
+			return nil
+		}
+		return &DeniedError{Reason: "not the owner of " + vars[OwnerVar]}
+	}
+	return &DeniedError{Reason: "no role or scope granted " + key(route, method)}
+}
+
+// CheckRouter fails if any route and method of the router has no rule, so a new endpoint cannot be
+// released without deciding who may call it
+func (p *Policy) CheckRouter(router *mux.Router) error {
+	var missing []string
+	err := router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		template, err := route.GetPathTemplate()
+		if err != nil {
+			return nil
+		}
+		routeMethods, err := route.GetMethods()
+		if err != nil {
+			missing = append(missing, "any method of "+template)
+			return nil
+		}
+		for _, method := range routeMethods {
+			if _, ok := p.rules[key(template, method)]; !ok {
+				missing = append(missing, key(template, method))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(missing) > 0 {
+		return errors.New("no policy rule for " + strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// hasAny reports whether any of the roles or scopes held is granted
+func hasAny(held, granted []string) bool {
+	for _, role := range held {
+		for _, grant := range granted {
+			if role == grant {
+				return true
+			}
+		}
+	}
+	return false
+}
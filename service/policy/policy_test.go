@@ -0,0 +1,148 @@
+package policy
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		err     string
+	}{
+		{
+			name:    "valid",
+			content: "rules:\n  - route: /users/{id}\n    methods: [get, PUT]\n    roles: [admin]\n    owner: [user]\n",
+		},
+		{
+			name:    "scopes only",
+			content: "rules:\n  - route: /users\n    methods: [GET]\n    scopes: [users:search]\n",
+		},
+		{
+			name:    "unknown field",
+			content: "rules:\n  - route: /users\n    methods: [GET]\n    role: [admin]\n",
+			err:     "unable to parse policy",
+		},
+		{
+			name:    "incomplete rule",
+			content: "rules:\n  - methods: [TRACE]\n",
+			err:     "invalid policy: rule 1: no route; rule 1: no roles or scopes; rule 1: unknown method TRACE",
+		},
+		{
+			name:    "owner without id",
+			content: "rules:\n  - route: /users\n    methods: [GET]\n    owner: [user]\n",
+			err:     "rule 1: owner roles require a route with {id}",
+		},
+		{
+			name:    "duplicate",
+			content: "rules:\n  - route: /users\n    methods: [GET]\n    roles: [admin]\n  - route: /users\n    methods: [get]\n    roles: [service]\n",
+			err:     "rule 2: GET /users already has a rule",
+		},
+	}
+	for _, test := range tests {
+		tt := test
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse([]byte(tt.content))
+			if tt.err == "" {
+				assert.NoError(t, err)
+				return
+			}
+			if assert.Error(t, err) {
+				assert.Contains(t, err.Error(), tt.err)
+			}
+		})
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	p, err := Load("../../config/policy.yaml")
+	if !assert.NoError(t, err) {
+		return
+	}
+	admin := &Identity{Subject: "root", Roles: []string{"admin"}}
+	user := &Identity{Subject: "testing", Roles: []string{"user"}}
+	service := &Identity{Subject: "search", Roles: []string{"service"}}
+	tests := []struct {
+		name     string
+		identity *Identity
+		route    string
+		method   string
+		id       string
+		allowed  bool
+	}{
+		{name: "admin gets any user", identity: admin, route: "/users/{id}", method: http.MethodGet, id: "testing", allowed: true},
+		{name: "admin adds users", identity: admin, route: "/users", method: http.MethodPost, allowed: true},
+		{name: "admin replays", identity: admin, route: "/admin/events/replay", method: http.MethodPost, allowed: true},
+		{name: "user gets themselves", identity: user, route: "/users/{id}", method: http.MethodGet, id: "testing", allowed: true},
+		{name: "user updates themselves", identity: user, route: "/users/{id}", method: http.MethodPut, id: "testing", allowed: true},
+		{name: "user deletes themselves", identity: user, route: "/users/{id}", method: http.MethodDelete, id: "testing", allowed: true},
+		{name: "user gets another user", identity: user, route: "/users/{id}", method: http.MethodGet, id: "other"},
+		{name: "user deletes another user", identity: user, route: "/users/{id}", method: http.MethodDelete, id: "other"},
+		{name: "user searches", identity: user, route: "/users", method: http.MethodGet},
+		{name: "user replays", identity: user, route: "/admin/events/replay", method: http.MethodPost},
+		{name: "service searches", identity: service, route: "/users", method: http.MethodGet, allowed: true},
+		{name: "service gets a user", identity: service, route: "/users/{id}", method: http.MethodGet, id: "search"},
+		{name: "service adds users", identity: service, route: "/users", method: http.MethodPost},
+		{name: "no roles", identity: &Identity{Subject: "testing"}, route: "/users/{id}", method: http.MethodGet, id: "testing"},
+		{name: "no rule", identity: admin, route: "/users/{id}/avatar", method: http.MethodGet, id: "testing"},
+	}
+	for _, test := range tests {
+		tt := test
+		t.Run(tt.name, func(t *testing.T) {
+			err := p.Authorize(tt.identity, tt.route, tt.method, map[string]string{"id": tt.id})
+			if tt.allowed {
+				assert.NoError(t, err)
+			} else {
+				assert.IsType(t, &DeniedError{}, err)
+			}
+		})
+	}
+}
+
+func TestAuthorizeScopes(t *testing.T) {
+	p, err := Parse([]byte("rules:\n  - route: /users\n    methods: [GET]\n    roles: [admin]\n    scopes: [users:search, users:admin]\n"))
+	if !assert.NoError(t, err) {
+		return
+	}
+	tests := []struct {
+		name     string
+		identity *Identity
+		allowed  bool
+	}{
+		{name: "granted scope", identity: &Identity{Subject: "search", Scopes: []string{"openid", "users:search"}}, allowed: true},
+		{name: "granted role without scopes", identity: &Identity{Subject: "root", Roles: []string{"admin"}}, allowed: true},
+		{name: "other scopes", identity: &Identity{Subject: "search", Scopes: []string{"openid", "users:read"}}},
+		{name: "scope named as a role", identity: &Identity{Subject: "search", Roles: []string{"users:search"}}},
+	}
+	for _, test := range tests {
+		tt := test
+		t.Run(tt.name, func(t *testing.T) {
+			err := p.Authorize(tt.identity, "/users", http.MethodGet, nil)
+			if tt.allowed {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, "access denied: no role or scope granted GET /users")
+			}
+		})
+	}
+}
+
+func TestCheckRouter(t *testing.T) {
+	p, err := Parse([]byte("rules:\n  - route: /users\n    methods: [GET]\n    roles: [admin]\n"))
+	if !assert.NoError(t, err) {
+		return
+	}
+	noop := func(w http.ResponseWriter, r *http.Request) {}
+	r := mux.NewRouter()
+	api := r.NewRoute().Subrouter()
+	api.HandleFunc("/users", noop).Methods(http.MethodGet)
+	assert.NoError(t, p.CheckRouter(api))
+
+	api.HandleFunc("/users", noop).Methods(http.MethodPost)
+	api.HandleFunc("/users/{id}", noop)
+	assert.EqualError(t, p.CheckRouter(api), "no policy rule for POST /users, any method of /users/{id}")
+}
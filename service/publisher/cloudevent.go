@@ -0,0 +1,91 @@
+package publisher
+
+import (
+	"strconv"
+	"time"
+
+	"faceit/model"
+)
+
+const (
+	// SpecVersion is the version of the CloudEvents specification events conform to
+	SpecVersion = "1.0"
+	// Source identifies the service as the producer of the events
+	Source = "/faceit/users"
+	// ContentType is the content type of an event in the structured JSON mode
+	ContentType = "application/cloudevents+json"
+	// DataContentType is the content type of the message carried by an event
+	DataContentType = "application/json"
+	// DataSchema describes the message carried by an event
+	DataSchema = "events.yaml#/components/schemas/Message"
+
+	// TypeUserCreated is the event type of a user being added
+	TypeUserCreated = "com.faceit.user.created"
+	// TypeUserUpdated is the event type of a user being changed
+	TypeUserUpdated = "com.faceit.user.updated"
+	// TypeUserDeleted is the event type of a user being removed
+	TypeUserDeleted = "com.faceit.user.deleted"
+	// TypeUserSnapshot is the event type of the current state of a user, emitted by a replay
+	TypeUserSnapshot = "com.faceit.user.snapshot"
+)
+
+// eventTypes maps the actions of messages to event types
+var eventTypes = map[string]string{
+	model.UserAdd:      TypeUserCreated,
+	model.UserUpdate:   TypeUserUpdated,
+	model.UserDelete:   TypeUserDeleted,
+	model.UserSnapshot: TypeUserSnapshot,
+}
+
+// CloudEvent is a CloudEvents 1.0 envelope in the structured JSON mode. Sequence is the sequence
+// extension, carrying the user version so consumers can order the events of a user
+type CloudEvent struct {
+	SpecVersion     string         `json:"specversion"`
+	Id              string         `json:"id"`
+	Source          string         `json:"source"`
+	Type            string         `json:"type"`
+	Subject         string         `json:"subject"`
+	Time            time.Time      `json:"time"`
+	DataContentType string         `json:"datacontenttype"`
+	DataSchema      string         `json:"dataschema"`
+	Sequence        string         `json:"sequence"`
+	Data            *model.Message `json:"data"`
+}
+
+// NewCloudEvent wraps a message in an event, reusing its event Id so redelivered messages can be
+// recognised
+func NewCloudEvent(msg *model.Message) *CloudEvent {
+	return &CloudEvent{
+		SpecVersion:     SpecVersion,
+		Id:              msg.EventId,
+		Source:          Source,
+		Type:            EventType(msg.Action),
+		Subject:         msg.Id,
+		Time:            msg.Created.UTC(),
+		DataContentType: DataContentType,
+		DataSchema:      DataSchema,
+		Sequence:        strconv.FormatInt(msg.Version, 10),
+		Data:            msg,
+	}
+}
+
+// EventType converts the action of a message to its event type
+func EventType(action string) string {
+	eventType, ok := eventTypes[action]
+	if !ok {
+		return "com.faceit.user.unknown"
+	}
+	return eventType
+}
+
+// Country returns the country of the user a message describes, as it is after the change, or
+// before it for deletes
+func Country(msg *model.Message) string {
+	if msg.After != nil {
+		return msg.After.Country
+	}
+	if msg.Before != nil {
+		return msg.Before.Country
+	}
+	return ""
+}
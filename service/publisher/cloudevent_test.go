@@ -0,0 +1,113 @@
+package publisher
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"testing"
+
+	"faceit/model"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
+)
+
+func TestNewCloudEvent(t *testing.T) {
+	user := &model.User{Id: "dummy-test-user", Nickname: "gla1ve", Country: "DNK", Version: 4}
+	moved := *user
+	moved.Country = "SWE"
+	tests := []struct {
+		name            string
+		msg             *model.Message
+		expectedType    string
+		expectedCountry string
+		expectedSeq     string
+	}{
+		{
+			name:            "created",
+			msg:             model.NewChangeMessage(model.UserAdd, nil, &model.User{Id: user.Id, Country: "DNK"}, false),
+			expectedType:    TypeUserCreated,
+			expectedCountry: "DNK",
+			expectedSeq:     "1",
+		}, {
+			name:            "updated",
+			msg:             model.NewChangeMessage(model.UserUpdate, user, &moved, false),
+			expectedType:    TypeUserUpdated,
+			expectedCountry: "SWE",
+			expectedSeq:     "5",
+		}, {
+			name:            "deleted",
+			msg:             model.NewChangeMessage(model.UserDelete, user, nil, false),
+			expectedType:    TypeUserDeleted,
+			expectedCountry: "DNK",
+			expectedSeq:     "5",
+		}, {
+			name:            "snapshot",
+			msg:             model.NewSnapshotMessage(user),
+			expectedType:    TypeUserSnapshot,
+			expectedCountry: "DNK",
+			expectedSeq:     "4",
+		}, {
+			name:            "bare message",
+			msg:             model.NewMessage(user.Id, model.UserDelete),
+			expectedType:    TypeUserDeleted,
+			expectedCountry: "",
+			expectedSeq:     "0",
+		},
+	}
+	for _, test := range tests {
+		tt := test
+		t.Run(tt.name, func(t *testing.T) {
+			event := NewCloudEvent(tt.msg)
+			assert.Equal(t, SpecVersion, event.SpecVersion)
+			assert.Equal(t, tt.msg.EventId, event.Id)
+			assert.Equal(t, tt.expectedType, event.Type)
+			assert.Equal(t, user.Id, event.Subject)
+			assert.Equal(t, tt.expectedSeq, event.Sequence)
+
+			attributes := messageAttributes(event)
+			assert.Equal(t, tt.expectedType, *attributes[AttributeEventType].StringValue)
+			if tt.expectedCountry == "" {
+				assert.NotContains(t, attributes, AttributeCountry)
+			} else {
+				assert.Equal(t, tt.expectedCountry, *attributes[AttributeCountry].StringValue)
+			}
+
+			// the required context attributes are always present in the structured mode
+			body, err := json.Marshal(event)
+			assert.Nil(t, err)
+			decoded := map[string]interface{}{}
+			assert.Nil(t, json.Unmarshal(body, &decoded))
+			for _, attribute := range []string{"specversion", "id", "source", "type", "data"} {
+				assert.NotEmpty(t, decoded[attribute], attribute)
+			}
+		})
+	}
+}
+
+// TestEventsSchema checks the envelope matches the CloudEvent schema published in events.yaml
+func TestEventsSchema(t *testing.T) {
+	content, err := ioutil.ReadFile("../../events.yaml")
+	assert.Nil(t, err)
+	spec := struct {
+		Components struct {
+			Schemas map[string]struct {
+				Required []string `yaml:"required"`
+			} `yaml:"schemas"`
+		} `yaml:"components"`
+	}{}
+	assert.Nil(t, yaml.Unmarshal(content, &spec))
+	schema, ok := spec.Components.Schemas["CloudEvent"]
+	assert.True(t, ok, "events.yaml should define a CloudEvent schema")
+
+	fields := []string{}
+	kind := reflect.TypeOf(CloudEvent{})
+	for i := 0; i < kind.NumField(); i++ {
+		fields = append(fields, kind.Field(i).Tag.Get("json"))
+	}
+	sort.Strings(fields)
+	required := append([]string{}, schema.Required...)
+	sort.Strings(required)
+	assert.Equal(t, fields, required)
+}
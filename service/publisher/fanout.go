@@ -0,0 +1,210 @@
+package publisher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"sync"
+	"time"
+
+	"faceit/model"
+
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultAttempts is the number of times a message is published to a target before it is dead lettered
+	DefaultAttempts = 5
+	// DefaultBaseBackoff is the longest wait after the first failed attempt, it doubles with each further failure
+	DefaultBaseBackoff = 100 * time.Millisecond
+	// DefaultMaxBackoff caps the wait between attempts
+	DefaultMaxBackoff = 5 * time.Second
+)
+
+var (
+	// ErrUnknownTarget is returned when replaying a dead letter for a publisher that is not configured
+	ErrUnknownTarget = errors.New("unknown publisher")
+	// ErrReplayFailed is returned when a dead letter still cannot be published
+	ErrReplayFailed = errors.New("unable to replay dead letter")
+)
+
+// MsgClient is the messaging operation every publisher implements
+type MsgClient interface {
+	Publish(ctx context.Context, msg *model.Message) error
+}
+
+// DeadLetterStore keeps the messages that could not be published until they are replayed
+type DeadLetterStore interface {
+	AddDeadLetter(ctx context.Context, letter *model.DeadLetter) error
+	DeadLetters(ctx context.Context, limit int) ([]*model.DeadLetter, error)
+	DeadLetterCount(ctx context.Context) (int, error)
+	GetDeadLetter(ctx context.Context, id string) (*model.DeadLetter, error)
+	RemoveDeadLetter(ctx context.Context, id string) error
+}
+
+// Target is a publisher and the name its dead letters are recorded under
+type Target struct {
+	Name   string
+	Client MsgClient
+}
+
+// FanOut publishes every message to each of its targets. Failures are retried with exponential
+// backoff and jitter, and a message a target still refuses is dead lettered for that target alone,
+// so one failing publisher neither blocks nor duplicates the delivery to the others
+type FanOut struct {
+	targets  map[string]MsgClient
+	names    []string
+	dead     DeadLetterStore
+	attempts int
+	base     time.Duration
+	max      time.Duration
+}
+
+// NewFanOut instantiates a publisher to the given targets, dead lettering to the store
+func NewFanOut(dead DeadLetterStore, targets ...*Target) *FanOut {
+	f := &FanOut{
+		targets:  map[string]MsgClient{},
+		dead:     dead,
+		attempts: DefaultAttempts,
+		base:     DefaultBaseBackoff,
+		max:      DefaultMaxBackoff,
+	}
+	for _, target := range targets {
+		f.targets[target.Name] = target.Client
+		f.names = append(f.names, target.Name)
+	}
+	return f
+}
+
+// Publish publishes a message to every target concurrently. It only fails if the context ends
+// before a target has been tried every time, or a dead letter cannot be stored, in which case the
+// message should be published again
+func (f *FanOut) Publish(ctx context.Context, msg *model.Message) error {
+	errs := make([]error, len(f.names))
+	var wg sync.WaitGroup
+	for i, name := range f.names {
+		wg.Add(1)
+		go func(i int, name string) {
+			defer wg.Done()
+			errs[i] = f.deliver(ctx, name, msg)
+		}(i, name)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deliver publishes a message to a single target, dead lettering it if every attempt fails
+func (f *FanOut) deliver(ctx context.Context, name string, msg *model.Message) error {
+	attempts, err := f.retry(ctx, f.targets[name], msg)
+	if err == nil {
+		return nil
+	}
+	if attempts < f.attempts || ctx.Err() != nil {
+		return fmt.Errorf("unable to publish to %s: %w", name, err)
+	}
+	letter := &model.DeadLetter{
+		Id:       uuid.New().String(),
+		Target:   name,
+		Message:  msg,
+		Error:    err.Error(),
+		Attempts: attempts,
+		Failed:   time.Now().UTC(),
+	}
+	log.WithFields(log.Fields{
+		"event":      msg.EventId,
+		"target":     name,
+		"deadLetter": letter.Id,
+		"error":      err,
+	}).Warn("dead lettering message")
+	err = f.dead.AddDeadLetter(ctx, letter)
+	if err != nil {
+		return fmt.Errorf("unable to store dead letter for %s: %w", name, err)
+	}
+	return nil
+}
+
+// retry publishes a message until it succeeds or the attempts run out, returning the number of
+// attempts made. It gives up early rather than wait past the deadline of the context
+func (f *FanOut) retry(ctx context.Context, client MsgClient, msg *model.Message) (int, error) {
+	for attempt := 1; ; attempt++ {
+		err := client.Publish(ctx, msg)
+		if err == nil || attempt == f.attempts {
+			return attempt, err
+		}
+		wait := f.backoff(attempt)
+		if deadline, ok := ctx.Deadline(); ok && time.Now().Add(wait).After(deadline) {
+			return attempt, fmt.Errorf("%w: %v", context.DeadlineExceeded, err)
+		}
+		select {
+		case <-ctx.Done():
+			return attempt, fmt.Errorf("%w: %v", ctx.Err(), err)
+		case <-time.After(wait):
+		}
+	}
+}
+
+// backoff returns a random wait of up to the exponential backoff of an attempt, so publishers
+// recovering from an outage are not retried in step
+func (f *FanOut) backoff(attempt int) time.Duration {
+	ceiling := f.max
+	if attempt < 32 {
+		exponential := f.base << uint(attempt-1)
+		if exponential > 0 && exponential < ceiling {
+			ceiling = exponential
+		}
+	}
+	return time.Duration(rand.Int63n(int64(ceiling) + 1))
+}
+
+// DeadLetters lists up to limit dead letters, oldest first
+func (f *FanOut) DeadLetters(ctx context.Context, limit int) ([]*model.DeadLetter, error) {
+	return f.dead.DeadLetters(ctx, limit)
+}
+
+// Replay publishes a dead letter again to the target that refused it, and removes it once delivered
+func (f *FanOut) Replay(ctx context.Context, id string) error {
+	letter, err := f.dead.GetDeadLetter(ctx, id)
+	if err != nil {
+		return err
+	}
+	client, ok := f.targets[letter.Target]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownTarget, letter.Target)
+	}
+	_, err = f.retry(ctx, client, letter.Message)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrReplayFailed, err)
+	}
+	return f.dead.RemoveDeadLetter(ctx, id)
+}
+
+// Close closes every target holding connections or buffered messages, such as the kafka producer
+// or nats connection, returning the first error
+func (f *FanOut) Close() error {
+	var first error
+	for _, name := range f.names {
+		closer, ok := f.targets[name].(io.Closer)
+		if !ok {
+			continue
+		}
+		err := closer.Close()
+		if err != nil {
+			log.WithFields(log.Fields{
+				"target": name,
+				"error":  err,
+			}).Warn("unable to close publisher")
+			if first == nil {
+				first = err
+			}
+		}
+	}
+	return first
+}
@@ -0,0 +1,267 @@
+package publisher
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"faceit/model"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// flakyClient fails the given number of publishes before succeeding, a negative number always fails
+type flakyClient struct {
+	mu        sync.Mutex
+	failures  int
+	attempts  int
+	published int
+}
+
+func (c *flakyClient) Publish(ctx context.Context, msg *model.Message) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.attempts++
+	if c.failures != 0 {
+		c.failures--
+		return errors.New("unable to publish")
+	}
+	c.published++
+	return nil
+}
+
+type mockDeadLetters struct {
+	mu      sync.Mutex
+	letters map[string]*model.DeadLetter
+	fail    bool
+}
+
+func newMockDeadLetters() *mockDeadLetters {
+	return &mockDeadLetters{letters: map[string]*model.DeadLetter{}}
+}
+
+func (m *mockDeadLetters) AddDeadLetter(ctx context.Context, letter *model.DeadLetter) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.fail {
+		return errors.New("unable to store dead letter")
+	}
+	m.letters[letter.Id] = letter
+	return nil
+}
+
+func (m *mockDeadLetters) DeadLetterCount(ctx context.Context) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.letters), nil
+}
+
+func (m *mockDeadLetters) DeadLetters(ctx context.Context, limit int) ([]*model.DeadLetter, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	letters := []*model.DeadLetter{}
+	for _, letter := range m.letters {
+		letters = append(letters, letter)
+	}
+	sort.Slice(letters, func(i, j int) bool { return letters[i].Id < letters[j].Id })
+	return letters, nil
+}
+
+func (m *mockDeadLetters) GetDeadLetter(ctx context.Context, id string) (*model.DeadLetter, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	letter, ok := m.letters[id]
+	if !ok {
+		return nil, errors.New("no such dead letter")
+	}
+	return letter, nil
+}
+
+func (m *mockDeadLetters) RemoveDeadLetter(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.letters, id)
+	return nil
+}
+
+// newTestFanOut returns a fan out with short waits between attempts
+func newTestFanOut(dead DeadLetterStore, targets ...*Target) *FanOut {
+	f := NewFanOut(dead, targets...)
+	f.base = time.Millisecond
+	f.max = 5 * time.Millisecond
+	return f
+}
+
+func TestFanOutPublish(t *testing.T) {
+	tests := []struct {
+		name                string
+		failures            int
+		failDeadLetter      bool
+		expectedErr         bool
+		expectedAttempts    int
+		expectedDeadLetters int
+	}{
+		{
+			name:                "delivered",
+			failures:            0,
+			expectedAttempts:    1,
+			expectedDeadLetters: 0,
+		}, {
+			name:                "retried",
+			failures:            DefaultAttempts - 1,
+			expectedAttempts:    DefaultAttempts,
+			expectedDeadLetters: 0,
+		}, {
+			name:                "dead lettered",
+			failures:            -1,
+			expectedAttempts:    DefaultAttempts,
+			expectedDeadLetters: 1,
+		}, {
+			name:             "dead letter failed",
+			failures:         -1,
+			failDeadLetter:   true,
+			expectedErr:      true,
+			expectedAttempts: DefaultAttempts,
+		},
+	}
+	for _, test := range tests {
+		tt := test
+		t.Run(tt.name, func(t *testing.T) {
+			healthy := &flakyClient{}
+			flaky := &flakyClient{failures: tt.failures}
+			dead := newMockDeadLetters()
+			dead.fail = tt.failDeadLetter
+			f := newTestFanOut(dead, &Target{Name: "healthy", Client: healthy}, &Target{Name: "flaky", Client: flaky})
+
+			msg := model.NewMessage("dummy-test-user", model.UserAdd)
+			err := f.Publish(context.Background(), msg)
+			assert.Equal(t, tt.expectedErr, err != nil)
+			assert.Equal(t, tt.expectedAttempts, flaky.attempts)
+			// the healthy target is unaffected by the failures of the other
+			assert.Equal(t, 1, healthy.attempts)
+			assert.Equal(t, 1, healthy.published)
+
+			letters, err := f.DeadLetters(context.Background(), 0)
+			assert.Nil(t, err)
+			assert.Equal(t, tt.expectedDeadLetters, len(letters))
+			if tt.expectedDeadLetters > 0 {
+				assert.Equal(t, "flaky", letters[0].Target)
+				assert.Equal(t, msg.EventId, letters[0].Message.EventId)
+				assert.Equal(t, DefaultAttempts, letters[0].Attempts)
+			}
+		})
+	}
+}
+
+func TestFanOutDeadline(t *testing.T) {
+	client := &flakyClient{failures: -1}
+	dead := newMockDeadLetters()
+	f := NewFanOut(dead, &Target{Name: "failing", Client: client})
+	f.base = time.Hour
+	f.max = time.Hour
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err := f.Publish(ctx, model.NewMessage("dummy-test-user", model.UserAdd))
+	// the wait would pass the deadline, so the fan out gives up at once, without dead lettering
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	assert.True(t, time.Since(start) < 50*time.Millisecond)
+	assert.Equal(t, 1, client.attempts)
+	assert.Equal(t, 0, len(dead.letters))
+}
+
+func TestBackoff(t *testing.T) {
+	f := NewFanOut(newMockDeadLetters())
+	for attempt := 1; attempt < 40; attempt++ {
+		ceiling := DefaultMaxBackoff
+		if attempt < 6 {
+			ceiling = DefaultBaseBackoff << uint(attempt-1)
+		}
+		wait := f.backoff(attempt)
+		assert.True(t, wait >= 0 && wait <= ceiling, "attempt %d waited %v", attempt, wait)
+	}
+}
+
+func TestReplay(t *testing.T) {
+	tests := []struct {
+		name         string
+		target       string
+		failures     int
+		expectedErr  error
+		expectedKept bool
+	}{
+		{
+			name:     "replayed",
+			target:   "flaky",
+			failures: 1,
+		}, {
+			name:         "still failing",
+			target:       "flaky",
+			failures:     -1,
+			expectedErr:  ErrReplayFailed,
+			expectedKept: true,
+		}, {
+			name:         "unknown target",
+			target:       "removed",
+			expectedErr:  ErrUnknownTarget,
+			expectedKept: true,
+		},
+	}
+	for _, test := range tests {
+		tt := test
+		t.Run(tt.name, func(t *testing.T) {
+			client := &flakyClient{failures: tt.failures}
+			dead := newMockDeadLetters()
+			f := newTestFanOut(dead, &Target{Name: "flaky", Client: client})
+			letter := &model.DeadLetter{Id: "letter", Target: tt.target, Message: model.NewMessage("dummy-test-user", model.UserAdd)}
+			assert.Nil(t, dead.AddDeadLetter(context.Background(), letter))
+
+			err := f.Replay(context.Background(), "letter")
+			if tt.expectedErr == nil {
+				assert.Nil(t, err)
+				assert.Equal(t, 1, client.published)
+			} else {
+				assert.True(t, errors.Is(err, tt.expectedErr), err)
+			}
+			_, err = dead.GetDeadLetter(context.Background(), "letter")
+			assert.Equal(t, tt.expectedKept, err == nil)
+		})
+	}
+
+	f := newTestFanOut(newMockDeadLetters())
+	assert.NotNil(t, f.Replay(context.Background(), "missing"))
+}
+
+// closingClient records whether it was closed, failing to close if given an error
+type closingClient struct {
+	flakyClient
+	err    error
+	closed bool
+}
+
+func (c *closingClient) Close() error {
+	c.closed = true
+	return c.err
+}
+
+func TestFanOutClose(t *testing.T) {
+	first := &closingClient{}
+	second := &closingClient{err: errors.New("unable to flush")}
+	third := &closingClient{err: errors.New("unable to drain")}
+	f := newTestFanOut(newMockDeadLetters(),
+		&Target{Name: "first", Client: first},
+		&Target{Name: "plain", Client: &flakyClient{}},
+		&Target{Name: "second", Client: second},
+		&Target{Name: "third", Client: third},
+	)
+
+	err := f.Close()
+	assert.EqualError(t, err, "unable to flush")
+	assert.True(t, first.closed)
+	assert.True(t, second.closed)
+	assert.True(t, third.closed)
+}
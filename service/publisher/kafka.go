@@ -0,0 +1,62 @@
+package publisher
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"faceit/model"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// KafkaClient publishes messages to a Kafka topic. Messages are keyed by user Id, so every change
+// to a user lands on the same partition and is consumed in order
+type KafkaClient struct {
+	writer *kafka.Writer
+}
+
+// NewKafkaClient instantiates a producer for the topic on the given brokers
+func NewKafkaClient(brokers []string, topic string) *KafkaClient {
+	return &KafkaClient{
+		writer: &kafka.Writer{
+			Addr:         kafka.TCP(brokers...),
+			Topic:        topic,
+			Balancer:     &kafka.Hash{},
+			RequiredAcks: kafka.RequireAll,
+			// the relay publishes one message at a time, so waiting to fill a batch only adds latency
+			BatchTimeout: 10 * time.Millisecond,
+		},
+	}
+}
+
+// Publish publishes a message structure to the configured topic as a CloudEvent
+func (pub *KafkaClient) Publish(ctx context.Context, m *model.Message) error {
+	msg, err := newKafkaMessage(m)
+	if err != nil {
+		return err
+	}
+	return pub.writer.WriteMessages(ctx, msg)
+}
+
+// Close flushes and closes the producer
+func (pub *KafkaClient) Close() error {
+	return pub.writer.Close()
+}
+
+// newKafkaMessage converts a message to a Kafka record in the CloudEvents structured mode
+func newKafkaMessage(m *model.Message) (kafka.Message, error) {
+	event := NewCloudEvent(m)
+	value, err := json.Marshal(event)
+	if err != nil {
+		return kafka.Message{}, err
+	}
+	return kafka.Message{
+		Key:   []byte(m.Id),
+		Value: value,
+		Headers: []kafka.Header{
+			{Key: "content-type", Value: []byte(ContentType)},
+			{Key: AttributeEventType, Value: []byte(event.Type)},
+		},
+	}, nil
+}
@@ -0,0 +1,29 @@
+package publisher
+
+import (
+	"encoding/json"
+	"testing"
+
+	"faceit/model"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewKafkaMessage(t *testing.T) {
+	msg := model.NewChangeMessage(model.UserAdd, nil, &model.User{Id: "dummy-test-user", Country: "FRA"}, false)
+	record, err := newKafkaMessage(msg)
+	assert.Nil(t, err)
+
+	// the key keeps the changes of a user on one partition
+	assert.Equal(t, []byte("dummy-test-user"), record.Key)
+	headers := map[string]string{}
+	for _, header := range record.Headers {
+		headers[header.Key] = string(header.Value)
+	}
+	assert.Equal(t, map[string]string{"content-type": ContentType, AttributeEventType: TypeUserCreated}, headers)
+
+	event := &CloudEvent{}
+	assert.Nil(t, json.Unmarshal(record.Value, event))
+	assert.Equal(t, msg.EventId, event.Id)
+	assert.Equal(t, "FRA", event.Data.After.Country)
+}
@@ -0,0 +1,100 @@
+package publisher
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"faceit/model"
+
+	"github.com/nats-io/nats.go"
+)
+
+// NATSClient publishes messages to NATS, on a subject per event type under a common prefix, e.g.
+// faceit.users.created. With a stream name the messages are published to JetStream and acknowledged,
+// the event Id letting the server discard duplicates
+type NATSClient struct {
+	conn   *nats.Conn
+	js     nats.JetStreamContext
+	prefix string
+	closed chan struct{}
+}
+
+// natsDrainTimeout bounds how long closing waits for buffered messages to be flushed, after which
+// the connection is closed regardless
+const natsDrainTimeout = 10 * time.Second
+
+// NewNATSClient connects to a NATS server. If stream is not empty, a JetStream stream of that name
+// is created for the subjects if it does not already exist
+func NewNATSClient(url, prefix, stream string) (*NATSClient, error) {
+	closed := make(chan struct{})
+	conn, err := nats.Connect(url,
+		nats.Name("faceit-users"),
+		nats.MaxReconnects(-1),
+		nats.DrainTimeout(natsDrainTimeout),
+		nats.ClosedHandler(func(*nats.Conn) { close(closed) }),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to nats: %w", err)
+	}
+	client := &NATSClient{
+		conn:   conn,
+		prefix: prefix,
+		closed: closed,
+	}
+	if stream == "" {
+		return client, nil
+	}
+	client.js, err = conn.JetStream()
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("unable to use jetstream: %w", err)
+	}
+	_, err = client.js.StreamInfo(stream)
+	if err != nil {
+		_, err = client.js.AddStream(&nats.StreamConfig{
+			Name:     stream,
+			Subjects: []string{prefix + ".>"},
+		})
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("unable to create stream %s: %w", stream, err)
+		}
+	}
+	return client, nil
+}
+
+// Publish publishes a message structure as a CloudEvent, waiting for the acknowledgement of
+// JetStream if it is used
+func (pub *NATSClient) Publish(ctx context.Context, m *model.Message) error {
+	event := NewCloudEvent(m)
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	msg := nats.NewMsg(pub.Subject(event.Type))
+	msg.Data = data
+	msg.Header.Set("Content-Type", ContentType)
+	if pub.js == nil {
+		return pub.conn.PublishMsg(msg)
+	}
+	_, err = pub.js.PublishMsg(msg, nats.MsgId(event.Id), nats.Context(ctx))
+	return err
+}
+
+// Subject returns the subject events of the given type are published on
+func (pub *NATSClient) Subject(eventType string) string {
+	return pub.prefix + "." + strings.TrimPrefix(eventType, "com.faceit.user.")
+}
+
+// Close drains the connection, returning once buffered messages are flushed and it is closed. Drain
+// only starts the process, so the wait is on the closed handler, which the drain timeout guarantees
+func (pub *NATSClient) Close() error {
+	if err := pub.conn.Drain(); err != nil {
+		return err
+	}
+	<-pub.closed
+	return nil
+}
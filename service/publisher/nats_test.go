@@ -0,0 +1,110 @@
+package publisher
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"faceit/model"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+)
+
+// runNATSServer starts an embedded server with JetStream enabled on a random port, and returns a
+// function to stop it
+func runNATSServer(t *testing.T) (*server.Server, func()) {
+	dir, err := ioutil.TempDir("", "nats")
+	assert.Nil(t, err)
+	s, err := server.NewServer(&server.Options{
+		Host:      "127.0.0.1",
+		Port:      -1,
+		JetStream: true,
+		StoreDir:  dir,
+	})
+	assert.Nil(t, err)
+	go s.Start()
+	if !s.ReadyForConnections(5 * time.Second) {
+		t.Fatal("nats server did not start")
+	}
+	return s, func() {
+		s.Shutdown()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestNATSPublish(t *testing.T) {
+	s, stop := runNATSServer(t)
+	defer stop()
+	pub, err := NewNATSClient(s.ClientURL(), "faceit.users", "")
+	assert.Nil(t, err)
+	defer pub.Close()
+
+	conn, err := nats.Connect(s.ClientURL())
+	assert.Nil(t, err)
+	defer conn.Close()
+	sub, err := conn.SubscribeSync("faceit.users.deleted")
+	assert.Nil(t, err)
+	assert.Nil(t, conn.Flush())
+
+	user := &model.User{Id: "dummy-test-user", Version: 2}
+	assert.Nil(t, pub.Publish(context.Background(), model.NewChangeMessage(model.UserUpdate, user, user, false)))
+	assert.Nil(t, pub.Publish(context.Background(), model.NewChangeMessage(model.UserDelete, user, nil, false)))
+
+	// only the deletion is on the subscribed subject
+	msg, err := sub.NextMsg(time.Second)
+	assert.Nil(t, err)
+	assert.Equal(t, ContentType, msg.Header.Get("Content-Type"))
+	event := &CloudEvent{}
+	assert.Nil(t, json.Unmarshal(msg.Data, event))
+	assert.Equal(t, TypeUserDeleted, event.Type)
+	assert.Equal(t, "3", event.Sequence)
+}
+
+func TestNATSJetStreamPublish(t *testing.T) {
+	s, stop := runNATSServer(t)
+	defer stop()
+	pub, err := NewNATSClient(s.ClientURL(), "faceit.users", "USERS")
+	assert.Nil(t, err)
+	defer pub.Close()
+
+	// connecting again reuses the existing stream
+	again, err := NewNATSClient(s.ClientURL(), "faceit.users", "USERS")
+	assert.Nil(t, err)
+	defer again.Close()
+
+	msg := model.NewChangeMessage(model.UserAdd, nil, &model.User{Id: "dummy-test-user"}, false)
+	assert.Nil(t, pub.Publish(context.Background(), msg))
+	// a redelivered message is discarded by the stream
+	assert.Nil(t, again.Publish(context.Background(), msg))
+	assert.Nil(t, pub.Publish(context.Background(), model.NewChangeMessage(model.UserAdd, nil, &model.User{Id: "other"}, false)))
+
+	info, err := pub.js.StreamInfo("USERS")
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(2), info.State.Msgs)
+}
+
+func TestNATSClose(t *testing.T) {
+	s, stop := runNATSServer(t)
+	defer stop()
+	pub, err := NewNATSClient(s.ClientURL(), "faceit.users", "")
+	assert.Nil(t, err)
+
+	conn, err := nats.Connect(s.ClientURL())
+	assert.Nil(t, err)
+	defer conn.Close()
+	sub, err := conn.SubscribeSync("faceit.users.created")
+	assert.Nil(t, err)
+	assert.Nil(t, conn.Flush())
+
+	// a message buffered before closing is flushed by the time close returns
+	assert.Nil(t, pub.Publish(context.Background(), model.NewChangeMessage(model.UserAdd, nil, &model.User{Id: "dummy-test-user"}, false)))
+	assert.Nil(t, pub.Close())
+	assert.True(t, pub.conn.IsClosed())
+	_, err = sub.NextMsg(time.Second)
+	assert.Nil(t, err)
+}
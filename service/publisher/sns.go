@@ -6,9 +6,16 @@ import (
 	"faceit/model"
 
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/credentials"
-	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/aws/client"
 	"github.com/aws/aws-sdk-go/service/sns"
+	"go.opentelemetry.io/otel"
+)
+
+const (
+	// AttributeEventType is the message attribute holding the event type, for subscription filter policies
+	AttributeEventType = "eventType"
+	// AttributeCountry is the message attribute holding the country of the user
+	AttributeCountry = "country"
 )
 
 // SNSClient is a small extension of the AWS SNS client to allow for specific behaviour
@@ -17,34 +24,92 @@ type SNSClient struct {
 	topicArn *string
 }
 
-// NewSNSClient instantiates a new SNS client for publishing messages to a user topic
-// This is configured for the local environment used in testing only.
-func NewSNSClient() *SNSClient {
-	client := &SNSClient{
-		topicArn: aws.String("arn:aws:sns:eu-west-1:000000000000:messages_sns"), // very hardcoded
+// NewSNSClient instantiates a new SNS client for publishing messages to a user topic, using the
+// region, endpoint and credentials of the given AWS session
+func NewSNSClient(sess client.ConfigProvider, topicArn string) *SNSClient {
+	return &SNSClient{
+		client:   sns.New(sess),
+		topicArn: aws.String(topicArn),
 	}
-	client.client = sns.New(session.Must(session.NewSession(aws.NewConfig().
-		WithRegion("eu-west-1").
-		WithEndpoint("http://localstack:4566"). // Hardcoded for simplicity in task
-		WithDisableEndpointHostPrefix(true).
-		WithDisableSSL(true).
-		WithCredentials(credentials.NewStaticCredentials("dummy", "dummy", "dummy")),
-	)))
-	return client
 }
 
-// Publish publishes a message structure to the configured SNS topic
+// Check reads the attributes of the topic, failing if SNS is unreachable or the topic is missing
+func (pub *SNSClient) Check(ctx context.Context) error {
+	_, err := pub.client.GetTopicAttributesWithContext(ctx, &sns.GetTopicAttributesInput{
+		TopicArn: pub.topicArn,
+	})
+	return err
+}
+
+// Publish publishes a message structure to the configured SNS topic as a CloudEvent, with the event
+// type and country as message attributes so subscriptions can filter on them. The W3C trace context
+// of the publish is added as the traceparent and tracestate attributes, so consumers continue the trace
 func (pub *SNSClient) Publish(ctx context.Context, m *model.Message) error {
-	msg, err := json.Marshal(m)
+	event := NewCloudEvent(m)
+	msg, err := json.Marshal(event)
 	if err != nil {
 		return err
 	}
+	attributes := messageAttributes(event)
+	otel.GetTextMapPropagator().Inject(ctx, attributeCarrier(attributes))
 	_, err = pub.client.PublishWithContext(ctx, &sns.PublishInput{
-		Message:  aws.String(string(msg)),
-		TopicArn: pub.topicArn,
+		Message:           aws.String(string(msg)),
+		MessageAttributes: attributes,
+		TopicArn:          pub.topicArn,
 	})
 	if err != nil {
 		return err
 	}
 	return nil
 }
+
+// messageAttributes lists the SNS attributes of an event. SNS rejects empty values, so an unknown
+// country is left out
+func messageAttributes(event *CloudEvent) map[string]*sns.MessageAttributeValue {
+	attributes := map[string]*sns.MessageAttributeValue{
+		AttributeEventType: {
+			DataType:    aws.String("String"),
+			StringValue: aws.String(event.Type),
+		},
+	}
+	country := Country(event.Data)
+	if country != "" {
+		attributes[AttributeCountry] = &sns.MessageAttributeValue{
+			DataType:    aws.String("String"),
+			StringValue: aws.String(country),
+		}
+	}
+	return attributes
+}
+
+// attributeCarrier carries a trace context in the string attributes of an SNS message
+type attributeCarrier map[string]*sns.MessageAttributeValue
+
+// Get returns the value of a string attribute
+func (c attributeCarrier) Get(key string) string {
+	value, ok := c[key]
+	if !ok || value.StringValue == nil {
+		return ""
+	}
+	return *value.StringValue
+}
+
+// Set sets a string attribute, leaving out empty values which SNS rejects
+func (c attributeCarrier) Set(key, value string) {
+	if value == "" {
+		return
+	}
+	c[key] = &sns.MessageAttributeValue{
+		DataType:    aws.String("String"),
+		StringValue: aws.String(value),
+	}
+}
+
+// Keys lists the attributes
+func (c attributeCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for key := range c {
+		keys = append(keys, key)
+	}
+	return keys
+}
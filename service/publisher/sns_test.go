@@ -0,0 +1,55 @@
+package publisher
+
+import (
+	"context"
+	"testing"
+
+	"faceit/model"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestAttributeCarrier(t *testing.T) {
+	user := &model.User{Id: "dummy-test-user", Country: "DNK"}
+	parent := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID{0x4b, 0xf9, 0x2f, 0x35, 0x77, 0xb3, 0x4d, 0xa6, 0xa3, 0xce, 0x92, 0x9d, 0x0e, 0x0e, 0x47, 0x36},
+		SpanID:     trace.SpanID{0x00, 0xf0, 0x67, 0xaa, 0x0b, 0xa9, 0x02, 0xb7},
+		TraceFlags: trace.FlagsSampled,
+	})
+	tests := []struct {
+		name     string
+		ctx      context.Context
+		expected string
+	}{
+		{
+			name:     "traced",
+			ctx:      trace.ContextWithSpanContext(context.Background(), parent),
+			expected: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		}, {
+			name:     "untraced",
+			ctx:      context.Background(),
+			expected: "",
+		},
+	}
+	for _, test := range tests {
+		tt := test
+		t.Run(tt.name, func(t *testing.T) {
+			attributes := messageAttributes(NewCloudEvent(model.NewChangeMessage(model.UserAdd, user, nil, false)))
+			propagation.TraceContext{}.Inject(tt.ctx, attributeCarrier(attributes))
+			if tt.expected == "" {
+				assert.NotContains(t, attributes, "traceparent")
+				return
+			}
+			assert.Equal(t, tt.expected, *attributes["traceparent"].StringValue)
+			assert.Equal(t, "String", *attributes["traceparent"].DataType)
+			assert.Equal(t, "DNK", *attributes[AttributeCountry].StringValue)
+
+			// consumers read the parent back from the attributes
+			extracted := propagation.TraceContext{}.Extract(context.Background(), attributeCarrier(attributes))
+			assert.Equal(t, parent.TraceID(), trace.SpanContextFromContext(extracted).TraceID())
+			assert.Equal(t, parent.SpanID(), trace.SpanContextFromContext(extracted).SpanID())
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package publisher
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	"faceit/model"
+)
+
+const (
+	// SignatureHeader carries the HMAC-SHA256 of the timestamp and body of a webhook, signed with the shared secret
+	SignatureHeader = "X-Faceit-Signature"
+	// TimestampHeader carries the unix time a webhook was sent, so receivers can reject replayed requests
+	TimestampHeader = "X-Faceit-Timestamp"
+	// webhookTimeout bounds each request when the context has no deadline
+	webhookTimeout = 10 * time.Second
+)
+
+// WebhookClient publishes messages by POSTing them as CloudEvents to a list of URLs. Each request is
+// signed, so receivers can check it came from the service
+type WebhookClient struct {
+	client *http.Client
+	urls   []string
+	secret []byte
+}
+
+// NewWebhookClient instantiates a publisher to the given URLs, signing requests with the secret
+func NewWebhookClient(urls []string, secret string) *WebhookClient {
+	return &WebhookClient{
+		client: &http.Client{Timeout: webhookTimeout},
+		urls:   urls,
+		secret: []byte(secret),
+	}
+}
+
+// Publish posts a message structure to every URL. It fails if any URL does not accept it, in which
+// case the message is delivered again to all of them
+func (pub *WebhookClient) Publish(ctx context.Context, m *model.Message) error {
+	body, err := json.Marshal(NewCloudEvent(m))
+	if err != nil {
+		return err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	signature := Sign(pub.secret, timestamp, body)
+	for _, url := range pub.urls {
+		err = pub.post(ctx, url, timestamp, signature, body)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// post sends a signed event to a single URL
+func (pub *WebhookClient) post(ctx context.Context, url, timestamp, signature string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", ContentType)
+	req.Header.Set(TimestampHeader, timestamp)
+	req.Header.Set(SignatureHeader, signature)
+	res, err := pub.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	// drain the body so the connection can be reused
+	_, _ = io.Copy(ioutil.Discard, res.Body)
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("webhook %s responded %d", url, res.StatusCode)
+	}
+	return nil
+}
+
+// Sign returns the signature of a webhook, the hex HMAC-SHA256 of the timestamp, a dot and the body
+func Sign(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,70 @@
+package publisher
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"faceit/model"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWebhookPublish(t *testing.T) {
+	tests := []struct {
+		name        string
+		status      int
+		expectedErr bool
+	}{
+		{
+			name:        "accepted",
+			status:      http.StatusAccepted,
+			expectedErr: false,
+		}, {
+			name:        "rejected",
+			status:      http.StatusServiceUnavailable,
+			expectedErr: true,
+		},
+	}
+	for _, test := range tests {
+		tt := test
+		t.Run(tt.name, func(t *testing.T) {
+			received := []*CloudEvent{}
+			sink := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, err := ioutil.ReadAll(r.Body)
+				assert.Nil(t, err)
+				assert.Equal(t, ContentType, r.Header.Get("Content-Type"))
+				expected := Sign([]byte("secret"), r.Header.Get(TimestampHeader), body)
+				assert.Equal(t, expected, r.Header.Get(SignatureHeader))
+				event := &CloudEvent{}
+				assert.Nil(t, json.Unmarshal(body, event))
+				received = append(received, event)
+				w.WriteHeader(tt.status)
+			}))
+			defer sink.Close()
+
+			pub := NewWebhookClient([]string{sink.URL + "/first", sink.URL + "/second"}, "secret")
+			msg := model.NewChangeMessage(model.UserAdd, nil, &model.User{Id: "dummy-test-user"}, false)
+			err := pub.Publish(context.Background(), msg)
+			assert.Equal(t, tt.expectedErr, err != nil)
+			if tt.expectedErr {
+				// the first failure stops the delivery
+				assert.Equal(t, 1, len(received))
+				return
+			}
+			assert.Equal(t, 2, len(received))
+			assert.Equal(t, TypeUserCreated, received[0].Type)
+			assert.Equal(t, msg.EventId, received[1].Id)
+		})
+	}
+}
+
+func TestSign(t *testing.T) {
+	signature := Sign([]byte("secret"), "1609459200", []byte(`{}`))
+	assert.Equal(t, signature, Sign([]byte("secret"), "1609459200", []byte(`{}`)))
+	assert.NotEqual(t, signature, Sign([]byte("other"), "1609459200", []byte(`{}`)))
+	assert.NotEqual(t, signature, Sign([]byte("secret"), "1609459201", []byte(`{}`)))
+}
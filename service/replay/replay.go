@@ -0,0 +1,166 @@
+package replay
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"faceit/model"
+
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
+)
+
+const (
+	// DefaultRate is the number of events emitted per second when a replay does not set a rate
+	DefaultRate = 100
+	// MaxRate is the highest rate a replay may request
+	MaxRate = 10000
+	// PageSize is the number of users read from storage at a time
+	PageSize = 100
+	// Retention is how long the status of a finished replay is kept
+	Retention = 24 * time.Hour
+)
+
+// Lister is the storage the users to replay are read from
+type Lister interface {
+	Filter(ctx context.Context, conditions []*model.FilterCondition, page *model.Page) ([]*model.User, string, error)
+	GetAll(ctx context.Context, page *model.Page) ([]*model.User, string, error)
+}
+
+// MsgClient is the messaging operation snapshots are emitted through
+type MsgClient interface {
+	Publish(ctx context.Context, msg *model.Message) error
+}
+
+// Replayer emits a UserSnapshot event for every user matching a filter, so consumers can rebuild
+// their projections. Replays run in the background and their status is kept in memory, so it is
+// lost when the service restarts, and forgotten once finished for longer than the retention
+type Replayer struct {
+	ctx  context.Context
+	db   Lister
+	msg  MsgClient
+	mu   sync.Mutex
+	jobs map[string]*model.ReplayJob
+	wg   sync.WaitGroup
+	now  func() time.Time
+}
+
+// NewReplayer instantiates a replayer. Running replays stop when the context is cancelled
+func NewReplayer(ctx context.Context, db Lister, msg MsgClient) *Replayer {
+	return &Replayer{
+		ctx:  ctx,
+		db:   db,
+		msg:  msg,
+		jobs: map[string]*model.ReplayJob{},
+		now:  time.Now,
+	}
+}
+
+// Start begins a replay of the users matching the conditions, or all users if there are none, at
+// the given number of events per second. The filter is only recorded in the status of the job
+func (r *Replayer) Start(conditions []*model.FilterCondition, filter map[string]string, perSecond float64) *model.ReplayJob {
+	job := &model.ReplayJob{
+		Id:      uuid.New().String(),
+		Status:  model.JobRunning,
+		Filter:  filter,
+		Rate:    perSecond,
+		Started: r.now().UTC(),
+	}
+	r.mu.Lock()
+	r.evict()
+	r.jobs[job.Id] = job
+	status := *job
+	r.mu.Unlock()
+
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		err := r.run(job, conditions, rate.NewLimiter(rate.Limit(perSecond), 1))
+		r.finish(job, err)
+	}()
+	return &status
+}
+
+// Job returns the status of a replay
+func (r *Replayer) Job(id string) (*model.ReplayJob, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.evict()
+	job, ok := r.jobs[id]
+	if !ok {
+		return nil, false
+	}
+	status := *job
+	return &status, true
+}
+
+// evict forgets replays finished for longer than the retention. The caller must hold the lock
+func (r *Replayer) evict() {
+	cutoff := r.now().Add(-Retention)
+	for id, job := range r.jobs {
+		if job.Finished != nil && job.Finished.Before(cutoff) {
+			delete(r.jobs, id)
+		}
+	}
+}
+
+// Wait blocks until every running replay has finished
+func (r *Replayer) Wait() {
+	r.wg.Wait()
+}
+
+// run pages through the users, emitting a snapshot of each
+func (r *Replayer) run(job *model.ReplayJob, conditions []*model.FilterCondition, limiter *rate.Limiter) error {
+	page := &model.Page{Limit: PageSize}
+	for {
+		users, next, err := r.list(conditions, page)
+		if err != nil {
+			return err
+		}
+		for _, user := range users {
+			err = limiter.Wait(r.ctx)
+			if err != nil {
+				return err
+			}
+			err = r.msg.Publish(r.ctx, model.NewSnapshotMessage(user))
+			if err != nil {
+				return err
+			}
+			r.mu.Lock()
+			job.Emitted++
+			r.mu.Unlock()
+		}
+		if next == "" {
+			return nil
+		}
+		page.Cursor = next
+	}
+}
+
+func (r *Replayer) list(conditions []*model.FilterCondition, page *model.Page) ([]*model.User, string, error) {
+	if len(conditions) == 0 {
+		return r.db.GetAll(r.ctx, page)
+	}
+	return r.db.Filter(r.ctx, conditions, page)
+}
+
+// finish records the outcome of a replay
+func (r *Replayer) finish(job *model.ReplayJob, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	finished := r.now().UTC()
+	job.Finished = &finished
+	job.Status = model.JobCompleted
+	if err != nil {
+		job.Status = model.JobFailed
+		job.Error = err.Error()
+	}
+	log.WithFields(log.Fields{
+		"job":     job.Id,
+		"status":  job.Status,
+		"emitted": job.Emitted,
+		"error":   err,
+	}).Info("replay finished")
+}
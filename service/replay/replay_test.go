@@ -0,0 +1,144 @@
+package replay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"faceit/model"
+	"faceit/service/dao"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type recordingMsgClient struct {
+	mu       sync.Mutex
+	messages []*model.Message
+	failAt   int
+}
+
+func (m *recordingMsgClient) Publish(ctx context.Context, msg *model.Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.failAt > 0 && len(m.messages)+1 == m.failAt {
+		return errors.New("unable to publish")
+	}
+	m.messages = append(m.messages, msg)
+	return nil
+}
+
+// newTestStore returns a memory store holding count users, every third of them Danish
+func newTestStore(t *testing.T, count int) *dao.MemoryClient {
+	db := dao.NewMemoryClient()
+	for i := 0; i < count; i++ {
+		country := "SWE"
+		if i%3 == 0 {
+			country = "DNK"
+		}
+		user := &model.User{Id: fmt.Sprintf("user-%03d", i), Nickname: fmt.Sprintf("player%d", i), Country: country}
+		assert.Nil(t, db.Insert(context.Background(), user, nil))
+	}
+	return db
+}
+
+func TestReplay(t *testing.T) {
+	tests := []struct {
+		name            string
+		conditions      []*model.FilterCondition
+		failAt          int
+		expectedStatus  string
+		expectedEmitted int
+	}{
+		{
+			name:            "all users",
+			expectedStatus:  model.JobCompleted,
+			expectedEmitted: 250,
+		}, {
+			name:            "filtered",
+			conditions:      []*model.FilterCondition{{Query: "country", Operator: model.OpEqual, Value: "DNK"}},
+			expectedStatus:  model.JobCompleted,
+			expectedEmitted: 84,
+		}, {
+			name:            "publish failure",
+			failAt:          120,
+			expectedStatus:  model.JobFailed,
+			expectedEmitted: 119,
+		},
+	}
+	for _, test := range tests {
+		tt := test
+		t.Run(tt.name, func(t *testing.T) {
+			msg := &recordingMsgClient{failAt: tt.failAt}
+			replayer := NewReplayer(context.Background(), newTestStore(t, 250), msg)
+
+			started := replayer.Start(tt.conditions, nil, MaxRate)
+			assert.Equal(t, model.JobRunning, started.Status)
+			replayer.Wait()
+
+			job, ok := replayer.Job(started.Id)
+			assert.True(t, ok)
+			assert.Equal(t, tt.expectedStatus, job.Status)
+			assert.Equal(t, tt.expectedEmitted, job.Emitted)
+			assert.Equal(t, tt.expectedEmitted, len(msg.messages))
+			assert.NotNil(t, job.Finished)
+			assert.Equal(t, tt.expectedStatus == model.JobFailed, job.Error != "")
+
+			// every user is emitted once, as it is stored
+			seen := map[string]bool{}
+			for _, m := range msg.messages {
+				assert.Equal(t, model.UserSnapshot, m.Action)
+				assert.Equal(t, m.Id, m.After.Id)
+				assert.Equal(t, int64(1), m.Version)
+				assert.False(t, seen[m.Id])
+				seen[m.Id] = true
+			}
+		})
+	}
+
+	_, ok := NewReplayer(context.Background(), dao.NewMemoryClient(), &recordingMsgClient{}).Job("missing")
+	assert.False(t, ok)
+}
+
+func TestReplayRate(t *testing.T) {
+	msg := &recordingMsgClient{}
+	replayer := NewReplayer(context.Background(), newTestStore(t, 6), msg)
+
+	start := time.Now()
+	replayer.Start(nil, nil, 50)
+	replayer.Wait()
+	// the first event is emitted at once, the other five 20ms apart
+	assert.True(t, time.Since(start) >= 90*time.Millisecond, time.Since(start))
+	assert.Equal(t, 6, len(msg.messages))
+}
+
+func TestReplayCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	replayer := NewReplayer(ctx, newTestStore(t, 10), &recordingMsgClient{})
+
+	started := replayer.Start(nil, nil, 1)
+	cancel()
+	replayer.Wait()
+	job, ok := replayer.Job(started.Id)
+	assert.True(t, ok)
+	assert.Equal(t, model.JobFailed, job.Status)
+	assert.True(t, job.Emitted < 10)
+}
+
+func TestReplayRetention(t *testing.T) {
+	now := time.Now()
+	replayer := NewReplayer(context.Background(), newTestStore(t, 2), &recordingMsgClient{})
+	replayer.now = func() time.Time { return now }
+
+	finished := replayer.Start(nil, nil, MaxRate)
+	replayer.Wait()
+	now = now.Add(Retention - time.Minute)
+	_, ok := replayer.Job(finished.Id)
+	assert.True(t, ok, "kept within the retention")
+
+	now = now.Add(2 * time.Minute)
+	_, ok = replayer.Job(finished.Id)
+	assert.False(t, ok, "forgotten after the retention")
+}
@@ -0,0 +1,164 @@
+package tracing
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"faceit/model"
+	"faceit/service/dao"
+	"faceit/service/handlers"
+	"faceit/service/outbox"
+)
+
+const (
+	// DynamoSystem, SQLiteSystem, PostgresSystem and MemorySystem are the db.system of each storage
+	DynamoSystem   = "dynamodb"
+	SQLiteSystem   = "sqlite"
+	PostgresSystem = "postgresql"
+	MemorySystem   = "memory"
+)
+
+// Storage is a user store which also holds the outbox, as used by the handlers, relay and replays
+type Storage interface {
+	handlers.DAOClient
+	outbox.Store
+}
+
+// DAO decorates a storage, tracing each operation as a child span of the caller. Messages written
+// with a change carry the trace context of the write, so their publishing continues the trace
+type DAO struct {
+	db     Storage
+	users  []attribute.KeyValue
+	outbox []attribute.KeyValue
+}
+
+// NewDAO traces a storage of the given system, with the tables of its users and outbox as attributes
+func NewDAO(db Storage, system, usersTable, outboxTable string) *DAO {
+	return &DAO{
+		db:     db,
+		users:  tableAttributes(system, usersTable),
+		outbox: tableAttributes(system, outboxTable),
+	}
+}
+
+// tableAttributes returns the attributes of a storage and table, following the semantic conventions
+func tableAttributes(system, table string) []attribute.KeyValue {
+	attributes := []attribute.KeyValue{semconv.DBSystemKey.String(system)}
+	switch system {
+	case DynamoSystem:
+		attributes = append(attributes, semconv.AWSDynamoDBTableNamesKey.StringSlice([]string{table}))
+	case MemorySystem:
+	default:
+		attributes = append(attributes, semconv.DBSQLTableKey.String(table))
+	}
+	return attributes
+}
+
+// start starts the span of an operation on a table
+func (d *DAO) start(ctx context.Context, operation string, table []attribute.KeyValue) (context.Context, trace.Span) {
+	return tracer().Start(ctx, "dao."+operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(table...),
+		trace.WithAttributes(semconv.DBOperationKey.String(operation)),
+	)
+}
+
+// startOutbox starts the span of an outbox operation within a trace. The relay polls the outbox
+// without one, and a root span for every pass would bury the traces of requests
+func (d *DAO) startOutbox(ctx context.Context, operation string) (context.Context, trace.Span) {
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		return ctx, trace.SpanFromContext(ctx)
+	}
+	return d.start(ctx, operation, d.outbox)
+}
+
+// inject records the trace context of a write on the message written with it
+func inject(ctx context.Context, msg *model.Message) {
+	if msg == nil {
+		return
+	}
+	carrier := mapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+	if len(carrier) > 0 {
+		msg.TraceContext = map[string]string(carrier)
+	}
+}
+
+// Get traces the storage Get
+func (d *DAO) Get(ctx context.Context, id string) (*model.User, error) {
+	ctx, span := d.start(ctx, "Get", d.users)
+	user, err := d.db.Get(ctx, id)
+	endSpan(span, err)
+	return user, err
+}
+
+// Insert traces the storage Insert
+func (d *DAO) Insert(ctx context.Context, user *model.User, msg *model.Message) error {
+	ctx, span := d.start(ctx, "Insert", d.users)
+	inject(ctx, msg)
+	err := d.db.Insert(ctx, user, msg)
+	endSpan(span, err)
+	return err
+}
+
+// Update traces the storage Update
+func (d *DAO) Update(ctx context.Context, user *model.User, fields []string, msg *model.Message) error {
+	ctx, span := d.start(ctx, "Update", d.users)
+	inject(ctx, msg)
+	err := d.db.Update(ctx, user, fields, msg)
+	endSpan(span, err)
+	return err
+}
+
+// Delete traces the storage Delete
+func (d *DAO) Delete(ctx context.Context, userId string, version int64, msg *model.Message) error {
+	ctx, span := d.start(ctx, "Delete", d.users)
+	inject(ctx, msg)
+	err := d.db.Delete(ctx, userId, version, msg)
+	endSpan(span, err)
+	return err
+}
+
+// Filter traces the storage Filter
+func (d *DAO) Filter(ctx context.Context, conditions []*model.FilterCondition, page *model.Page) ([]*model.User, string, error) {
+	ctx, span := d.start(ctx, "Filter", d.users)
+	users, cursor, err := d.db.Filter(ctx, conditions, page)
+	endSpan(span, err)
+	return users, cursor, err
+}
+
+// GetAll traces the storage GetAll
+func (d *DAO) GetAll(ctx context.Context, page *model.Page) ([]*model.User, string, error) {
+	ctx, span := d.start(ctx, "GetAll", d.users)
+	users, cursor, err := d.db.GetAll(ctx, page)
+	endSpan(span, err)
+	return users, cursor, err
+}
+
+// Pending traces the outbox Pending
+func (d *DAO) Pending(ctx context.Context, limit int) ([]*dao.OutboxEntry, error) {
+	ctx, span := d.startOutbox(ctx, "Pending")
+	entries, err := d.db.Pending(ctx, limit)
+	endSpan(span, err)
+	return entries, err
+}
+
+// MarkDelivered traces the outbox MarkDelivered
+func (d *DAO) MarkDelivered(ctx context.Context, id string) error {
+	ctx, span := d.startOutbox(ctx, "MarkDelivered")
+	err := d.db.MarkDelivered(ctx, id)
+	endSpan(span, err)
+	return err
+}
+
+// MarkFailed traces the outbox MarkFailed
+func (d *DAO) MarkFailed(ctx context.Context, id string, cause error) error {
+	ctx, span := d.startOutbox(ctx, "MarkFailed")
+	err := d.db.MarkFailed(ctx, id, cause)
+	endSpan(span, err)
+	return err
+}
@@ -0,0 +1,57 @@
+package tracing
+
+import (
+	"context"
+	"io"
+
+	"go.opentelemetry.io/otel"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"faceit/model"
+	"faceit/service/publisher"
+)
+
+// Publisher decorates a publisher, tracing each attempt to publish as a producer span
+type Publisher struct {
+	target      string
+	destination string
+	client      publisher.MsgClient
+}
+
+// NewPublisher traces the publisher of a fan out target, with its topic, subject or urls as the destination
+func NewPublisher(target, destination string, client publisher.MsgClient) *Publisher {
+	return &Publisher{
+		target:      target,
+		destination: destination,
+		client:      client,
+	}
+}
+
+// Publish traces the publish. Messages published by the outbox relay have no span in their context,
+// so continue the trace of the request that wrote them
+func (p *Publisher) Publish(ctx context.Context, msg *model.Message) error {
+	if !trace.SpanContextFromContext(ctx).IsValid() && len(msg.TraceContext) > 0 {
+		ctx = otel.GetTextMapPropagator().Extract(ctx, mapCarrier(msg.TraceContext))
+	}
+	ctx, span := tracer().Start(ctx, p.target+" send",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			semconv.MessagingSystemKey.String(p.target),
+			semconv.MessagingDestinationKey.String(p.destination),
+			semconv.MessagingDestinationKindTopic,
+			semconv.MessagingMessageIDKey.String(msg.EventId),
+		),
+	)
+	err := p.client.Publish(ctx, msg)
+	endSpan(span, err)
+	return err
+}
+
+// Close closes the publisher if it holds connections, so the fan out can still close it once traced
+func (p *Publisher) Close() error {
+	if closer, ok := p.client.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+// Package tracing exports OpenTelemetry traces of the service, and traces the storage and publishers
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// NoExporter records no spans, trace context is still propagated
+	NoExporter = "none"
+	// StdoutExporter writes spans to stdout, for local runs
+	StdoutExporter = "stdout"
+	// OTLPExporter sends spans to an OpenTelemetry collector over OTLP/HTTP
+	OTLPExporter = "otlp"
+
+	// TracerName names the tracer of the spans started by the service
+	TracerName = "faceit"
+)
+
+// Options configures the export of traces
+type Options struct {
+	Service  string
+	Version  string
+	Exporter string
+	// Endpoint is the url of the OTLP/HTTP collector, e.g. http://localhost:4318, an http scheme disables TLS
+	Endpoint string
+	// SampleRatio is the fraction of new traces recorded, traces continued from a caller follow its decision
+	SampleRatio float64
+}
+
+// Setup installs the global W3C trace context propagator and a tracer provider exporting to the
+// configured exporter. The returned func flushes the spans still buffered and stops the exporter
+func Setup(ctx context.Context, opts Options) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	exporter, err := newExporter(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	if exporter == nil {
+		return func(context.Context) error { return nil }, nil
+	}
+	res, err := resource.New(ctx, resource.WithAttributes(
+		semconv.ServiceNameKey.String(opts.Service),
+		semconv.ServiceVersionKey.String(opts.Version),
+	))
+	if err != nil {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(opts.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// newExporter creates the configured exporter, nil if spans are not exported
+func newExporter(ctx context.Context, opts Options) (sdktrace.SpanExporter, error) {
+	switch opts.Exporter {
+	case NoExporter:
+		return nil, nil
+	case StdoutExporter:
+		exporter, err := stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+		if err != nil {
+			return nil, err
+		}
+		return exporter, nil
+	case OTLPExporter:
+		endpoint, err := url.Parse(opts.Endpoint)
+		if err != nil {
+			return nil, fmt.Errorf("invalid otlp endpoint: %v", err)
+		}
+		options := []otlptracehttp.Option{otlptracehttp.WithEndpoint(endpoint.Host)}
+		if endpoint.Scheme == "http" {
+			options = append(options, otlptracehttp.WithInsecure())
+		}
+		if endpoint.Path != "" && endpoint.Path != "/" {
+			options = append(options, otlptracehttp.WithURLPath(endpoint.Path))
+		}
+		exporter, err := otlptracehttp.New(ctx, options...)
+		if err != nil {
+			return nil, err
+		}
+		return exporter, nil
+	default:
+		return nil, fmt.Errorf("unknown trace exporter: %s", opts.Exporter)
+	}
+}
+
+// tracer returns the tracer of the service from the global provider, so spans started before
+// Setup is called still go to the installed provider
+func tracer() trace.Tracer {
+	return otel.Tracer(TracerName)
+}
+
+// endSpan records the error of a failed operation on its span before ending it
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// mapCarrier carries a trace context in the map kept with an outbox message
+type mapCarrier map[string]string
+
+// Get returns the value of a key
+func (c mapCarrier) Get(key string) string {
+	return c[key]
+}
+
+// Set sets the value of a key
+func (c mapCarrier) Set(key, value string) {
+	c[key] = value
+}
+
+// Keys lists the keys carried
+func (c mapCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for key := range c {
+		keys = append(keys, key)
+	}
+	return keys
+}
@@ -0,0 +1,167 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+
+	"faceit/model"
+	"faceit/service/dao"
+)
+
+// record installs a tracer provider recording every span, with the W3C propagator
+func record() *tracetest.SpanRecorder {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	return recorder
+}
+
+// attributes maps the attributes of a span by key
+func attributes(span sdktrace.ReadOnlySpan) map[attribute.Key]attribute.Value {
+	values := map[attribute.Key]attribute.Value{}
+	for _, kv := range span.Attributes() {
+		values[kv.Key] = kv.Value
+	}
+	return values
+}
+
+func TestSetup(t *testing.T) {
+	tests := []struct {
+		name     string
+		exporter string
+		endpoint string
+		err      string
+	}{
+		{name: "none", exporter: NoExporter},
+		{name: "stdout", exporter: StdoutExporter},
+		{name: "otlp", exporter: OTLPExporter, endpoint: "http://localhost:4318"},
+		{name: "otlp with path", exporter: OTLPExporter, endpoint: "https://collector.example.com/otlp/v1/traces"},
+		{name: "invalid endpoint", exporter: OTLPExporter, endpoint: "http://local host", err: "invalid otlp endpoint"},
+		{name: "unknown", exporter: "zipkin", err: "unknown trace exporter: zipkin"},
+	}
+	for _, test := range tests {
+		tt := test
+		t.Run(tt.name, func(t *testing.T) {
+			shutdown, err := Setup(context.Background(), Options{
+				Service:     "faceit",
+				Version:     "test",
+				Exporter:    tt.exporter,
+				Endpoint:    tt.endpoint,
+				SampleRatio: 1,
+			})
+			if tt.err != "" {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), tt.err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.NoError(t, shutdown(context.Background()))
+		})
+	}
+}
+
+func TestDAO(t *testing.T) {
+	recorder := record()
+	db := NewDAO(dao.NewMemoryClient(), DynamoSystem, "users", "outbox")
+	ctx, parent := otel.Tracer("test").Start(context.Background(), "request")
+
+	user := &model.User{Id: "traced", Nickname: "s1mple"}
+	msg := model.NewMessage(user.Id, model.UserAdd)
+	assert.NoError(t, db.Insert(ctx, user, msg))
+	_, err := db.Get(ctx, "missing")
+	assert.Equal(t, dao.ErrNotFound, err)
+	_, err = db.Pending(ctx, 10)
+	assert.NoError(t, err)
+	parent.End()
+	// the relay polling the outbox outside a trace records nothing
+	_, err = db.Pending(context.Background(), 10)
+	assert.NoError(t, err)
+
+	spans := recorder.Ended()
+	assert.Len(t, spans, 4)
+	insert, get, pending := spans[0], spans[1], spans[2]
+	for _, span := range []sdktrace.ReadOnlySpan{insert, get, pending} {
+		assert.Equal(t, parent.SpanContext().SpanID(), span.Parent().SpanID())
+		assert.Equal(t, trace.SpanKindClient, span.SpanKind())
+		assert.Equal(t, DynamoSystem, attributes(span)["db.system"].AsString())
+	}
+	assert.Equal(t, "dao.Insert", insert.Name())
+	assert.Equal(t, []string{"users"}, attributes(insert)["aws.dynamodb.table_names"].AsStringSlice())
+	assert.Equal(t, "Insert", attributes(insert)["db.operation"].AsString())
+	assert.Equal(t, codes.Unset, insert.Status().Code)
+	assert.Equal(t, codes.Error, get.Status().Code)
+	assert.Equal(t, dao.ErrNotFound.Error(), get.Status().Description)
+	assert.Equal(t, []string{"outbox"}, attributes(pending)["aws.dynamodb.table_names"].AsStringSlice())
+
+	// the message continues the trace from the write
+	extracted := otel.GetTextMapPropagator().Extract(context.Background(), mapCarrier(msg.TraceContext))
+	assert.Equal(t, insert.SpanContext().SpanID(), trace.SpanContextFromContext(extracted).SpanID())
+}
+
+func TestTableAttributes(t *testing.T) {
+	assert.Equal(t, []attribute.KeyValue{
+		attribute.String("db.system", PostgresSystem),
+		attribute.String("db.sql.table", "users"),
+	}, tableAttributes(PostgresSystem, "users"))
+	assert.Equal(t, []attribute.KeyValue{attribute.String("db.system", MemorySystem)}, tableAttributes(MemorySystem, "users"))
+}
+
+// mockClient fails every publish if given an error, recording whether it was closed
+type mockClient struct {
+	err    error
+	closed bool
+}
+
+func (c *mockClient) Publish(ctx context.Context, msg *model.Message) error {
+	return c.err
+}
+
+func (c *mockClient) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestPublisher(t *testing.T) {
+	recorder := record()
+	client := &mockClient{}
+	sns := NewPublisher("sns", "arn:aws:sns:eu-west-1:000000000000:users", client)
+	webhook := NewPublisher("webhook", "http://localhost:3001/events", &mockClient{err: errors.New("unable to post")})
+
+	// the relay publishes without a span, continuing the trace kept with the message
+	_, write := otel.Tracer("test").Start(context.Background(), "dao.Insert")
+	msg := model.NewMessage("publisher", model.UserAdd)
+	msg.TraceContext = map[string]string{}
+	otel.GetTextMapPropagator().Inject(trace.ContextWithSpan(context.Background(), write), mapCarrier(msg.TraceContext))
+	write.End()
+	assert.NoError(t, sns.Publish(context.Background(), msg))
+
+	// a span in the context takes precedence
+	ctx, replay := otel.Tracer("test").Start(context.Background(), "replay")
+	assert.EqualError(t, webhook.Publish(ctx, msg), "unable to post")
+	replay.End()
+
+	spans := recorder.Ended()
+	assert.Len(t, spans, 4)
+	published, failed := spans[1], spans[2]
+	assert.Equal(t, "sns send", published.Name())
+	assert.Equal(t, trace.SpanKindProducer, published.SpanKind())
+	assert.Equal(t, write.SpanContext().SpanID(), published.Parent().SpanID())
+	assert.Equal(t, "sns", attributes(published)["messaging.system"].AsString())
+	assert.Equal(t, "arn:aws:sns:eu-west-1:000000000000:users", attributes(published)["messaging.destination"].AsString())
+	assert.Equal(t, msg.EventId, attributes(published)["messaging.message_id"].AsString())
+	assert.Equal(t, replay.SpanContext().SpanID(), failed.Parent().SpanID())
+	assert.Equal(t, codes.Error, failed.Status().Code)
+
+	assert.NoError(t, sns.Close())
+	assert.True(t, client.closed)
+}
@@ -0,0 +1,20 @@
+#!/bin/sh
+# Prints a development bearer token, valid for an hour, signed with the key in testdata/auth and
+# accepted by the default configuration. Never configure the service with this key outside local runs
+# usage: ./token.sh [subject] [roles], roles comma separated, e.g. ./token.sh testing user
+SUBJECT=${1:-testing}
+ROLES=$(printf '%s' "${2:-admin}" | sed 's/[^,][^,]*/"&"/g')
+KEY=testdata/auth/dev-rs256.pem
+ISSUER=https://auth.faceit.local/
+AUDIENCE=faceit-users
+
+b64url() {
+  openssl base64 -A | tr '+/' '-_' | tr -d '='
+}
+
+NOW=$(date +%s)
+HEADER=$(printf '{"alg":"RS256","typ":"JWT","kid":"faceit-dev"}' | b64url)
+PAYLOAD=$(printf '{"iss":"%s","aud":"%s","sub":"%s","roles":[%s],"iat":%d,"exp":%d}' \
+  "$ISSUER" "$AUDIENCE" "$SUBJECT" "$ROLES" "$NOW" $((NOW + 3600)) | b64url)
+SIGNATURE=$(printf '%s.%s' "$HEADER" "$PAYLOAD" | openssl dgst -sha256 -sign "$KEY" -binary | b64url)
+echo "$HEADER.$PAYLOAD.$SIGNATURE"